@@ -0,0 +1,168 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// corpusSize is the size of the generated benchmark inputs, large enough to
+// span many buffers of the reader but quick to generate and compress.
+const corpusSize = 1 << 20
+
+// corpus is a generated input and its compressed form.
+type corpus struct {
+	name       string
+	text       []byte
+	compressed []byte
+}
+
+var (
+	corporaOnce sync.Once
+	corpora     []corpus
+	corporaErr  error
+)
+
+// xorshift fills b with the deterministic output of a xorshift generator.
+func xorshift(b []byte) {
+	x := uint32(2463534242)
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = byte(x)
+	}
+}
+
+// compressibleText returns n bytes of the words of loremText in a
+// deterministic pseudorandom order, which compresses like natural text rather
+// than like a repeated string.
+func compressibleText(n int) []byte {
+	words := strings.Fields(loremText)
+	picks := make([]byte, n)
+	xorshift(picks)
+	b := make([]byte, 0, n+32)
+	for i := 0; len(b) < n; i++ {
+		b = append(b, words[int(picks[i])%len(words)]...)
+		b = append(b, ' ')
+	}
+	return b[:n]
+}
+
+// benchCorpora returns the compressible and incompressible inputs of the
+// benchmarks, generated and compressed once with preset 1, which is quick to encode.
+func benchCorpora(tb testing.TB) []corpus {
+	corporaOnce.Do(func() {
+		incompressible := make([]byte, corpusSize)
+		xorshift(incompressible)
+		for _, c := range []corpus{
+			{name: "compressible", text: compressibleText(corpusSize)},
+			{name: "incompressible", text: incompressible},
+		} {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, 1)
+			if err == nil {
+				_, err = w.Write(c.text)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			if err != nil {
+				corporaErr = err
+				return
+			}
+			c.compressed = buf.Bytes()
+			corpora = append(corpora, c)
+		}
+	})
+	if corporaErr != nil {
+		tb.Fatal(corporaErr)
+	}
+	return corpora
+}
+
+func TestBenchCorpora(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates and compresses the benchmark inputs")
+	}
+	for _, c := range benchCorpora(t) {
+		got, err := Decompress(c.compressed)
+		if err != nil {
+			t.Fatalf("%s: Decompress() error = %v", c.name, err)
+		}
+		if !bytes.Equal(got, c.text) {
+			t.Errorf("%s: Decompress() got %d bytes differing from %d bytes", c.name, len(got), len(c.text))
+		}
+	}
+	if !bytes.Equal(compressibleText(1000), compressibleText(1000)) {
+		t.Error("compressibleText() is not deterministic")
+	}
+}
+
+func BenchmarkReader_Read(b *testing.B) {
+	for _, c := range benchCorpora(b) {
+		for _, size := range []int{512, 4 << 10, 32 << 10, 256 << 10} {
+			b.Run(c.name+"/"+sizeName(size), func(b *testing.B) {
+				buf := make([]byte, size)
+				b.SetBytes(int64(len(c.text)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					xr := NewReader(bytes.NewReader(c.compressed))
+					for {
+						_, err := xr.Read(buf)
+						if err == io.EOF {
+							break
+						}
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkReader_Copy measures io.Copy from the reader, which has no WriteTo
+// method and so decodes into the buffers io.Discard reads into.
+func BenchmarkReader_Copy(b *testing.B) {
+	for _, c := range benchCorpora(b) {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(c.text)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := io.Copy(io.Discard, NewReader(bytes.NewReader(c.compressed))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, c := range benchCorpora(b) {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(c.text)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Decompress(c.compressed); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// sizeName formats a buffer size in bytes or KiB for a benchmark name.
+func sizeName(size int) string {
+	if size < 1<<10 {
+		return strconv.Itoa(size) + "B"
+	}
+	return strconv.Itoa(size>>10) + "KiB"
+}
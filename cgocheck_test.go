@@ -0,0 +1,104 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+//go:build goexperiment.cgocheck2
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+// TestCgoCheck2 exercises every path that passes memory to liblzma so that a
+// build with GOEXPERIMENT=cgocheck2 faults if Go pointers are passed unpinned
+// or stored where the garbage collector cannot see them.
+func TestCgoCheck2(t *testing.T) {
+	newReaders := []struct {
+		name      string
+		newReader func([]byte) *Reader
+	}{
+		{name: "NewReader", newReader: func(b []byte) *Reader { return NewReader(bytes.NewReader(b)) }},
+		{
+			name:      "NewReader streamed",
+			newReader: func(b []byte) *Reader { return NewReader(struct{ io.Reader }{bytes.NewReader(b)}) },
+		},
+		{name: "NewBytesReader", newReader: func(b []byte) *Reader { return NewBytesReader(b) }},
+		{name: "NewVerifyingReader", newReader: func(b []byte) *Reader { return NewVerifyingReader(bytes.NewReader(b)) }},
+	}
+	for _, nr := range newReaders {
+		t.Run(
+			nr.name, func(t *testing.T) {
+				for _, tt := range readerTests {
+					xr := nr.newReader(decodeBase64(t, tt.base64Input))
+					_, _ = io.ReadAll(xr)
+					_ = xr.Close()
+				}
+			},
+		)
+	}
+
+	input := bytes.Repeat([]byte(loremText), 1000)
+	newWriters := []struct {
+		name      string
+		newWriter func(io.Writer) (io.WriteCloser, error)
+	}{
+		{
+			name: "NewWriter",
+			newWriter: func(dst io.Writer) (io.WriteCloser, error) {
+				return NewWriter(dst, 1, WithBlockSize(1<<16))
+			},
+		},
+		{
+			name: "NewWriterThreads",
+			newWriter: func(dst io.Writer) (io.WriteCloser, error) {
+				return NewWriterThreads(dst, 1, 2, WithBlockSize(1<<16))
+			},
+		},
+		{
+			name: "NewLZMAWriter",
+			newWriter: func(dst io.Writer) (io.WriteCloser, error) {
+				return NewLZMAWriter(dst, 1), nil
+			},
+		},
+	}
+	for _, nw := range newWriters {
+		t.Run(
+			nw.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := nw.newWriter(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(input); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				xr := NewReader(bytes.NewReader(buf.Bytes()))
+				if nw.name == "NewLZMAWriter" {
+					xr = NewLZMAReader(bytes.NewReader(buf.Bytes()))
+				}
+				if _, err := io.ReadAll(xr); err != nil {
+					t.Fatal(err)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"Index", func(t *testing.T) {
+			compressed, err := lzma.EasyBufferEncode(1, lzma.CheckCRC64, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := UncompressedSize(bytes.NewReader(compressed)); err != nil {
+				t.Fatal(err)
+			}
+		},
+	)
+}
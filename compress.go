@@ -0,0 +1,59 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"io"
+
+	"dill.foo/xz/lzma"
+)
+
+// CompressToSize compresses src to a single XZ stream of at most maxOut bytes,
+// for fitting data into a fixed-size slot. Presets are tried from 0 up to 9,
+// returning the output of the smallest that fits and true, or false if none
+// fit. As the higher presets need far more memory and time, the first is
+// usually all that is tried. An error of the encoder is returned as is.
+func CompressToSize(src []byte, maxOut int) ([]byte, bool, error) {
+	for preset := uint32(0); preset <= 9; preset++ {
+		out, err := lzma.EasyBufferEncode(preset, lzma.CheckCRC64, src)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(out) <= maxOut {
+			return out, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Transcode decodes the xz compressed data read from src and compresses it
+// again to dst as a single XZ stream using the compression level preset and the
+// integrity check, such as to normalize archives to one setting. The data is
+// passed between the decoder and the encoder through a single buffer. If
+// decoding fails the new stream is left incomplete, so that the output cannot
+// be mistaken for a complete stream, and the error is returned.
+func Transcode(dst io.Writer, src io.Reader, preset uint32, check lzma.Check) error {
+	w, err := NewWriter(dst, preset, WithCheck(check))
+	if err != nil {
+		return err
+	}
+	xr := NewReader(src)
+	buf := make([]byte, defaultBufferSize)
+	for {
+		n, err := xr.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				_ = xr.Close()
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return w.Close()
+		}
+		if err != nil {
+			_ = w.Abort()
+			return err
+		}
+	}
+}
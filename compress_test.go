@@ -0,0 +1,83 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestCompressToSize(t *testing.T) {
+	compressible := bytes.Repeat([]byte(loremText), 100)
+	const maxOut = 1024
+	for preset := uint32(0); preset <= 9; preset++ {
+		out, err := lzma.EasyBufferEncode(preset, lzma.CheckCRC64, compressible)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) > maxOut {
+			t.Fatalf("preset %d compressed to %d bytes, want at most %d", preset, len(out), maxOut)
+		}
+	}
+	out, ok, err := CompressToSize(compressible, maxOut)
+	if err != nil || !ok || len(out) > maxOut {
+		t.Fatalf("CompressToSize() = %d bytes, %v, %v, want at most %d, true", len(out), ok, err, maxOut)
+	}
+	// The smallest preset that fits is used.
+	if want, _ := lzma.EasyBufferEncode(0, lzma.CheckCRC64, compressible); !bytes.Equal(out, want) {
+		t.Errorf("CompressToSize() = %d bytes, want the %d bytes of preset 0", len(out), len(want))
+	}
+	got, err := Decompress(out)
+	if err != nil {
+		t.Fatalf("Decompress() error = %v", err)
+	}
+	if !bytes.Equal(got, compressible) {
+		t.Error("Decompress() does not match the input")
+	}
+
+	incompressible := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(incompressible)
+	if out, ok, err := CompressToSize(incompressible, 64); ok || out != nil || err != nil {
+		t.Errorf("CompressToSize() = %d bytes, %v, %v, want nil, false", len(out), ok, err)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	// good-1-check-crc32.xz
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=")
+	var buf bytes.Buffer
+	if err := Transcode(&buf, bytes.NewReader(input), 9, lzma.CheckCRC64); err != nil {
+		t.Fatalf("Transcode() error = %v", err)
+	}
+	var checks []lzma.Check
+	got, err := io.ReadAll(NewReader(&buf, WithStreamCallback(func(s StreamInfo) { checks = append(checks, s.Check) })))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != "Hello\nWorld!\n" {
+		t.Errorf("Read() got = '%v', want %v", string(got), "Hello\nWorld!\n")
+	}
+	if len(checks) != 1 || checks[0] != lzma.CheckCRC64 {
+		t.Errorf("Read() checks = %v, want [%v]", checks, lzma.CheckCRC64)
+	}
+
+	buf.Reset()
+	err = Transcode(&buf, bytes.NewReader(input[:len(input)-1]), 9, lzma.CheckCRC64)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Transcode() truncated error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := io.ReadAll(NewReader(&buf)); err == nil {
+		t.Error("Read() of the output of a failed Transcode() expected error")
+	}
+
+	werr := errors.New("write failed")
+	if err := Transcode(errWriter{werr}, bytes.NewReader(input), 9, lzma.CheckCRC64); err != werr {
+		t.Errorf("Transcode() error = %v, want %v", err, werr)
+	}
+}
@@ -0,0 +1,823 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math"
+
+	"dill.foo/xz/lzma"
+)
+
+type decoderSeq int
+
+const (
+	seqStreamHeader decoderSeq = iota
+	seqBlockHeader
+	seqBlock
+	seqIndex
+	seqStreamFooter
+	seqStreamPadding
+	seqResync
+)
+
+// indexIndicator is the first byte of an Index field, where a block header
+// would otherwise begin.
+const indexIndicator = 0x00
+
+// streamMagic are the Header Magic Bytes that begin every stream.
+var streamMagic = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+
+// xzDecoder decodes the .xz container format in Go, using liblzma to decode the
+// data of each block and to verify the Index. It behaves like the liblzma
+// stream decoder but can observe the structure of the input as it is decoded.
+type xzDecoder struct {
+	in, out []byte
+
+	seq   decoderSeq
+	buf   [lzma.BlockHeaderSizeMax]byte
+	pos   int
+	need  int
+	flags lzma.StreamFlags
+
+	block       *lzma.Block
+	blockStream *lzma.Stream
+	index       *lzma.IndexHash
+
+	// held is the memory acquired from limiter for the block decoder.
+	limiter *MemLimiter
+	held    uint64
+
+	memlimit             uint64
+	concatenated         bool
+	tellNoCheck          bool
+	tellUnsupportedCheck bool
+	tellAnyCheck         bool
+	ignoreCheck          bool
+	firstStream          bool
+	allowBufError        bool
+	// allowTrailingGarbage ends decoding without error when the input after a
+	// stream is neither Stream Padding nor another stream.
+	allowTrailingGarbage bool
+	// rejectTrailingData fails a decoder that is not concatenated with
+	// ErrTrailingData if the input after its stream is not Stream Padding.
+	rejectTrailingData bool
+
+	// verify recomputes the check of every block from its decoded output.
+	verify bool
+	// recompute records the check recomputed for every block in computed,
+	// without verifying it unless verify is also set.
+	recompute bool
+	computed  [][]byte
+	hash      hash.Hash
+	// lastCheck is the integrity check stored in the last block decoded.
+	lastCheck []byte
+	// chunks follows the LZMA2 chunks of the block being decoded.
+	chunks lzma2Chunks
+	// verifySize compares the uncompressed size of every stream with its
+	// Index, which is kept in indexBuf as it is decoded.
+	verifySize bool
+	indexBuf   []byte
+
+	// onBlock is called with each block once it has been decoded, onStream
+	// with each stream.
+	onBlock  func(BlockInfo)
+	onStream func(StreamInfo)
+	// report accumulates every block and stream.
+	report *Report
+	// log is called with the lifecycle events of the decoder.
+	log func(event string, kv ...any)
+	// onSkip is called with the input skipped after a corrupt stream, if
+	// corrupt streams are skipped. skipStart is the offset of the input to skip.
+	onSkip    func(offset, size int64)
+	skipStart uint64
+	// inBase is the offset in the input of the next input at the start of the
+	// current call to Code, inLen its length.
+	inBase       uint64
+	inLen        int
+	outTotal     uint64
+	outLen       int
+	blockOffset  uint64
+	uncompressed uint64
+	stream       StreamInfo
+	// streamEnd is the offset in the input just after the footer of the last
+	// stream decoded, if streamEnded.
+	streamEnd   uint64
+	streamEnded bool
+	// pauseAtStreamEnd makes Code return at the end of every stream, setting
+	// paused, so that the output of one stream is not followed by the next.
+	pauseAtStreamEnd bool
+	paused           bool
+	// blocks counts the blocks started across all streams.
+	blocks int
+	// streams counts the streams started, up to maxStreams if nonzero.
+	streams    int
+	maxStreams int
+
+	// err describes the cause of the last error Return when it is more
+	// specific than the code alone.
+	err error
+}
+
+func newXZDecoder(memlimit uint64, flags ...lzma.DecoderOpt) *xzDecoder {
+	d := &xzDecoder{
+		block:       lzma.NewBlock(),
+		blockStream: lzma.NewStream(),
+		index:       lzma.NewIndexHash(),
+		memlimit:    memlimit,
+		firstStream: true,
+	}
+	for _, flag := range flags {
+		switch flag {
+		case lzma.TellNoCheck:
+			d.tellNoCheck = true
+		case lzma.TellUnsupportedCheck:
+			d.tellUnsupportedCheck = true
+		case lzma.TellAnyCheck:
+			d.tellAnyCheck = true
+		case lzma.Concatenated:
+			d.concatenated = true
+		case lzma.IgnoreCheck:
+			d.ignoreCheck = true
+		}
+	}
+	return d
+}
+
+func (d *xzDecoder) SetNextIn(in []byte) {
+	d.in = in
+}
+
+func (d *xzDecoder) AvailableIn() int {
+	return len(d.in)
+}
+
+func (d *xzDecoder) SetNextOut(out []byte) {
+	d.out = out
+}
+
+func (d *xzDecoder) AvailableOut() int {
+	return len(d.out)
+}
+
+func (d *xzDecoder) unpause() bool {
+	paused := d.paused
+	d.paused = false
+	return paused
+}
+
+// TotalIn is the total input consumed by Code.
+func (d *xzDecoder) TotalIn() uint64 {
+	return d.inBase
+}
+
+// TotalOut is the total output produced by Code.
+func (d *xzDecoder) TotalOut() uint64 {
+	return d.outTotal
+}
+
+// Check returns the integrity check of the stream being decoded.
+func (d *xzDecoder) Check() lzma.Check {
+	return d.flags.Check
+}
+
+// Code decodes from the next input to the next output like lzma.Stream.Code.
+func (d *xzDecoder) Code(action lzma.Action) lzma.Return {
+	inLen, outLen := len(d.in), len(d.out)
+	d.inLen, d.outLen = inLen, outLen
+	ret := d.code(action)
+	// Too many streams is not corruption to skip past.
+	for ret == lzma.DataError && d.onSkip != nil && d.err != ErrTooManyStreams {
+		d.startResync()
+		ret = d.code(action)
+	}
+	d.inBase += uint64(inLen - len(d.in))
+	d.outTotal += uint64(outLen - len(d.out))
+	// Like lzma_code, only report BufError when two consecutive calls make no
+	// progress in case the output was just full.
+	if ret == lzma.Ok && len(d.in) == inLen && len(d.out) == outLen {
+		if d.allowBufError {
+			return lzma.BufError
+		}
+		d.allowBufError = true
+	} else {
+		d.allowBufError = false
+	}
+	return ret
+}
+
+func (d *xzDecoder) code(action lzma.Action) lzma.Return {
+	for {
+		switch d.seq {
+		case seqStreamHeader:
+			if d.pos == 0 && len(d.in) > 0 {
+				d.stream = StreamInfo{
+					Offset:             int64(d.inOffset()),
+					UncompressedOffset: int64(d.uncompressed),
+				}
+			}
+			if !d.fill(lzma.StreamHeaderSize) {
+				if action == lzma.Finish && d.trailingGarbage() {
+					return lzma.StreamEnd
+				}
+				// A truncated stream header is skipped like a corrupt one.
+				if action == lzma.Finish && d.onSkip != nil && !d.firstStream && len(d.in) == 0 {
+					return lzma.DataError
+				}
+				return lzma.Ok
+			}
+			flags, ret := lzma.DecodeStreamHeader(d.buf[:lzma.StreamHeaderSize])
+			if ret != lzma.Ok {
+				if d.trailingGarbage() {
+					return lzma.StreamEnd
+				}
+				// Only the first stream is used to detect the file format.
+				if ret == lzma.FormatError && !d.firstStream {
+					return lzma.DataError
+				}
+				return ret
+			}
+			d.streams++
+			if d.maxStreams > 0 && d.streams > d.maxStreams {
+				d.err = ErrTooManyStreams
+				return lzma.DataError
+			}
+			d.firstStream = false
+			d.flags = flags
+			if d.log != nil {
+				d.log("stream start", "offset", d.stream.Offset, "check", flags.Check)
+			}
+			d.index.Reset()
+			d.indexBuf = d.indexBuf[:0]
+			d.seq = seqBlockHeader
+			if d.tellNoCheck && flags.Check == lzma.CheckNone {
+				return lzma.NoCheck
+			}
+			if d.tellUnsupportedCheck && !lzma.CheckIsSupported(flags.Check) {
+				return lzma.UnsupportedCheck
+			}
+			if d.tellAnyCheck {
+				return lzma.GetCheck
+			}
+		case seqBlockHeader:
+			if len(d.in) == 0 {
+				return lzma.Ok
+			}
+			if d.pos == 0 {
+				if d.in[0] == indexIndicator {
+					d.seq = seqIndex
+					continue
+				}
+				d.blockOffset = d.inOffset()
+				d.need = lzma.BlockHeaderSize(d.in[0])
+			}
+			if !d.fill(d.need) {
+				return lzma.Ok
+			}
+			if ret := d.initBlock(d.buf[:d.need]); ret != lzma.Ok {
+				return ret
+			}
+			d.seq = seqBlock
+		case seqBlock:
+			d.blockStream.SetNextIn(d.in)
+			d.blockStream.SetNextOut(d.out)
+			ret := d.blockStream.Code(lzma.Run)
+			consumed := len(d.in) - d.blockStream.AvailableIn()
+			produced := len(d.out) - d.blockStream.AvailableOut()
+			if d.hash != nil {
+				d.hash.Write(d.out[:produced])
+			}
+			d.chunks.walk(d.in[:consumed])
+			d.in, d.out = d.in[consumed:], d.out[produced:]
+			switch ret {
+			case lzma.StreamEnd:
+			case lzma.Ok, lzma.BufError:
+				// Progress is accounted for by Code.
+				return lzma.Ok
+			default:
+				if ret == lzma.DataError && d.chunks.invalid {
+					d.err = errLZMA2Control
+				} else if ret == lzma.DataError && d.blockSizeMismatch() {
+					d.err = errBlockSize
+				}
+				return ret
+			}
+			if ret := d.endBlock(); ret != lzma.Ok {
+				return ret
+			}
+			d.seq = seqBlockHeader
+		case seqIndex:
+			if len(d.in) == 0 {
+				return lzma.Ok
+			}
+			n, ret := d.index.Decode(d.in)
+			if d.verifySize {
+				d.indexBuf = append(d.indexBuf, d.in[:n]...)
+			}
+			d.in = d.in[n:]
+			if ret == lzma.DataError {
+				d.err = ErrIndex
+			}
+			if (ret == lzma.DataError || ret == lzma.StreamEnd) && d.verifySize && d.sizeMismatch() {
+				d.err = errIndexSize
+				return lzma.DataError
+			}
+			if ret != lzma.StreamEnd {
+				return ret
+			}
+			d.seq = seqStreamFooter
+		case seqStreamFooter:
+			if !d.fill(lzma.StreamHeaderSize) {
+				return lzma.Ok
+			}
+			footer, ret := lzma.DecodeStreamFooter(d.buf[:lzma.StreamHeaderSize])
+			if ret != lzma.Ok {
+				if ret == lzma.FormatError {
+					return lzma.DataError
+				}
+				return ret
+			}
+			if footer.BackwardSize != d.index.Size() {
+				d.err = ErrIndex
+				return lzma.DataError
+			}
+			if ret := d.flags.Compare(footer); ret != lzma.Ok {
+				d.err = ErrStreamFlagsMismatch
+				return ret
+			}
+			d.streamEnd, d.streamEnded = d.inOffset(), true
+			if d.onStream != nil || d.log != nil || d.report != nil {
+				d.stream.Size = int64(d.inOffset()) - d.stream.Offset
+				d.stream.UncompressedSize = int64(d.uncompressed) - d.stream.UncompressedOffset
+				d.stream.Check = d.flags.Check
+			}
+			if d.onStream != nil {
+				d.onStream(d.stream)
+			}
+			if d.report != nil {
+				d.report.addStream(d.stream)
+			}
+			if d.log != nil {
+				d.log(
+					"stream end", "offset", d.stream.Offset, "size", d.stream.Size,
+					"uncompressed_size", d.stream.UncompressedSize, "blocks", d.stream.BlockCount,
+				)
+			}
+			if !d.concatenated && !d.rejectTrailingData {
+				return lzma.StreamEnd
+			}
+			d.seq = seqStreamPadding
+			if d.pauseAtStreamEnd {
+				d.paused = true
+				return lzma.Ok
+			}
+		case seqStreamPadding:
+			for len(d.in) > 0 && d.in[0] == 0x00 {
+				d.in = d.in[1:]
+				d.pos = (d.pos + 1) & 3
+			}
+			if len(d.in) == 0 {
+				if action != lzma.Finish {
+					return lzma.Ok
+				}
+				if d.pos != 0 && !d.trailingGarbage() {
+					if !d.concatenated {
+						d.err = ErrTrailingData
+					}
+					return lzma.DataError
+				}
+				return lzma.StreamEnd
+			}
+			if !d.concatenated {
+				if d.trailingGarbage() {
+					return lzma.StreamEnd
+				}
+				d.err = ErrTrailingData
+				return lzma.DataError
+			}
+			// Stream padding must be a multiple of four bytes.
+			if d.pos != 0 {
+				if d.trailingGarbage() {
+					return lzma.StreamEnd
+				}
+				d.in = d.in[1:]
+				return lzma.DataError
+			}
+			d.seq = seqStreamHeader
+		case seqResync:
+			for len(d.in) > 0 && d.pos < len(streamMagic) {
+				switch {
+				case d.in[0] == streamMagic[d.pos]:
+					d.pos++
+				case d.in[0] == streamMagic[0]:
+					d.pos = 1
+				default:
+					d.pos = 0
+				}
+				d.in = d.in[1:]
+			}
+			if d.pos < len(streamMagic) {
+				if action == lzma.Finish && len(d.in) == 0 {
+					d.onSkip(int64(d.skipStart), int64(d.inOffset()-d.skipStart))
+					return lzma.StreamEnd
+				}
+				return lzma.Ok
+			}
+			// The magic bytes are either matched in the input or were already
+			// buffered along with the rest of the stream header.
+			copy(d.buf[:], streamMagic)
+			start := d.inOffset() - uint64(d.pos)
+			d.onSkip(int64(d.skipStart), int64(start-d.skipStart))
+			d.stream = StreamInfo{Offset: int64(start), UncompressedOffset: int64(d.uncompressed)}
+			d.seq = seqStreamHeader
+		}
+	}
+}
+
+// startResync skips the input from the start of a corrupt stream, or from the
+// end of the last stream if the corruption follows it, to the magic bytes of
+// the next stream. A stream header that failed to decode is searched for the
+// magic bytes first, as it may hold the start of the next stream.
+func (d *xzDecoder) startResync() {
+	var buffered []byte
+	if d.seq == seqStreamHeader {
+		buffered = d.buf[:lzma.StreamHeaderSize]
+		if d.pos > 0 {
+			buffered = d.buf[:d.pos]
+		}
+	}
+	d.skipStart = uint64(d.stream.Offset)
+	if d.streamEnded && d.streamEnd > d.skipStart {
+		d.skipStart = d.streamEnd
+	}
+	// Output of the corrupt stream already returned is not withdrawn.
+	d.uncompressed = d.outTotal + uint64(d.outLen-len(d.out))
+	d.err = nil
+	d.seq = seqResync
+	d.pos = 0
+	for i := 1; i < len(buffered); i++ {
+		rest := buffered[i:]
+		if bytes.HasPrefix(rest, streamMagic) || bytes.HasPrefix(streamMagic, rest) {
+			d.pos = copy(d.buf[:], rest)
+			return
+		}
+	}
+}
+
+// sizeMismatch reports whether the records of the Index decoded so far are
+// complete and their uncompressed sizes differ in total from the output of the
+// blocks of the stream.
+func (d *xzDecoder) sizeMismatch() bool {
+	size, ok := indexUncompressedSize(d.indexBuf)
+	return ok && size != d.uncompressed-uint64(d.stream.UncompressedOffset)
+}
+
+// indexUncompressedSize sums the Uncompressed Size of the records of an Index
+// field, returning false if the records are incomplete or malformed.
+func indexUncompressedSize(index []byte) (uint64, bool) {
+	if len(index) == 0 || index[0] != indexIndicator {
+		return 0, false
+	}
+	pos := 1
+	count, n, ret := lzma.VLIDecode(index[pos:])
+	if ret != lzma.Ok {
+		return 0, false
+	}
+	pos += n
+	var sum uint64
+	for i := uint64(0); i < 2*count; i++ {
+		vli, n, ret := lzma.VLIDecode(index[pos:])
+		if ret != lzma.Ok {
+			return 0, false
+		}
+		pos += n
+		// Records are pairs of Unpadded Size and Uncompressed Size.
+		if i%2 == 1 {
+			sum += vli
+		}
+	}
+	return sum, true
+}
+
+// trailingGarbage reports whether the input from the end of the last stream is
+// to be ignored rather than decoded as another stream.
+func (d *xzDecoder) trailingGarbage() bool {
+	return d.allowTrailingGarbage && !d.firstStream
+}
+
+// fill buffers input until n bytes are available in buf, returning false if
+// more input is needed.
+func (d *xzDecoder) fill(n int) bool {
+	c := copy(d.buf[d.pos:n], d.in)
+	d.in = d.in[c:]
+	d.pos += c
+	if d.pos < n {
+		return false
+	}
+	d.pos = 0
+	return true
+}
+
+func (d *xzDecoder) initBlock(header []byte) lzma.Return {
+	if ret := d.block.DecodeHeader(header, d.flags.Check); ret != lzma.Ok {
+		if !validHeaderVLIs(header) {
+			d.err = errVLI
+		}
+		return ret
+	}
+	d.block.SetIgnoreCheck(d.ignoreCheck)
+	memusage := d.block.DecoderMemUsage()
+	if memusage == math.MaxUint64 {
+		return lzma.OptionsError
+	}
+	if memusage > d.memlimit {
+		return lzma.MemLimitError
+	}
+	if !d.reserve(memusage, true) {
+		d.err = ErrMemLimiterExhausted
+		return lzma.MemLimitError
+	}
+	if ret := d.blockStream.InitBlockDecoder(d.block); ret != lzma.Ok {
+		return ret
+	}
+	if d.verify || d.recompute {
+		d.hash = newCheckHash(d.flags.Check)
+	}
+	d.chunks = lzma2Chunks{}
+	d.blocks++
+	return lzma.Ok
+}
+
+func (d *xzDecoder) endBlock() lzma.Return {
+	var sum []byte
+	if d.hash != nil {
+		sum = d.hash.Sum(nil)
+	}
+	if d.recompute {
+		d.computed = append(d.computed, sum)
+	}
+	if d.verify && sum != nil && !bytes.Equal(sum, d.block.RawCheck()) {
+		d.err = ErrCheckMismatch
+		return lzma.DataError
+	}
+	ret := d.index.Append(d.block.UnpaddedSize(), d.block.UncompressedSize())
+	if ret != lzma.Ok {
+		return ret
+	}
+	d.lastCheck = d.block.RawCheck()
+	if d.onBlock != nil || d.report != nil {
+		info := BlockInfo{
+			Offset:             int64(d.blockOffset),
+			UncompressedOffset: int64(d.uncompressed),
+			HeaderSize:         d.block.HeaderSize(),
+			CompressedSize:     int64(d.block.CompressedSize()),
+			UncompressedSize:   int64(d.block.UncompressedSize()),
+			TotalSize:          int64(d.block.TotalSize()),
+			Check:              d.block.Check(),
+		}
+		if d.onBlock != nil {
+			d.onBlock(info)
+		}
+		if d.report != nil {
+			d.report.addBlock(info, d.lastCheck)
+		}
+	}
+	if d.log != nil {
+		d.log(
+			"block decoded", "offset", int64(d.blockOffset), "size", int64(d.block.TotalSize()),
+			"uncompressed_size", int64(d.block.UncompressedSize()),
+		)
+		if d.checkVerified() {
+			d.log("check verified", "offset", int64(d.blockOffset), "check", d.block.Check())
+		}
+	}
+	d.uncompressed += d.block.UncompressedSize()
+	d.stream.BlockCount++
+	return lzma.Ok
+}
+
+// blockSizeMismatch reports whether the LZMA2 data of a block that failed to
+// decode did not end at the Uncompressed Size recorded in its header, either
+// continuing past it or ending before it.
+func (d *xzDecoder) blockSizeMismatch() bool {
+	size := d.block.UncompressedSize()
+	if size == lzma.VLIUnknown {
+		return false
+	}
+	produced := d.blockStream.TotalOut()
+	if d.chunks.ended {
+		return produced != size
+	}
+	return produced == size
+}
+
+// checkVerified reports whether the integrity check of a decoded block was
+// verified, either by liblzma or from the decoded output.
+func (d *xzDecoder) checkVerified() bool {
+	if d.verify && d.hash != nil {
+		return true
+	}
+	check := d.block.Check()
+	return check != lzma.CheckNone && !d.ignoreCheck && lzma.CheckIsSupported(check)
+}
+
+// lzma2Chunks follows the chunk headers of the LZMA2 data of a block as it is
+// decoded, so that a failure of the block decoder at an invalid control byte
+// can be told apart from other corruption. The LZMA2 filter is always last in
+// the filter chain so its data is the Compressed Data of the block.
+type lzma2Chunks struct {
+	// left is the rest of the current chunk following its header.
+	left int
+	// header buffers the header of the next chunk, n bytes of it.
+	header [6]byte
+	n      int
+	// ended is set at the end marker, invalid at an invalid control byte.
+	ended, invalid bool
+}
+
+// walk follows the chunks through the next data of the block.
+func (c *lzma2Chunks) walk(data []byte) {
+	for len(data) > 0 && !c.ended && !c.invalid {
+		if c.left > 0 {
+			n := min(c.left, len(data))
+			c.left -= n
+			data = data[n:]
+			continue
+		}
+		c.header[c.n] = data[0]
+		c.n++
+		data = data[1:]
+		control := c.header[0]
+		var size int
+		switch {
+		case control == 0x00:
+			c.ended = true
+			return
+		case control == 0x01 || control == 0x02:
+			// Uncompressed chunk.
+			if c.n < 3 {
+				continue
+			}
+			size = (int(c.header[1])<<8 | int(c.header[2])) + 1
+		case control >= 0x80:
+			// LZMA chunk, with properties from a state reset of at least new
+			// properties.
+			if c.n < 5 || (control >= 0xc0 && c.n < 6) {
+				continue
+			}
+			size = (int(c.header[3])<<8 | int(c.header[4])) + 1
+		default:
+			c.invalid = true
+			return
+		}
+		c.left, c.n = size, 0
+	}
+}
+
+// validHeaderVLIs reports whether the variable-length integers of a block
+// header with a valid CRC32 are encoded correctly. Other corruption, including
+// an invalid CRC32 or missing fields, is left for liblzma to report.
+func validHeaderVLIs(header []byte) bool {
+	body := header[:len(header)-4]
+	if lzma.CRC32(body, 0) != binary.LittleEndian.Uint32(header[len(header)-4:]) {
+		return true
+	}
+	flags, pos := body[1], 2
+	sizes := int(flags>>6&1) + int(flags>>7&1)
+	filters := int(flags&0x03) + 1
+	for i := 0; i < sizes+2*filters && pos < len(body); i++ {
+		vli, n, ret := lzma.VLIDecode(body[pos:])
+		if ret != lzma.Ok {
+			return false
+		}
+		pos += n
+		// Each filter ID is followed by the size of its properties, which are
+		// skipped.
+		if i >= sizes && (i-sizes)%2 == 1 {
+			if vli > uint64(len(body)-pos) {
+				return true
+			}
+			pos += int(vli)
+		}
+	}
+	return true
+}
+
+// inOffset is the offset in the input of the next input.
+func (d *xzDecoder) inOffset() uint64 {
+	return d.inBase + uint64(d.inLen-len(d.in))
+}
+
+// BlockInfo describes a block of a .xz file once it has been decoded.
+type BlockInfo struct {
+	Offset             int64 // offset of the block header in the input
+	UncompressedOffset int64 // offset of the block data in the output
+	HeaderSize         int   // size of the block header
+	CompressedSize     int64 // size of the Compressed Data field
+	UncompressedSize   int64 // size of the decoded data
+	TotalSize          int64 // size of the whole block including padding and check
+	Check              lzma.Check
+}
+
+// StreamInfo describes a stream of a .xz file once it has been decoded.
+type StreamInfo struct {
+	Offset             int64 // offset of the stream header in the input
+	UncompressedOffset int64 // offset of the stream data in the output
+	Size               int64 // size of the stream excluding Stream Padding
+	UncompressedSize   int64 // size of the decoded data
+	BlockCount         int64 // number of blocks
+	Check              lzma.Check
+}
+
+// preallocate initializes the block decoder for a LZMA2 block using the given
+// dictionary size so that the dictionary is allocated before any input is
+// decoded. liblzma reuses the dictionary for the first block if it needs the
+// same size, otherwise it is reallocated.
+func (d *xzDecoder) preallocate(dictSize uint32) {
+	if uint64(dictSize) > d.memlimit || !d.reserve(uint64(dictSize), false) {
+		return
+	}
+	d.block.SetLZMA2(dictSize)
+	_ = d.blockStream.InitBlockDecoder(d.block)
+}
+
+// reserve acquires memusage from the MemLimiter of the decoder, if any, in
+// addition to the memory already held, which liblzma may reuse for the next
+// block. It reports whether the decoder holds memusage.
+func (d *xzDecoder) reserve(memusage uint64, wait bool) bool {
+	if d.limiter == nil || memusage <= d.held {
+		return true
+	}
+	if !d.limiter.acquire(d.held, memusage, wait) {
+		return false
+	}
+	d.held = memusage
+	return true
+}
+
+// Close frees memory allocated for the decoder.
+func (d *xzDecoder) Close() error {
+	_ = d.blockStream.Close()
+	_ = d.block.Close()
+	_ = d.index.Close()
+	if d.limiter != nil {
+		d.limiter.release(d.held)
+		d.held = 0
+	}
+	return nil
+}
+
+// newCheckHash returns a hash computing check as it is stored in a block, or
+// nil if check has no value to compute.
+func newCheckHash(check lzma.Check) hash.Hash {
+	switch check {
+	case lzma.CheckCRC32:
+		return new(crc32Hash)
+	case lzma.CheckCRC64:
+		return new(crc64Hash)
+	case lzma.CheckSHA256:
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// crc32Hash computes lzma.CheckCRC32 with liblzma.
+type crc32Hash struct {
+	crc uint32
+}
+
+func (h *crc32Hash) Write(p []byte) (int, error) {
+	h.crc = lzma.CRC32(p, h.crc)
+	return len(p), nil
+}
+
+func (h *crc32Hash) Sum(b []byte) []byte {
+	return binary.LittleEndian.AppendUint32(b, h.crc)
+}
+
+func (h *crc32Hash) Reset()         { h.crc = 0 }
+func (h *crc32Hash) Size() int      { return 4 }
+func (h *crc32Hash) BlockSize() int { return 1 }
+
+// crc64Hash computes lzma.CheckCRC64 with liblzma.
+type crc64Hash struct {
+	crc uint64
+}
+
+func (h *crc64Hash) Write(p []byte) (int, error) {
+	h.crc = lzma.CRC64(p, h.crc)
+	return len(p), nil
+}
+
+func (h *crc64Hash) Sum(b []byte) []byte {
+	return binary.LittleEndian.AppendUint64(b, h.crc)
+}
+
+func (h *crc64Hash) Reset()         { h.crc = 0 }
+func (h *crc64Hash) Size() int      { return 8 }
+func (h *crc64Hash) BlockSize() int { return 1 }
@@ -0,0 +1,85 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestNewVerifyingReader(t *testing.T) {
+	tests := []struct {
+		name, base64Input, want string
+		wantErr                 bool
+	}{
+		{
+			name:        "good-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
+			want:        "Hello\nWorld!\n",
+		},
+		{
+			name:        "good-1-check-crc64.xz",
+			base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla",
+			want:        "Hello\nWorld!\n",
+		},
+		{
+			name:        "good-1-check-sha256.xz",
+			base64Input: "/Td6WFoAAArh+wyhAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgCOWTXn4TNozZaI/o9IoJVSk2dqAhViWCx+hI2v4T+wRgABQA2Thk6uGJtLmgEAAAAAClla",
+			want:        "Hello\nWorld!\n",
+		},
+		{
+			name:        "good-2-lzma2.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
+			want:        "Hello\nWorld!\n",
+		},
+		{
+			name:        "good-1-lzma2-1.xz",
+			base64Input: loremBase64,
+			want:        loremText,
+		},
+		{
+			name:        "bad-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=",
+			want:        "Hello\nWorld!\n",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewVerifyingReader(bytes.NewReader(decodeBase64(t, tt.base64Input))))
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Read() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if string(got) != tt.want {
+					t.Errorf("Read() got = '%v', want %v", string(got), tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestNewVerifyingReader_ignoreCheck(t *testing.T) {
+	// bad-1-check-crc32.xz is only rejected by the recomputed check when
+	// liblzma has been told to ignore it.
+	const base64Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo="
+	d := newXZDecoder(math.MaxUint64, lzma.Concatenated, lzma.IgnoreCheck)
+	_, err := io.ReadAll(newDecoderReader(bytes.NewReader(decodeBase64(t, base64Input)), func(uint64) (decoder, error) { return d, nil }, nil))
+	if err != nil {
+		t.Fatalf("Read() error = %v, want nil with check ignored", err)
+	}
+
+	d = newXZDecoder(math.MaxUint64, lzma.Concatenated, lzma.IgnoreCheck)
+	d.verify = true
+	_, err = io.ReadAll(newDecoderReader(bytes.NewReader(decodeBase64(t, base64Input)), func(uint64) (decoder, error) { return d, nil }, nil))
+	if !errors.Is(err, ErrCheckMismatch) {
+		t.Errorf("Read() error = %v, want %v", err, ErrCheckMismatch)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync"
+
+	"dill.foo/xz/lzma"
+)
+
+// maxSizeHint caps how much output is preallocated from a size hint, as the
+// Index is only verified once the whole input has been decoded.
+const maxSizeHint = 256 << 20
+
+// untrustedMemLimit is the memory DecompressContext allows the decoder beyond
+// maxOut, enough for the 64 MiB dictionary of preset 9.
+const untrustedMemLimit = 80 << 20
+
+// Decompress decodes all the xz compressed data in src.
+func Decompress(src []byte) ([]byte, error) {
+	return DecompressStream(bytes.NewReader(src))
+}
+
+// DecompressContext decodes all the xz compressed data in src like Decompress
+// with bounds for untrusted input. Decoding stops with ctx.Err() once ctx is
+// done, checked between reads of at most 32 KiB of output, and with
+// ErrTooLarge once the output exceeds maxOut bytes, having decoded no more than
+// maxOut+1 bytes. The decoder and the Index are limited to maxOut bytes of
+// memory plus 80 MiB, enough for any preset as a dictionary larger than the
+// output is never used, and input that needs more fails with an Error of code
+// lzma.MemLimitError before the memory is allocated.
+func DecompressContext(ctx context.Context, src []byte, maxOut int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	maxOut = max(maxOut, 0)
+	memlimit := uint64(maxOut) + untrustedMemLimit
+	size, _, err := outputSizeHint(bytes.NewReader(src), memlimit)
+	if err != nil {
+		return nil, err
+	}
+	xr := NewReaderN(bytes.NewReader(src), int64(maxOut)+1, WithMemLimit(memlimit))
+	defer xr.Close()
+	out := make([]byte, 0, min(size, int64(maxOut), maxSizeHint)+1)
+	for {
+		if len(out) == cap(out) {
+			out = append(out, 0)[:len(out)]
+		}
+		n, err := xr.Read(out[len(out):min(cap(out), len(out)+defaultBufferSize)])
+		out = out[:len(out)+n]
+		if len(out) > maxOut {
+			return nil, ErrTooLarge
+		}
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// DecompressStream decodes all the xz compressed data in r from its current
+// position. The output is preallocated from the size recorded in the Index.
+func DecompressStream(r io.ReadSeeker) ([]byte, error) {
+	size, _, err := OutputSizeHint(r)
+	if err != nil {
+		return nil, err
+	}
+	xr := NewReader(r)
+	defer xr.Close()
+	return readAll(xr, size)
+}
+
+// NewMemSeekReader decodes all the xz compressed data in src at once and
+// returns a reader of the output held in memory, for data that is seeked
+// repeatedly without decoding it again.
+func NewMemSeekReader(src []byte) (io.ReadSeeker, error) {
+	size, _, err := OutputSizeHint(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	xr := NewReader(bytes.NewReader(src))
+	defer xr.Close()
+	out, err := readAll(xr, size)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out), nil
+}
+
+// NewMemSeekReaderN is like NewMemSeekReader for output of at most maxSize
+// bytes. If the output is larger, ErrTooLarge is returned having decoded no
+// more than maxSize+1 bytes.
+func NewMemSeekReaderN(src []byte, maxSize int64) (io.ReadSeeker, error) {
+	maxSize = max(maxSize, 0)
+	size, exact, err := OutputSizeHint(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	if exact && size > maxSize {
+		return nil, ErrTooLarge
+	}
+	xr := NewReaderN(bytes.NewReader(src), maxSize+1)
+	defer xr.Close()
+	out, err := readAll(xr, min(size, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return bytes.NewReader(out), nil
+}
+
+// DecompressedLen returns the size of the output src decompresses to, read from
+// the Index of every stream without decoding any block data or allocating the
+// output. If the Index cannot be decoded, such as when src is not complete,
+// src is decoded with the output discarded to count it, failing like
+// Decompress. A size too large for an int64 fails with ErrTooLarge.
+func DecompressedLen(src []byte) (int64, error) {
+	index, ret, err := decodeIndex(bytes.NewReader(src))
+	if err != nil {
+		return 0, err
+	}
+	if ret == lzma.StreamEnd {
+		defer index.Close()
+		size := index.UncompressedSize()
+		if size > math.MaxInt64 {
+			return 0, ErrTooLarge
+		}
+		return int64(size), nil
+	}
+	buf := verifyBuffers.Get().(*[]byte)
+	defer verifyBuffers.Put(buf)
+	xr := NewBytesReader(src)
+	var size int64
+	for {
+		n, err := xr.Read(*buf)
+		if size += int64(n); size < 0 {
+			_ = xr.Close()
+			return 0, ErrTooLarge
+		}
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readAll reads r until EOF into a buffer preallocated to hold size bytes.
+func readAll(r io.Reader, size int64) ([]byte, error) {
+	if size > maxSizeHint {
+		size = maxSizeHint
+	}
+	// An extra byte is allocated so the terminal Read has room to report EOF.
+	b := make([]byte, 0, size+1)
+	for {
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return b, err
+		}
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+	}
+}
+
+// DecodeTo decodes the xz compressed data read from src to dst, returning the
+// number of bytes written. It allocates a single buffer of bufSize bytes, which
+// is shared between the input and output unless src is a *bytes.Buffer, making
+// its memory use predictable. Errors from src and dst are returned as is.
+func DecodeTo(dst io.Writer, src io.Reader, bufSize int) (int64, error) {
+	if bufSize < 2 {
+		return 0, errors.New("buffer size must be at least 2")
+	}
+	buf := make([]byte, bufSize)
+	out := buf
+	var opts []ReaderOption
+	switch src.(type) {
+	case *bytes.Buffer:
+	default:
+		half := bufSize / 2
+		opts = append(opts, withBuffer(buf[:half]))
+		out = buf[half:]
+	}
+	xr := NewReader(src, opts...)
+	var written int64
+	for {
+		n, err := xr.Read(out)
+		if n > 0 {
+			m, werr := dst.Write(out[:n])
+			written += int64(m)
+			if werr == nil && m < n {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				_ = xr.Close()
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// verifyBuffers are reused by Verify to receive the discarded output.
+var verifyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, defaultBufferSize)
+		return &b
+	},
+}
+
+// Verify decodes all the xz compressed data in r, discarding the output, like
+// xz --test. It returns nil if the integrity check of every block matches and
+// every Index and footer is consistent with the decoded blocks. A stream whose
+// check cannot be calculated by the linked liblzma fails with an Error of
+// lzma.UnsupportedCheck.
+func Verify(r io.Reader) error {
+	buf := verifyBuffers.Get().(*[]byte)
+	defer verifyBuffers.Put(buf)
+	xr := NewReader(r)
+	for {
+		_, err := xr.Read(*buf)
+		if xr.uncheckable {
+			_ = xr.Close()
+			return &Error{Code: lzma.UnsupportedCheck}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestDecompress(t *testing.T) {
+	got, err := Decompress(decodeBase64(t, loremBase64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Decompress() got = '%v', want %v", string(got), loremText)
+	}
+	if cap(got) != len(loremText)+1 {
+		t.Errorf("Decompress() cap = %d, want preallocated %d", cap(got), len(loremText)+1)
+	}
+	if _, err := Decompress([]byte("not xz data")); err == nil {
+		t.Error("Decompress() expected error for non xz data")
+	}
+}
+
+// countdownContext is canceled once Err has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDecompressContext(t *testing.T) {
+	large := bytes.Repeat([]byte(loremText), 1000)
+	compressed, err := lzma.EasyBufferEncode(lzma.PresetDefault, lzma.CheckCRC64, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		maxOut  int
+		want    []byte
+		wantErr error
+	}{
+		{name: "success", ctx: context.Background(), maxOut: len(large), want: large},
+		{name: "too large", ctx: context.Background(), maxOut: len(large) - 1, wantErr: ErrTooLarge},
+		{name: "zero", ctx: context.Background(), maxOut: 0, wantErr: ErrTooLarge},
+		{name: "canceled", ctx: canceled, maxOut: len(large), wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := DecompressContext(tt.ctx, compressed, tt.maxOut)
+				if err != tt.wantErr {
+					t.Fatalf("DecompressContext() error = %v, want %v", err, tt.wantErr)
+				}
+				if !bytes.Equal(got, tt.want) {
+					t.Errorf("DecompressContext() got %d bytes, want %d", len(got), len(tt.want))
+				}
+			},
+		)
+	}
+
+	// Cancellation during decoding stops it between reads.
+	ctx := &countdownContext{Context: context.Background(), n: 3}
+	if _, err := DecompressContext(ctx, compressed, len(large)); err != context.Canceled {
+		t.Errorf("DecompressContext() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := DecompressContext(context.Background(), []byte("not xz data"), 100); err == nil {
+		t.Error("DecompressContext() expected error for non xz data")
+	}
+
+	// A block header declaring a 1.5 GiB LZMA2 dictionary is rejected by the
+	// memory limit rather than allocated.
+	header, ret := lzma.EncodeStreamHeader(lzma.StreamFlags{Check: lzma.CheckCRC32})
+	if ret != lzma.Ok {
+		t.Fatalf("EncodeStreamHeader() = %v", ret)
+	}
+	block := []byte{0x02, 0x00, byte(lzma.FilterLZMA2), 0x01, 0x25, 0x00, 0x00, 0x00}
+	block = binary.LittleEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+	input := append(append(header, block...), make([]byte, 64)...)
+	_, err = DecompressContext(context.Background(), input, 1<<20)
+	var xzErr *Error
+	if !errors.As(err, &xzErr) || xzErr.Code != lzma.MemLimitError {
+		t.Errorf("DecompressContext() error = %v, want code %v", err, lzma.MemLimitError)
+	}
+}
+
+func TestNewMemSeekReader(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	r, err := NewMemSeekReader(input)
+	if err != nil {
+		t.Fatalf("NewMemSeekReader() error = %v", err)
+	}
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{offset: 100, whence: io.SeekStart, want: 100},
+		{offset: -50, whence: io.SeekCurrent, want: 60},
+		{offset: -10, whence: io.SeekEnd, want: int64(len(loremText) - 10)},
+		{offset: 0, whence: io.SeekStart, want: 0},
+		{offset: 333, whence: io.SeekCurrent, want: 343},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.want {
+			t.Fatalf("Seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, pos, err, tt.want)
+		}
+		got := make([]byte, 10)
+		n, err := io.ReadFull(r, got)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if want := loremText[pos : pos+int64(n)]; string(got[:n]) != want || n != min(10, len(loremText)-int(pos)) {
+			t.Errorf("Read() at %d got = '%v', want %v", pos, string(got[:n]), want)
+		}
+	}
+
+	if _, err := NewMemSeekReaderN(input, int64(len(loremText))); err != nil {
+		t.Errorf("NewMemSeekReaderN() at the size error = %v", err)
+	}
+	if _, err := NewMemSeekReaderN(input, int64(len(loremText)-1)); err != ErrTooLarge {
+		t.Errorf("NewMemSeekReaderN() error = %v, want %v", err, ErrTooLarge)
+	}
+	if _, err := NewMemSeekReader([]byte("not xz data")); err == nil {
+		t.Error("NewMemSeekReader() expected error for non xz data")
+	}
+}
+
+func TestDecompressedLen(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	tests := []struct {
+		name    string
+		input   []byte
+		want    int64
+		wantErr bool
+	}{
+		{name: "lorem", input: input, want: int64(len(loremText))},
+		{name: "empty", input: nil, want: 0},
+		{name: "truncated", input: input[:len(input)-1], wantErr: true},
+		{name: "not xz data", input: []byte("not xz data"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecompressedLen(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecompressedLen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DecompressedLen() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTo(t *testing.T) {
+	large := bytes.Repeat([]byte(loremText), 1000)
+	compressed, err := lzma.EasyBufferEncode(lzma.PresetDefault, lzma.CheckCRC64, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		src  io.Reader
+		want []byte
+	}{
+		{name: "good-1-lzma2-1.xz", src: bytes.NewReader(decodeBase64(t, loremBase64)), want: []byte(loremText)},
+		{name: "large in memory", src: bytes.NewReader(compressed), want: large},
+		{name: "large streamed", src: iotest.HalfReader(bytes.NewReader(compressed)), want: large},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				n, err := DecodeTo(&buf, tt.src, 1<<10)
+				if err != nil {
+					t.Fatalf("DecodeTo() error = %v", err)
+				}
+				if n != int64(len(tt.want)) || !bytes.Equal(buf.Bytes(), tt.want) {
+					t.Errorf("DecodeTo() wrote %d bytes, want %d", n, len(tt.want))
+				}
+			},
+		)
+	}
+
+	// Beyond the buffer only the small state of the reader is allocated, and
+	// no buffer of the default size.
+	for _, src := range []func() io.Reader{
+		func() io.Reader { return bytes.NewReader(compressed) },
+		func() io.Reader { return bytes.NewBuffer(compressed) },
+		func() io.Reader { return iotest.HalfReader(bytes.NewReader(compressed)) },
+	} {
+		var before, after runtime.MemStats
+		r := src()
+		runtime.ReadMemStats(&before)
+		if _, err := DecodeTo(io.Discard, r, 1<<10); err != nil {
+			t.Fatalf("DecodeTo() error = %v", err)
+		}
+		runtime.ReadMemStats(&after)
+		if got := after.TotalAlloc - before.TotalAlloc; got >= defaultBufferSize {
+			t.Errorf("DecodeTo() from %T allocated %d bytes, want less than %d", r, got, defaultBufferSize)
+		}
+	}
+
+	sentinel := errors.New("sentinel")
+	_, err = DecodeTo(errWriter{sentinel}, bytes.NewReader(compressed), 1<<10)
+	var xzErr *Error
+	if err != sentinel || errors.As(err, &xzErr) {
+		t.Errorf("DecodeTo() write error = %v, want %v", err, sentinel)
+	}
+	// bad-1-check-crc32.xz
+	corrupt := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=")
+	if _, err := DecodeTo(io.Discard, bytes.NewReader(corrupt), 1<<10); !errors.As(err, &xzErr) {
+		t.Errorf("DecodeTo() decode error = %v, want %T", err, xzErr)
+	}
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestVerify(t *testing.T) {
+	for _, tt := range readerTests {
+		good := strings.HasPrefix(tt.name, "good-")
+		if !good && !strings.HasPrefix(tt.name, "bad-") {
+			continue
+		}
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := Verify(bytes.NewReader(decodeBase64(t, tt.base64Input)))
+				if (err == nil) != good {
+					t.Errorf("Verify() error = %v, want error %v", err, !good)
+				}
+			},
+		)
+	}
+}
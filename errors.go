@@ -0,0 +1,119 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"errors"
+	"fmt"
+
+	"dill.foo/xz/lzma"
+)
+
+var (
+	// ErrData is matched by errors for input that is corrupt.
+	ErrData = errors.New("xz: corrupt data")
+
+	// ErrIndex is matched by errors for a corrupt Index field, or an Index that
+	// does not describe the blocks that were decoded. The blocks themselves may
+	// have decoded correctly. It also matches ErrData.
+	ErrIndex = fmt.Errorf("%w: corrupt index", ErrData)
+
+	// ErrCheckMismatch is returned by NewVerifyingReader when the integrity
+	// check recomputed from the decoded output of a block differs from the
+	// stored check. It also matches ErrData.
+	ErrCheckMismatch = fmt.Errorf("%w: integrity check mismatch", ErrData)
+
+	// ErrSizeMismatch is matched by errors for a block whose data does not
+	// decode to the Uncompressed Size recorded in its header. For a block with
+	// Check type None the sizes are the only check of its data. It is also
+	// matched, together with ErrIndex, by the error of a reader created with
+	// WithVerifyUncompressedSize when the uncompressed size of a stream differs
+	// from the total recorded in its Index. It also matches ErrData.
+	ErrSizeMismatch = fmt.Errorf("%w: uncompressed size mismatch", ErrData)
+
+	// ErrStreamFlagsMismatch is matched by errors for a stream whose footer
+	// holds different flags, such as the integrity check, than its header. The
+	// stream may have been truncated and joined to the end of another. It also
+	// matches ErrData.
+	ErrStreamFlagsMismatch = fmt.Errorf("%w: stream header and footer flags differ", ErrData)
+
+	// ErrNoProgress is matched by the error of a reader whose decoder stalls
+	// before the source has ended. Input that ends before the end of the last
+	// stream is reported as an Error matching io.ErrUnexpectedEOF instead.
+	ErrNoProgress = errors.New("xz: decoder made no progress")
+
+	// ErrBudgetExceeded is returned by a reader created with WithMaxCodeCalls
+	// once the decoder has been run the given number of times.
+	ErrBudgetExceeded = errors.New("xz: decoding budget exceeded")
+
+	// ErrMemLimiterExhausted is matched by the error of a reader created with
+	// WithMemLimiter when its MemLimiter has too little memory left for the
+	// decoder.
+	ErrMemLimiterExhausted = errors.New("xz: shared memory limit exhausted")
+
+	// ErrTooLarge is returned when the decompressed data exceeds the maximum
+	// size given by the caller.
+	ErrTooLarge = errors.New("xz: decompressed data too large")
+
+	// ErrTrailingData is matched by the error of a reader created with
+	// WithRejectTrailingData when the input after its stream is not Stream
+	// Padding. The stream itself decoded correctly, so it does not match
+	// ErrData.
+	ErrTrailingData = errors.New("xz: data after end of stream")
+
+	// ErrTooManyStreams is matched by the error of a reader created with
+	// WithMaxStreams once the input holds more streams than allowed.
+	ErrTooManyStreams = errors.New("xz: too many streams")
+
+	// errLZMA2Control describes LZMA2 data with a reserved chunk control byte.
+	errLZMA2Control = fmt.Errorf("%w: invalid LZMA2 control byte", ErrData)
+
+	// errBlockSize describes a block whose data does not decode to the
+	// Uncompressed Size in its header.
+	errBlockSize = fmt.Errorf("%w: block data does not match block header", ErrSizeMismatch)
+
+	// errIndexSize describes a stream whose uncompressed size differs from its
+	// Index.
+	errIndexSize error = indexSizeError{}
+
+	// errVLI describes a block header with a malformed variable-length integer.
+	errVLI = fmt.Errorf("%w: invalid variable-length integer encoding", ErrData)
+)
+
+// indexSizeError matches both ErrSizeMismatch and ErrIndex.
+type indexSizeError struct{}
+
+func (indexSizeError) Error() string {
+	return ErrIndex.Error() + ": stream size differs from index"
+}
+
+func (indexSizeError) Unwrap() []error {
+	return []error{ErrSizeMismatch, ErrIndex}
+}
+
+// Error is returned when liblzma fails to decode or encode the data. Errors from
+// the source reader or destination writer are never wrapped in an Error and are
+// returned as is.
+type Error struct {
+	Code lzma.Return
+	// Err is the cause of the error when it is more specific than Code.
+	Err error
+	// BytesProduced is the total decoded output returned before the error.
+	BytesProduced int64
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v code=%v", e.Err, e.Code)
+	}
+	return fmt.Sprintf("lzma return error code=%v", e.Code)
+}
+
+// Unwrap returns the cause of the error, or ErrData for a DataError.
+func (e *Error) Unwrap() error {
+	if e.Err == nil && e.Code == lzma.DataError {
+		return ErrData
+	}
+	return e.Err
+}
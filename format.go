@@ -0,0 +1,47 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// lzmaAloneHeaderSize is the size of the header of the legacy .lzma format.
+const lzmaAloneHeaderSize = 13
+
+// IsXZ reports whether prefix begins with the magic bytes of a .xz stream.
+func IsXZ(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, streamMagic)
+}
+
+// IsLZMAAlone reports whether prefix begins with a plausible header of the
+// legacy .lzma format, which has no magic bytes. Like the format detection of
+// xz, it requires the 13 byte header to hold valid LZMA properties, a common
+// dictionary size and an uncompressed size that is either unknown or below
+// 256 GiB. Data that passes may still not be .lzma.
+func IsLZMAAlone(prefix []byte) bool {
+	if len(prefix) < lzmaAloneHeaderSize {
+		return false
+	}
+	// The lc, lp and pb properties are encoded as (pb*5+lp)*9+lc.
+	if prefix[0] > (4*5+4)*9+8 {
+		return false
+	}
+	dictSize := binary.LittleEndian.Uint32(prefix[1:5])
+	if dictSize != 1<<32-1 {
+		// Only 2^n and 2^n+2^(n-1) are accepted.
+		d := dictSize - 1
+		d |= d >> 2
+		d |= d >> 3
+		d |= d >> 4
+		d |= d >> 8
+		d |= d >> 16
+		if d+1 != dictSize {
+			return false
+		}
+	}
+	size := binary.LittleEndian.Uint64(prefix[5:13])
+	return size == 1<<64-1 || size < 1<<38
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestIsXZ(t *testing.T) {
+	var lzmaAlone bytes.Buffer
+	w := NewLZMAWriter(&lzmaAlone, lzma.PresetDefault)
+	if _, err := w.Write([]byte(loremText)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                  string
+		prefix                []byte
+		wantXZ, wantLZMAAlone bool
+	}{
+		{name: "good-1-lzma2-1.xz", prefix: decodeBase64(t, loremBase64), wantXZ: true},
+		{name: "good-0cat-empty.xz", prefix: decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg=="), wantXZ: true},
+		{name: "magic only", prefix: []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}, wantXZ: true},
+		{name: "truncated magic", prefix: []byte{0xFD, '7', 'z', 'X', 'Z'}},
+		{name: "NewLZMAWriter", prefix: lzmaAlone.Bytes(), wantLZMAAlone: true},
+		{name: "lzma alone known size", prefix: decodeBase64(t, "XQAAgAANAAAAAAAAAAAkGUmYbwUVJycNdnjQKmgXFf//dfgAAA=="), wantLZMAAlone: true},
+		{name: "text", prefix: []byte(loremText)},
+		{name: "invalid properties", prefix: append([]byte{225}, lzmaAlone.Bytes()[1:]...)},
+		{name: "uncommon dictionary size", prefix: append([]byte{0x5d, 0x01, 0x00, 0x80, 0x00}, lzmaAlone.Bytes()[5:]...)},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := IsXZ(tt.prefix); got != tt.wantXZ {
+					t.Errorf("IsXZ() = %v, want %v", got, tt.wantXZ)
+				}
+				if got := IsLZMAAlone(tt.prefix); got != tt.wantLZMAAlone {
+					t.Errorf("IsLZMAAlone() = %v, want %v", got, tt.wantLZMAAlone)
+				}
+			},
+		)
+	}
+}
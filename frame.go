@@ -0,0 +1,139 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"io"
+
+	"dill.foo/xz/lzma"
+)
+
+// FrameWriter compresses every Write as a frame of its own, a complete XZ
+// stream that can be decoded independently of the others, such as for
+// real-time telemetry where each message must be readable on arrival. The
+// frames written to a destination decode in sequence as concatenated streams,
+// at the cost of compressing each frame without the data of the others.
+type FrameWriter struct {
+	dst    io.Writer
+	preset uint32
+}
+
+// NewFrameWriter creates a FrameWriter to the given destination compressing
+// each frame with the compression level preset, optionally combined with
+// lzma.PresetExtreme, and the default check.
+func NewFrameWriter(dst io.Writer, preset uint32) (*FrameWriter, error) {
+	if _, err := lzma.PresetOptions(preset); err != nil {
+		return nil, err
+	}
+	return &FrameWriter{dst: dst, preset: preset}, nil
+}
+
+// Write compresses p as one frame and writes it to the destination. An empty p
+// writes no frame.
+func (w *FrameWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	frame, err := lzma.EasyBufferEncode(w.preset, lzma.CheckCRC64, p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.dst.Write(frame)
+	if err == nil && n < len(frame) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewFrameReader creates a XZ decoder reader like NewReader whose Read never
+// returns the output of more than one stream, so that the frames written by a
+// FrameWriter are read one per Read if p is large enough to hold them. A larger
+// frame is returned over several Reads, and the next frame starts with a new
+// Read.
+func NewFrameReader(src io.Reader, opts ...ReaderOption) *Reader {
+	r := &Reader{
+		newStream: func(memlimit uint64) (decoder, error) {
+			return newXZDecoder(memlimit, lzma.Concatenated, lzma.TellUnsupportedCheck), nil
+		},
+		frames: true,
+	}
+	r.init(src, opts)
+	return r
+}
+
+// NewLZMA2MessageReader creates a reader of raw LZMA2 data framed as messages,
+// each ending with the LZMA2 end marker, for protocols that share a decoder
+// between independent messages. The dictionary is reset between messages with
+// lzma.Stream.ResetDictionary so that no message decodes against the data of
+// the one before it; a message must start with a chunk that resets the
+// dictionary, or decoding fails with an error matching ErrData. Like
+// NewFrameReader, Read never returns the output of more than one message.
+// dictSize must be at least the dictionary size the messages were encoded
+// with.
+func NewLZMA2MessageReader(src io.Reader, dictSize uint32, opts ...ReaderOption) *Reader {
+	return newDecoderReader(
+		src,
+		func(uint64) (decoder, error) {
+			stream, err := lzma.NewLZMA2RawDecoder(dictSize)
+			if err != nil {
+				return nil, err
+			}
+			return &lzma2MessageDecoder{Stream: stream}, nil
+		},
+		opts,
+	)
+}
+
+// lzma2MessageDecoder decodes the messages of NewLZMA2MessageReader, resetting
+// the dictionary of its raw LZMA2 decoder at the end marker of each.
+type lzma2MessageDecoder struct {
+	*lzma.Stream
+	// totalIn and totalOut count the messages before the current one, as the
+	// totals of the Stream restart at every reset.
+	totalIn, totalOut uint64
+	// boundary is set at the end of a message until the next is started, and
+	// paused until Read has returned at the end of the message.
+	boundary, paused bool
+}
+
+func (d *lzma2MessageDecoder) Code(action lzma.Action) lzma.Return {
+	if d.boundary {
+		// The input may end after any message.
+		if d.AvailableIn() == 0 {
+			if action == lzma.Finish {
+				return lzma.StreamEnd
+			}
+			return lzma.Ok
+		}
+		d.boundary = false
+	}
+	ret := d.Stream.Code(action)
+	if ret != lzma.StreamEnd {
+		return ret
+	}
+	d.totalIn += d.Stream.TotalIn()
+	d.totalOut += d.Stream.TotalOut()
+	if ret := d.ResetDictionary(); ret != lzma.Ok {
+		return ret
+	}
+	d.boundary, d.paused = true, true
+	return lzma.Ok
+}
+
+func (d *lzma2MessageDecoder) TotalIn() uint64 {
+	return d.totalIn + d.Stream.TotalIn()
+}
+
+func (d *lzma2MessageDecoder) TotalOut() uint64 {
+	return d.totalOut + d.Stream.TotalOut()
+}
+
+func (d *lzma2MessageDecoder) unpause() bool {
+	paused := d.paused
+	d.paused = false
+	return paused
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFrameWriter(t *testing.T) {
+	messages := []string{"Hello\n", "World!\n", loremText}
+	var buf bytes.Buffer
+	w, err := NewFrameWriter(&buf, 6)
+	if err != nil {
+		t.Fatalf("NewFrameWriter() error = %v", err)
+	}
+	var frames [][]byte
+	for _, msg := range messages {
+		start := buf.Len()
+		if n, err := w.Write([]byte(msg)); err != nil || n != len(msg) {
+			t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(msg))
+		}
+		frames = append(frames, buf.Bytes()[start:])
+	}
+	if n, err := w.Write(nil); err != nil || n != 0 || buf.Len() != len(bytes.Join(frames, nil)) {
+		t.Errorf("Write(nil) = %d, %v, wrote %d bytes, want no frame", n, err, buf.Len())
+	}
+
+	for i, frame := range frames {
+		got, err := Decompress(frame)
+		if err != nil {
+			t.Fatalf("Decompress() frame %d error = %v", i, err)
+		}
+		if string(got) != messages[i] {
+			t.Errorf("Decompress() frame %d got = '%v', want %v", i, string(got), messages[i])
+		}
+	}
+
+	for _, src := range []io.Reader{bytes.NewReader(buf.Bytes()), iotest.OneByteReader(bytes.NewReader(buf.Bytes()))} {
+		xr := NewFrameReader(src)
+		var got []string
+		p := make([]byte, 4096)
+		for {
+			n, err := xr.Read(p)
+			if n > 0 {
+				got = append(got, string(p[:n]))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+		}
+		if !reflect.DeepEqual(got, messages) {
+			t.Errorf("Read() got = %q, want %q", got, messages)
+		}
+	}
+
+	if _, err := NewFrameWriter(&buf, 10); err == nil {
+		t.Error("NewFrameWriter() expected error for preset 10")
+	}
+}
+
+func TestNewLZMA2MessageReader(t *testing.T) {
+	// The chunks of the block of good-1-lzma2-4.xz. The first is LZMA with a
+	// dictionary reset, the second uncompressed with a dictionary reset and
+	// the third LZMA continuing the dictionary of the two before it.
+	input := decodeBase64(t, "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKwADlAL1dADGbyhnFVOy2VOexfcRXnmyJrUptFtg8BZQQFpk4IaO5xYD//O7U1T/djNc9j3bsiKoyq2XUOO/3+Yq/9/ilVtdt1z+FC54/4kdoIggFNbhBcvnbvreOhr9DS44NQy9Bad9hDMToNwhK3sJ2FrhITp65U1AfM4PoKaBnyGY6fyISYvtH5Lz0UQ8ViEnYygsli17o2v04wM5Mcxv/0JvoTLcT+DeZ4tqcL7XquKWN6leCmyXK+/aICpvfQQNuAAAAsgdE6RczS4QAAasDyQMAAPVQLf6xxGf7AgAAAAAEWVo=")
+	chunks := [][]byte{input[24:192], input[192:234], input[234:430]}
+	message := func(chunks ...[]byte) []byte {
+		return append(bytes.Join(chunks, nil), 0x00)
+	}
+	messages := bytes.Join([][]byte{message(chunks[0]), message(chunks[1], chunks[2])}, nil)
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "independent messages",
+			input: messages,
+			want:  []string{loremText[:188], loremText[188:]},
+		},
+		{
+			// Decoded without the reset, the message would continue the
+			// dictionary of the message before it.
+			name:    "message continuing the dictionary",
+			input:   append(bytes.Clone(messages), message(chunks[2])...),
+			want:    []string{loremText[:188], loremText[188:]},
+			wantErr: ErrData,
+		},
+		{
+			name:    "truncated message",
+			input:   messages[:len(messages)-1],
+			want:    []string{loremText[:188], loremText[188:]},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				xr := NewLZMA2MessageReader(iotest.HalfReader(bytes.NewReader(tt.input)), 1<<20)
+				var got []string
+				var err error
+				buf := make([]byte, len(loremText))
+				for err == nil {
+					var n int
+					n, err = xr.Read(buf)
+					if n > 0 {
+						got = append(got, string(buf[:n]))
+					}
+				}
+				if tt.wantErr == nil && err != io.EOF || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Read() error = %v, want %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Read() got %q, want %q", got, tt.want)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"io"
+	"math"
+
+	"dill.foo/xz/lzma"
+)
+
+// UncompressedSize returns the total uncompressed size recorded in the Index of
+// every stream in r without decoding any block data. r is read from its
+// current position to the end and is left at its original position.
+func UncompressedSize(r io.ReadSeeker) (int64, error) {
+	index, ret, err := decodeIndex(r)
+	if err != nil {
+		return 0, err
+	}
+	if ret != lzma.StreamEnd {
+		return 0, &Error{Code: ret}
+	}
+	defer index.Close()
+	return int64(index.UncompressedSize()), nil
+}
+
+// OutputSizeHint returns the size of the output r will decompress to. If the
+// Index could be decoded the exact size is returned with true, otherwise a
+// conservative estimate is returned with false: the compressed size, as data
+// rarely decompresses to less, capped at 256 MiB. The estimate is not a bound
+// and the output may be far larger. An error is only returned if r fails to
+// read or seek. r is left at its original position.
+func OutputSizeHint(r io.ReadSeeker) (int64, bool, error) {
+	return outputSizeHint(r, math.MaxUint64)
+}
+
+// outputSizeHint is OutputSizeHint decoding the Index with at most memlimit
+// bytes of memory.
+func outputSizeHint(r io.ReadSeeker, memlimit uint64) (int64, bool, error) {
+	index, ret, err := decodeIndexLimit(r, memlimit)
+	if err != nil {
+		return 0, false, err
+	}
+	if ret != lzma.StreamEnd {
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false, err
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false, err
+		}
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return 0, false, err
+		}
+		return min(end-start, maxSizeHint), false, nil
+	}
+	defer index.Close()
+	return int64(index.UncompressedSize()), true, nil
+}
+
+// BlockLocation locates a block of a .xz file in both the compressed input and
+// the decompressed output, as recorded in the Index.
+type BlockLocation struct {
+	// CompressedOffset is the offset of the block header in the input and
+	// CompressedSize the size of the block including its header, padding and
+	// check.
+	CompressedOffset int64
+	CompressedSize   int64
+	// UncompressedOffset is the offset of the block data in the output.
+	UncompressedOffset int64
+	UncompressedSize   int64
+}
+
+// BlockOffsets returns the location of every block of every stream in r, read
+// from the Index without decoding any block data, so that a block can later be
+// decoded directly by seeking to it. Offsets are relative to the current
+// position of r, where it is left.
+func BlockOffsets(r io.ReadSeeker) ([]BlockLocation, error) {
+	index, ret, err := decodeIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	if ret != lzma.StreamEnd {
+		return nil, &Error{Code: ret}
+	}
+	defer index.Close()
+	blocks := index.Blocks()
+	locations := make([]BlockLocation, len(blocks))
+	for i, b := range blocks {
+		locations[i] = BlockLocation{
+			CompressedOffset:   int64(b.CompressedFileOffset),
+			CompressedSize:     int64(b.TotalSize),
+			UncompressedOffset: int64(b.UncompressedFileOffset),
+			UncompressedSize:   int64(b.UncompressedSize),
+		}
+	}
+	return locations, nil
+}
+
+// decodeIndex decodes the combined Index of every stream in r from its current
+// position to the end, restoring the position afterward. The Index is only
+// returned along with lzma.StreamEnd, decoding failures are reported by the
+// lzma.Return alone. With liblzma older than 5.4.0 the Index cannot be decoded
+// and lzma.OptionsError is returned.
+func decodeIndex(r io.ReadSeeker) (*lzma.Index, lzma.Return, error) {
+	return decodeIndexLimit(r, math.MaxUint64)
+}
+
+// decodeIndexLimit is decodeIndex with at most memlimit bytes of memory for
+// the Index, failing with lzma.MemLimitError for one that needs more.
+func decodeIndexLimit(r io.ReadSeeker, memlimit uint64) (*lzma.Index, lzma.Return, error) {
+	if !lzma.HasFileInfoDecoder() {
+		return nil, lzma.OptionsError, nil
+	}
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	stream, err := lzma.NewFileInfoDecoder(memlimit, uint64(end-start))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stream.Close()
+
+	buf := make([]byte, defaultBufferSize)
+	action := lzma.Run
+	for {
+		if stream.AvailableIn() == 0 && action == lzma.Run {
+			n, err := r.Read(buf)
+			if err != nil && err != io.EOF {
+				return nil, 0, err
+			}
+			if err == io.EOF {
+				action = lzma.Finish
+			}
+			stream.SetNextIn(buf[:n])
+		}
+		switch ret := stream.Code(action); ret {
+		case lzma.Ok:
+		case lzma.SeekNeeded:
+			if _, err := r.Seek(start+int64(stream.SeekPos()), io.SeekStart); err != nil {
+				return nil, 0, err
+			}
+			stream.SetNextIn(nil)
+			action = lzma.Run
+		case lzma.StreamEnd:
+			index := stream.Index()
+			if _, err := r.Seek(start, io.SeekStart); err != nil {
+				_ = index.Close()
+				return nil, 0, err
+			}
+			return index, ret, nil
+		default:
+			if _, err := r.Seek(start, io.SeekStart); err != nil {
+				return nil, 0, err
+			}
+			return nil, ret, nil
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestOutputSizeHint(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		want      int64
+		wantExact bool
+	}{
+		{
+			name:      "exact from Index",
+			input:     decodeBase64(t, loremBase64),
+			want:      int64(len(loremText)),
+			wantExact: true,
+		},
+		{
+			name:  "estimate without Index",
+			input: []byte("not xz data"),
+			want:  int64(len("not xz data")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := bytes.NewReader(tt.input)
+				got, exact, err := OutputSizeHint(r)
+				if err != nil {
+					t.Fatalf("OutputSizeHint() error = %v", err)
+				}
+				if got != tt.want || exact != tt.wantExact {
+					t.Errorf("OutputSizeHint() got = %v, %v, want %v, %v", got, exact, tt.want, tt.wantExact)
+				}
+				if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+					t.Errorf("OutputSizeHint() left reader at %d, want 0", pos)
+				}
+			},
+		)
+	}
+
+	// The estimate of a large input is capped.
+	got, exact, err := OutputSizeHint(io.NewSectionReader(zeroReaderAt{}, 0, 1<<40))
+	if err != nil || got != maxSizeHint || exact {
+		t.Errorf("OutputSizeHint() of a large input = %v, %v, %v, want %v, false", got, exact, err, maxSizeHint)
+	}
+}
+
+// zeroReaderAt reads zeros at any offset.
+type zeroReaderAt struct{}
+
+func (zeroReaderAt) ReadAt(p []byte, _ int64) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestUncompressedSize(t *testing.T) {
+	got, err := UncompressedSize(bytes.NewReader(decodeBase64(t, loremBase64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != int64(len(loremText)) {
+		t.Errorf("UncompressedSize() got = %d, want %d", got, len(loremText))
+	}
+	// The error names the return of liblzma.
+	_, err = UncompressedSize(bytes.NewReader([]byte("not xz data")))
+	if want := "lzma return error code=format error"; err == nil || err.Error() != want {
+		t.Errorf("UncompressedSize() error = %v, want %v", err, want)
+	}
+}
+
+func TestBlockOffsets(t *testing.T) {
+	// good-2-lzma2.xz
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=")
+	got, err := BlockOffsets(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("BlockOffsets() error = %v", err)
+	}
+	want := []BlockLocation{
+		{CompressedOffset: 12, CompressedSize: 28, UncompressedOffset: 0, UncompressedSize: 6},
+		{CompressedOffset: 40, CompressedSize: 28, UncompressedOffset: 6, UncompressedSize: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("BlockOffsets() got = %+v, want %+v", got, want)
+	}
+
+	if _, err := BlockOffsets(bytes.NewReader([]byte("not xz data"))); err == nil {
+		t.Error("BlockOffsets() expected error for non xz data")
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bufio"
+	"io"
+)
+
+// LineScanner scans the lines of XZ compressed data with the methods of its
+// bufio.Scanner. The decoder is freed once the scan reaches the end of the
+// data or a decoding error, or otherwise by Close.
+type LineScanner struct {
+	*bufio.Scanner
+	xr *Reader
+}
+
+// NewLineScanner creates a scanner of the lines of the XZ compressed data read
+// from src, as decoded by NewReader with opts. Lines are split by
+// bufio.ScanLines, so the end-of-line markers are not part of the tokens. A
+// decoding error stops the scan and is returned by Err. A scan stopped early,
+// such as by a token too long for the scanner, must be closed to free the
+// decoder.
+func NewLineScanner(src io.Reader, opts ...ReaderOption) *LineScanner {
+	xr := NewReader(src, opts...)
+	return &LineScanner{Scanner: bufio.NewScanner(xr), xr: xr}
+}
+
+// Close frees the decoder like Reader.Close. It returns nil once the scan has
+// reached the end of the data.
+func (s *LineScanner) Close() error {
+	return s.xr.Close()
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestNewLineScanner(t *testing.T) {
+	tests := []struct {
+		name        string
+		base64Input string
+		want        []string
+		wantErr     error
+	}{
+		{
+			name:        "good-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
+			want:        []string{"Hello", "World!"},
+		},
+		{
+			name:        "bad-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=",
+			// Lines decoded before the error are still scanned.
+			want:    []string{"Hello", "World!"},
+			wantErr: ErrData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				s := NewLineScanner(bytes.NewReader(decodeBase64(t, tt.base64Input)))
+				var got []string
+				for s.Scan() {
+					got = append(got, s.Text())
+				}
+				if err := s.Err(); !errors.Is(err, tt.wantErr) {
+					t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Scan() got = %q, want %q", got, tt.want)
+				}
+				if err := s.Close(); !errors.Is(err, tt.wantErr) {
+					t.Errorf("Close() error = %v, want %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func TestLineScanner_Close(t *testing.T) {
+	// A scan stopped before the end of the data leaves the decoder to Close.
+	input, err := lzma.EasyBufferEncode(lzma.PresetDefault, lzma.CheckCRC64, bytes.Repeat([]byte(loremText), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLineScanner(bytes.NewReader(input))
+	if !s.Scan() {
+		t.Fatalf("Scan() = false, Err() = %v", s.Err())
+	}
+	if s.xr.lastErr != nil {
+		t.Fatalf("decoder ended after one line with %v", s.xr.lastErr)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := s.xr.Read(make([]byte, 1)); err != errReaderClosed {
+		t.Errorf("Read() after Close() error = %v, want %v", err, errReaderClosed)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+#include <stdlib.h>
+#include <lzma.h>
+#include "_cgo_export.h"
+
+static void *alloc_trampoline(void *opaque, size_t nmemb, size_t size) {
+	return goAlloc((uintptr_t)opaque, nmemb, size);
+}
+
+static void free_trampoline(void *opaque, void *ptr) {
+	goFree((uintptr_t)opaque, ptr);
+}
+
+// Allocate a lzma_allocator calling the Go Allocator identified by handle.
+lzma_allocator *new_allocator(uintptr_t handle) {
+	lzma_allocator *allocator = malloc(sizeof(lzma_allocator));
+	if (allocator == NULL) {
+		return NULL;
+	}
+	allocator->alloc = alloc_trampoline;
+	allocator->free = free_trampoline;
+	allocator->opaque = (void *)handle;
+	return allocator;
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+#include <lzma.h>
+
+lzma_stream stream_init();
+lzma_allocator *new_allocator(uintptr_t handle);
+*/
+import "C"
+import (
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Allocator allocates the memory used internally by liblzma, for example to
+// account for native allocations. liblzma keeps pointers to the memory so it
+// must not be managed by the Go garbage collector: it may come from CAllocator
+// or be mapped outside the Go heap.
+type Allocator interface {
+	// Alloc returns size bytes of memory, or nil if it cannot be allocated.
+	Alloc(size uintptr) unsafe.Pointer
+	// Free releases memory returned by Alloc. ptr may be nil.
+	Free(ptr unsafe.Pointer)
+}
+
+// CAllocator allocates with the C malloc and free like the default allocator of
+// liblzma. It may be wrapped by other Allocators.
+type CAllocator struct{}
+
+func (CAllocator) Alloc(size uintptr) unsafe.Pointer {
+	return C.malloc(C.size_t(size))
+}
+
+func (CAllocator) Free(ptr unsafe.Pointer) {
+	C.free(ptr)
+}
+
+//export goAlloc
+func goAlloc(handle C.uintptr_t, nmemb, size C.size_t) unsafe.Pointer {
+	return cgo.Handle(handle).Value().(Allocator).Alloc(uintptr(nmemb) * uintptr(size))
+}
+
+//export goFree
+func goFree(handle C.uintptr_t, ptr unsafe.Pointer) {
+	cgo.Handle(handle).Value().(Allocator).Free(ptr)
+}
+
+// setAllocator makes the uninitialized stream allocate with alloc until it is
+// closed.
+func (stream *Stream) setAllocator(alloc Allocator) error {
+	handle := cgo.NewHandle(alloc)
+	allocator := C.new_allocator(C.uintptr_t(handle))
+	if allocator == nil {
+		handle.Delete()
+		return fmt.Errorf("error init allocator code=%v", MemError)
+	}
+	stream.internal.allocator = allocator
+	stream.handle = handle
+	return nil
+}
+
+// freeAllocator releases the allocator set by setAllocator once the coder
+// has been ended.
+func (stream *Stream) freeAllocator() {
+	if stream.handle == 0 {
+		return
+	}
+	C.free(unsafe.Pointer(stream.internal.allocator))
+	stream.internal.allocator = nil
+	stream.handle.Delete()
+	stream.handle = 0
+}
+
+// NewStreamDecoderAlloc initializes an .xz Stream configured as a decoder like
+// NewStreamDecoder, allocating its memory with alloc. alloc is retained until
+// the Stream is closed.
+func NewStreamDecoderAlloc(memlimit uint64, alloc Allocator, flags ...DecoderOpt) (*Stream, error) {
+	var decoderFlag int32
+	for _, flag := range flags {
+		decoderFlag |= int32(flag)
+	}
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	if err := stream.setAllocator(alloc); err != nil {
+		return nil, err
+	}
+	ret := Return(
+		C.lzma_stream_decoder(
+			(*C.lzma_stream)(&stream.internal),
+			C.uint64_t(memlimit),
+			C.uint32_t(decoderFlag),
+		),
+	)
+	if ret != Ok {
+		_ = stream.Close()
+		return nil, fmt.Errorf("error init stream decoder code=%v", ret)
+	}
+	return &stream, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"unsafe"
+)
+
+// countingAllocator counts the allocations outstanding with CAllocator.
+type countingAllocator struct {
+	CAllocator
+	allocs, frees int
+}
+
+func (a *countingAllocator) Alloc(size uintptr) unsafe.Pointer {
+	ptr := a.CAllocator.Alloc(size)
+	if ptr != nil {
+		a.allocs++
+	}
+	return ptr
+}
+
+func (a *countingAllocator) Free(ptr unsafe.Pointer) {
+	if ptr != nil {
+		a.frees++
+	}
+	a.CAllocator.Free(ptr)
+}
+
+func TestNewStreamDecoderAlloc(t *testing.T) {
+	in := bytes.Repeat([]byte("Hello\nWorld!\n"), 1000)
+	compressed, err := EasyBufferEncode(PresetDefault, CheckCRC64, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc := &countingAllocator{}
+	stream, err := NewStreamDecoderAlloc(math.MaxUint64, alloc, Concatenated)
+	if err != nil {
+		t.Fatalf("NewStreamDecoderAlloc() error = %v", err)
+	}
+	if got := codeAll(t, stream, compressed); !bytes.Equal(got, in) {
+		t.Errorf("Code() decoded %d bytes, want %d", len(got), len(in))
+	}
+	// codeAll closes the stream.
+	if alloc.allocs == 0 || alloc.allocs != alloc.frees {
+		t.Errorf("allocator made %d allocations and %d frees, want balanced", alloc.allocs, alloc.frees)
+	}
+	if stream.handle != 0 {
+		t.Error("Close() did not release the allocator")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <stdlib.h>
+#include <lzma.h>
+
+lzma_stream stream_init();
+
+// Free the options decoded into a LZMA_VLI_UNKNOWN terminated filter chain.
+void free_filter_options(lzma_filter *filters) {
+	for (int i = 0; i <= LZMA_FILTERS_MAX && filters[i].id != LZMA_VLI_UNKNOWN; i++) {
+		free(filters[i].options);
+		filters[i].options = NULL;
+	}
+	filters[0].id = LZMA_VLI_UNKNOWN;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+const (
+	BlockHeaderSizeMin = 8    // smallest valid block header
+	BlockHeaderSizeMax = 1024 // largest valid block header
+)
+
+// VLIUnknown marks a size that is not known.
+const VLIUnknown = ^uint64(0)
+
+// BlockHeaderSize decodes the size of a block header from its first byte.
+func BlockHeaderSize(b byte) int {
+	return (int(b) + 1) * 4
+}
+
+// Block holds the options of a single .xz block. It is allocated outside the
+// Go heap as liblzma keeps a reference to it while the block is decoded.
+type Block struct {
+	internal *C.lzma_block
+	filters  *C.lzma_filter
+}
+
+// NewBlock allocates an empty Block.
+func NewBlock() *Block {
+	block := &Block{
+		internal: (*C.lzma_block)(C.calloc(1, C.sizeof_lzma_block)),
+		filters:  (*C.lzma_filter)(C.calloc(C.LZMA_FILTERS_MAX+1, C.sizeof_lzma_filter)),
+	}
+	block.filterSlice()[0].id = C.LZMA_VLI_UNKNOWN
+	block.internal.filters = block.filters
+	return block
+}
+
+// DecodeHeader decodes a complete block header from in, whose length must be
+// given by BlockHeaderSize of its first byte. check is the integrity check of
+// the stream the block belongs to.
+func (block *Block) DecodeHeader(in []byte, check Check) Return {
+	C.free_filter_options(block.filters)
+	block.internal.version = 1
+	block.internal.header_size = C.uint32_t(len(in))
+	block.internal.check = C.lzma_check(check)
+	block.internal.filters = block.filters
+	return Return(
+		C.lzma_block_header_decode(
+			block.internal,
+			nil,
+			(*C.uint8_t)(unsafe.SliceData(in)),
+		),
+	)
+}
+
+// SetLZMA2 replaces the options of the block with those of a block of unknown
+// size compressed by a single LZMA2 filter using the given dictionary size,
+// without an integrity check.
+func (block *Block) SetLZMA2(dictSize uint32) {
+	C.free_filter_options(block.filters)
+	options := (*C.lzma_options_lzma)(C.calloc(1, C.sizeof_lzma_options_lzma))
+	options.dict_size = C.uint32_t(dictSize)
+	filters := block.filterSlice()
+	filters[0].id = C.LZMA_FILTER_LZMA2
+	filters[0].options = unsafe.Pointer(options)
+	filters[1].id = C.LZMA_VLI_UNKNOWN
+	block.internal.version = 1
+	block.internal.header_size = BlockHeaderSizeMin
+	block.internal.check = C.LZMA_CHECK_NONE
+	block.internal.compressed_size = C.LZMA_VLI_UNKNOWN
+	block.internal.uncompressed_size = C.LZMA_VLI_UNKNOWN
+	block.internal.filters = block.filters
+}
+
+// SetPreset replaces the options of the block with those of a block to be
+// encoded by a single LZMA2 filter using the compression level preset,
+// optionally combined with PresetExtreme, with the given integrity check.
+func (block *Block) SetPreset(preset uint32, check Check) error {
+	C.free_filter_options(block.filters)
+	options := (*C.lzma_options_lzma)(C.calloc(1, C.sizeof_lzma_options_lzma))
+	if C.lzma_lzma_preset(options, C.uint32_t(preset)) != 0 {
+		C.free(unsafe.Pointer(options))
+		return fmt.Errorf("error unsupported preset %d", preset)
+	}
+	filters := block.filterSlice()
+	filters[0].id = C.LZMA_FILTER_LZMA2
+	filters[0].options = unsafe.Pointer(options)
+	filters[1].id = C.LZMA_VLI_UNKNOWN
+	block.internal.version = 1
+	block.internal.check = C.lzma_check(check)
+	block.internal.filters = block.filters
+	return nil
+}
+
+// BlockBufferEncode compresses in as a single block using the options set by
+// SetPreset. The Block Header stores the Compressed Size and Uncompressed Size
+// of the block, which block also holds on return.
+func BlockBufferEncode(block *Block, in []byte) ([]byte, error) {
+	block.internal.compressed_size = C.LZMA_VLI_UNKNOWN
+	block.internal.uncompressed_size = C.LZMA_VLI_UNKNOWN
+	bound, ok := bufferBound(C.lzma_block_buffer_bound(C.size_t(len(in))))
+	if !ok {
+		return nil, fmt.Errorf("error block buffer encode input of %d bytes too large", len(in))
+	}
+	out := make([]byte, bound)
+	var outPos C.size_t
+	ret := Return(
+		C.lzma_block_buffer_encode(
+			block.internal,
+			nil,
+			(*C.uint8_t)(unsafe.SliceData(in)),
+			C.size_t(len(in)),
+			(*C.uint8_t)(unsafe.SliceData(out)),
+			&outPos,
+			C.size_t(len(out)),
+		),
+	)
+	if ret != Ok {
+		return nil, fmt.Errorf("error block buffer encode code=%v", ret)
+	}
+	return out[:outPos], nil
+}
+
+// SetIgnoreCheck disables verifying the integrity check of the block when it is
+// decoded. It must be set after DecodeHeader.
+func (block *Block) SetIgnoreCheck(ignore bool) {
+	block.internal.ignore_check = 0
+	if ignore {
+		block.internal.ignore_check = 1
+	}
+}
+
+// HeaderSize is the size of the block header.
+func (block *Block) HeaderSize() int {
+	return int(block.internal.header_size)
+}
+
+// Check is the integrity check of the block.
+func (block *Block) Check() Check {
+	return Check(block.internal.check)
+}
+
+// CompressedSize is the size of the Compressed Data field, or VLIUnknown if it
+// was not stored in the header and the block has not been decoded.
+func (block *Block) CompressedSize() uint64 {
+	return uint64(block.internal.compressed_size)
+}
+
+// UncompressedSize is the size of the uncompressed data, or VLIUnknown if it
+// was not stored in the header and the block has not been decoded.
+func (block *Block) UncompressedSize() uint64 {
+	return uint64(block.internal.uncompressed_size)
+}
+
+// UnpaddedSize is the size of the block excluding Block Padding as recorded
+// in the Index, or zero if the sizes are not yet known.
+func (block *Block) UnpaddedSize() uint64 {
+	return uint64(C.lzma_block_unpadded_size(block.internal))
+}
+
+// TotalSize is the size of the whole block including Block Padding, or zero if
+// the sizes are not yet known.
+func (block *Block) TotalSize() uint64 {
+	return uint64(C.lzma_block_total_size(block.internal))
+}
+
+// RawCheck is the integrity check stored after the block data. It is only
+// valid once the block has been decoded.
+func (block *Block) RawCheck() []byte {
+	size := C.lzma_check_size(block.internal.check)
+	return C.GoBytes(unsafe.Pointer(&block.internal.raw_check[0]), C.int(size))
+}
+
+// DecoderMemUsage is the memory required to decode the block, or the max
+// uint64 if its filter chain is unsupported.
+func (block *Block) DecoderMemUsage() uint64 {
+	return uint64(C.lzma_raw_decoder_memusage(block.filters))
+}
+
+// Close frees memory allocated for the Block.
+func (block *Block) Close() error {
+	if block.internal == nil {
+		return nil
+	}
+	C.free_filter_options(block.filters)
+	C.free(unsafe.Pointer(block.filters))
+	C.free(unsafe.Pointer(block.internal))
+	block.internal, block.filters = nil, nil
+	return nil
+}
+
+func (block *Block) filterSlice() []C.lzma_filter {
+	return unsafe.Slice(block.filters, C.LZMA_FILTERS_MAX+1)
+}
+
+// NewStream creates a Stream that has not been initialized as any coder yet.
+func NewStream() *Stream {
+	return &Stream{
+		internal: C.stream_init(),
+	}
+}
+
+// InitBlockDecoder initializes the Stream as a decoder of the Compressed Data,
+// Block Padding and Check of a block whose header was decoded into block. The
+// Stream may be re-initialized for every block to reuse its memory. block must
+// not be closed until the block has been decoded.
+func (stream *Stream) InitBlockDecoder(block *Block) Return {
+	stream.finished, stream.finishing, stream.closed = false, false, false
+	return Return(C.lzma_block_decoder((*C.lzma_stream)(&stream.internal), block.internal))
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <lzma.h>
+
+// get_check is lzma_get_check for a stream that may have no coder, as after
+// lzma_end.
+static lzma_check get_check(const lzma_stream *stream) {
+	if (stream->internal == NULL) {
+		return LZMA_CHECK_NONE;
+	}
+	return lzma_get_check(stream);
+}
+*/
+import "C"
+import (
+	"unsafe"
+)
+
+// Check is the type of integrity check stored in a stream.
+type Check int
+
+const (
+	CheckNone   Check = 0  // no integrity check
+	CheckCRC32  Check = 1  // 32-bit CRC
+	CheckCRC64  Check = 4  // 64-bit CRC
+	CheckSHA256 Check = 10 // SHA-256
+)
+
+// Check returns the integrity check of the stream being decoded. It is only
+// valid once Stream.Code has returned NoCheck, UnsupportedCheck or GetCheck.
+// Once the Stream is closed it is the check when it was closed.
+func (stream *Stream) Check() Check {
+	if stream.closed {
+		return stream.check
+	}
+	return Check(C.get_check((*C.lzma_stream)(&stream.internal)))
+}
+
+// CheckIsSupported reports whether the linked liblzma can calculate check.
+func CheckIsSupported(check Check) bool {
+	return C.lzma_check_is_supported(C.lzma_check(check)) != 0
+}
+
+// CheckSize is the size in bytes of the value of check stored in a block, or -1
+// if check is not a valid check ID. It does not depend on whether the linked
+// liblzma supports check.
+func CheckSize(check Check) int {
+	if check < 0 || check > C.LZMA_CHECK_ID_MAX {
+		return -1
+	}
+	return int(C.lzma_check_size(C.lzma_check(check)))
+}
+
+// CRC32 updates crc with the 32-bit CRC of buf. Pass zero as crc to start a
+// new calculation.
+func CRC32(buf []byte, crc uint32) uint32 {
+	return uint32(C.lzma_crc32((*C.uint8_t)(unsafe.SliceData(buf)), C.size_t(len(buf)), C.uint32_t(crc)))
+}
+
+// CRC64 updates crc with the 64-bit CRC of buf. Pass zero as crc to start a
+// new calculation.
+func CRC64(buf []byte, crc uint64) uint64 {
+	return uint64(C.lzma_crc64((*C.uint8_t)(unsafe.SliceData(buf)), C.size_t(len(buf)), C.uint64_t(crc)))
+}
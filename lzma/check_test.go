@@ -0,0 +1,47 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"testing"
+)
+
+func TestCheckSize(t *testing.T) {
+	tests := []struct {
+		check Check
+		want  int
+	}{
+		{check: CheckNone, want: 0},
+		{check: CheckCRC32, want: 4},
+		{check: CheckCRC64, want: 8},
+		{check: CheckSHA256, want: 32},
+		{check: 16, want: -1},
+	}
+	for _, tt := range tests {
+		if got := CheckSize(tt.check); got != tt.want {
+			t.Errorf("CheckSize(%d) = %d, want %d", tt.check, got, tt.want)
+		}
+	}
+}
+
+func TestNewStreamEncoder_check(t *testing.T) {
+	filters := []Filter{LZMA2Filter(0)}
+	for _, check := range []Check{CheckNone, CheckCRC32, CheckCRC64, CheckSHA256} {
+		if !CheckIsSupported(check) {
+			continue
+		}
+		stream, err := NewStreamEncoder(filters, check)
+		if err != nil {
+			t.Errorf("NewStreamEncoder(%d) error = %v", check, err)
+			continue
+		}
+		_ = stream.Close()
+	}
+	// Check IDs 2 and 3 are reserved for 4 byte checks no liblzma supports.
+	for _, check := range []Check{2, 16} {
+		if _, err := NewStreamEncoder(filters, check); err == nil {
+			t.Errorf("NewStreamEncoder(%d) expected error", check)
+		}
+	}
+}
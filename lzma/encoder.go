@@ -0,0 +1,152 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <lzma.h>
+
+lzma_stream stream_init();
+
+// A liblzma built without threads lacks the multithreaded encoder, so builds
+// for it with the nothreads tag leave it out.
+#ifndef XZ_NO_THREADS
+#define HAS_ENCODER_MT 1
+static lzma_ret stream_encoder_mt(lzma_stream *strm, const lzma_mt *options) {
+	return lzma_stream_encoder_mt(strm, options);
+}
+#else
+#define HAS_ENCODER_MT 0
+static lzma_ret stream_encoder_mt(lzma_stream *strm, const lzma_mt *options) {
+	return LZMA_OPTIONS_ERROR;
+}
+#endif
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+const (
+	PresetDefault = C.LZMA_PRESET_DEFAULT // default compression level
+	PresetExtreme = C.LZMA_PRESET_EXTREME // flag for slower but better compression of a preset
+)
+
+// EasyBufferEncode compresses in to a single .xz stream using the compression
+// level preset, optionally combined with PresetExtreme.
+func EasyBufferEncode(preset uint32, check Check, in []byte) ([]byte, error) {
+	bound, ok := bufferBound(C.lzma_stream_buffer_bound(C.size_t(len(in))))
+	if !ok {
+		return nil, fmt.Errorf("error easy buffer encode input of %d bytes too large", len(in))
+	}
+	out := make([]byte, bound)
+	var outPos C.size_t
+	ret := Return(
+		C.lzma_easy_buffer_encode(
+			C.uint32_t(preset),
+			C.lzma_check(check),
+			nil,
+			(*C.uint8_t)(unsafe.SliceData(in)),
+			C.size_t(len(in)),
+			(*C.uint8_t)(unsafe.SliceData(out)),
+			&outPos,
+			C.size_t(len(out)),
+		),
+	)
+	if ret == BufError {
+		// The output is sized to the worst case so this is a liblzma bug.
+		return nil, fmt.Errorf("error easy buffer encode exceeded bound of %d bytes", len(out))
+	}
+	if ret != Ok {
+		return nil, fmt.Errorf("error easy buffer encode code=%v", ret)
+	}
+	return out[:outPos], nil
+}
+
+// bufferBound converts the worst case output size of a one-shot encoder to an
+// int, reporting false if the size overflowed size_t, as it does for inputs
+// near 4 GiB on 32-bit platforms, or is too large for a slice.
+func bufferBound(bound C.size_t) (int, bool) {
+	if bound == 0 || uint64(bound) > math.MaxInt {
+		return 0, false
+	}
+	return int(bound), true
+}
+
+// NewEasyEncoder initializes a Stream that encodes a single .xz stream using
+// the compression level preset, optionally combined with PresetExtreme.
+func NewEasyEncoder(preset uint32, check Check) (*Stream, error) {
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	ret := Return(
+		C.lzma_easy_encoder(
+			(*C.lzma_stream)(&stream.internal),
+			C.uint32_t(preset),
+			C.lzma_check(check),
+		),
+	)
+	if ret != Ok {
+		return nil, fmt.Errorf("error init easy encoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// NewAloneEncoder initializes a Stream that encodes the legacy .lzma format
+// using the compression level preset, optionally combined with PresetExtreme.
+// The header records the uncompressed size as unknown and the data is
+// terminated by an end of payload marker.
+func NewAloneEncoder(preset uint32) (*Stream, error) {
+	var options C.lzma_options_lzma
+	if C.lzma_lzma_preset(&options, C.uint32_t(preset)) != 0 {
+		return nil, fmt.Errorf("error unsupported preset %d", preset)
+	}
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	ret := Return(C.lzma_alone_encoder((*C.lzma_stream)(&stream.internal), &options))
+	if ret != Ok {
+		return nil, fmt.Errorf("error init alone encoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// MTOptions configures the multithreaded stream encoder.
+type MTOptions struct {
+	Threads   uint32 // number of worker threads
+	BlockSize uint64 // uncompressed size of each block, zero for the default
+	Timeout   uint32 // milliseconds Code may block for, zero to disable
+	Preset    uint32 // compression level
+	Check     Check  // integrity check of the stream
+}
+
+// NewStreamEncoderMT initializes a Stream that encodes a single .xz stream,
+// compressing blocks in parallel. Built with the nothreads tag it fails with
+// OptionsError.
+func NewStreamEncoderMT(options MTOptions) (*Stream, error) {
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	mt := C.lzma_mt{
+		threads:    C.uint32_t(options.Threads),
+		block_size: C.uint64_t(options.BlockSize),
+		timeout:    C.uint32_t(options.Timeout),
+		preset:     C.uint32_t(options.Preset),
+		check:      C.lzma_check(options.Check),
+	}
+	ret := Return(C.stream_encoder_mt((*C.lzma_stream)(&stream.internal), &mt))
+	if ret != Ok {
+		return nil, fmt.Errorf("error init multithreaded stream encoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// HasThreadsSupport reports whether the package was built with the
+// multithreaded encoder. A liblzma built without threads lacks it and fails to
+// link unless the package is built with the nothreads tag, which leaves out
+// the multithreaded encoder and decoder.
+func HasThreadsSupport() bool {
+	return C.HAS_ENCODER_MT != 0
+}
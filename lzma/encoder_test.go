@@ -0,0 +1,204 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"testing/iotest"
+)
+
+// decode decompresses a complete .xz file with a stream decoder.
+func decode(t *testing.T, in []byte) []byte {
+	t.Helper()
+	stream, err := NewStreamDecoder(math.MaxUint64, Concatenated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codeAll(t, stream, in)
+}
+
+// codeAll runs stream over all of in until StreamEnd and returns the output.
+func codeAll(t *testing.T, stream *Stream, in []byte) []byte {
+	t.Helper()
+	defer stream.Close()
+	var out []byte
+	buf := make([]byte, 1024)
+	stream.SetNextIn(in)
+	for {
+		stream.SetNextOut(buf)
+		ret := stream.Code(Finish)
+		out = append(out, buf[:len(buf)-stream.AvailableOut()]...)
+		switch ret {
+		case Ok:
+		case StreamEnd:
+			return out
+		default:
+			t.Fatalf("Code() = %d", ret)
+		}
+	}
+}
+
+func TestEasyBufferEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset uint32
+		check  Check
+		in     []byte
+	}{
+		{name: "empty", preset: PresetDefault, check: CheckCRC64, in: nil},
+		{name: "text", preset: 0, check: CheckCRC32, in: []byte("Hello\nWorld!\n")},
+		{name: "extreme", preset: 9 | PresetExtreme, check: CheckSHA256, in: bytes.Repeat([]byte("xz"), 1<<16)},
+		{name: "incompressible", preset: 1, check: CheckNone, in: incompressible(1 << 12)},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := EasyBufferEncode(tt.preset, tt.check, tt.in)
+				if err != nil {
+					t.Fatalf("EasyBufferEncode() error = %v", err)
+				}
+				if out := decode(t, got); !bytes.Equal(out, tt.in) {
+					t.Errorf("EasyBufferEncode() decoded %d bytes, want %d", len(out), len(tt.in))
+				}
+			},
+		)
+	}
+}
+
+// incompressible returns n bytes of deterministic noise.
+func incompressible(n int) []byte {
+	b := make([]byte, n)
+	x := uint32(2463534242)
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = byte(x)
+	}
+	return b
+}
+
+func TestStream_Total(t *testing.T) {
+	in := []byte("Hello\nWorld!\n")
+	compressed, err := EasyBufferEncode(PresetDefault, CheckCRC64, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := NewStreamDecoder(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeAll(t, stream, compressed)
+	if stream.TotalIn() != uint64(len(compressed)) || stream.TotalOut() != uint64(len(in)) {
+		t.Errorf(
+			"TotalIn(), TotalOut() = %d, %d, want %d, %d",
+			stream.TotalIn(), stream.TotalOut(), len(compressed), len(in),
+		)
+	}
+}
+
+func TestStream_NeedsInput(t *testing.T) {
+	in := []byte("Hello\nWorld!\n")
+	compressed, err := EasyBufferEncode(PresetDefault, CheckCRC64, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := NewStreamDecoder(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if !stream.NeedsInput() || stream.HasOutputSpace() {
+		t.Fatalf("NeedsInput(), HasOutputSpace() = %v, %v before any input, want true, false", stream.NeedsInput(), stream.HasOutputSpace())
+	}
+
+	// Only the header is given so the decoder needs more input.
+	out := make([]byte, 5)
+	stream.SetNextIn(compressed[:20])
+	stream.SetNextOut(out)
+	if stream.NeedsInput() || !stream.HasOutputSpace() {
+		t.Fatalf("NeedsInput(), HasOutputSpace() = %v, %v after setting buffers, want false, true", stream.NeedsInput(), stream.HasOutputSpace())
+	}
+	if ret := stream.Code(Run); ret != Ok {
+		t.Fatalf("Code() = %d, want Ok", ret)
+	}
+	if !stream.NeedsInput() {
+		t.Error("NeedsInput() = false after consuming all input")
+	}
+
+	// The output fills before the rest of the input is consumed.
+	stream.SetNextIn(compressed[20:])
+	if ret := stream.Code(Run); ret != Ok {
+		t.Fatalf("Code() = %d, want Ok", ret)
+	}
+	if stream.HasOutputSpace() || stream.NeedsInput() {
+		t.Fatalf("NeedsInput(), HasOutputSpace() = %v, %v with full output, want false, false", stream.NeedsInput(), stream.HasOutputSpace())
+	}
+
+	// Once finished no more input is needed.
+	stream.SetNextOut(make([]byte, 64))
+	if ret := stream.Code(Finish); ret != StreamEnd {
+		t.Fatalf("Code() = %d, want StreamEnd", ret)
+	}
+	if stream.NeedsInput() || !stream.HasOutputSpace() {
+		t.Errorf("NeedsInput(), HasOutputSpace() = %v, %v at the end, want false, true", stream.NeedsInput(), stream.HasOutputSpace())
+	}
+}
+
+func TestStream_SignalEnd(t *testing.T) {
+	in := []byte("Hello\nWorld!\n")
+	compressed, err := EasyBufferEncode(PresetDefault, CheckCRC64, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A concatenated decoder only ends once told the input has ended.
+	stream, err := NewStreamDecoder(math.MaxUint64, Concatenated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	src := iotest.HalfReader(bytes.NewReader(compressed))
+	inBuf, outBuf := make([]byte, 16), make([]byte, 4)
+	var out []byte
+	for {
+		if stream.NeedsInput() && !stream.Finishing() {
+			n, err := src.Read(inBuf)
+			if err == io.EOF {
+				stream.SignalEnd()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			stream.SetNextIn(inBuf[:n])
+		}
+		stream.SetNextOut(outBuf)
+		ret := stream.Code(Run)
+		out = append(out, outBuf[:len(outBuf)-stream.AvailableOut()]...)
+		if ret == StreamEnd {
+			break
+		}
+		if ret != Ok {
+			t.Fatalf("Code() = %d", ret)
+		}
+	}
+	if !bytes.Equal(out, in) {
+		t.Errorf("decoded %q, want %q", out, in)
+	}
+}
+
+func TestHasThreadsSupport(t *testing.T) {
+	got := HasThreadsSupport()
+	if HasThreadsSupport() != got {
+		t.Error("HasThreadsSupport() changed between calls")
+	}
+	stream, err := NewStreamEncoderMT(MTOptions{Threads: 2, Preset: 1, Check: CheckCRC32})
+	if err == nil {
+		_ = stream.Close()
+	}
+	if want := err == nil; got != want {
+		t.Errorf("HasThreadsSupport() = %v, want %v as NewStreamEncoderMT() error = %v", got, want, err)
+	}
+}
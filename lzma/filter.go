@@ -0,0 +1,324 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <stdlib.h>
+#include <lzma.h>
+
+lzma_stream stream_init();
+void free_filter_options(lzma_filter *filters);
+
+// Filter IDs are fixed by the .xz format so those added by newer liblzma are
+// defined here to build against older headers. The linked liblzma reports
+// them as unsupported.
+#ifndef LZMA_FILTER_ARM64
+#define LZMA_FILTER_ARM64 LZMA_VLI_C(0x0A)
+#endif
+#ifndef LZMA_FILTER_RISCV
+#define LZMA_FILTER_RISCV LZMA_VLI_C(0x0B)
+#endif
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// FilterID identifies a filter in the .xz format.
+type FilterID uint64
+
+const (
+	FilterLZMA1    FilterID = C.LZMA_FILTER_LZMA1    // LZMA1, only for the .lzma format
+	FilterLZMA2    FilterID = C.LZMA_FILTER_LZMA2    // LZMA2
+	FilterDelta    FilterID = C.LZMA_FILTER_DELTA    // delta encoding of bytes a fixed distance apart
+	FilterX86      FilterID = C.LZMA_FILTER_X86      // BCJ for x86 and x86-64
+	FilterPowerPC  FilterID = C.LZMA_FILTER_POWERPC  // BCJ for big endian PowerPC
+	FilterIA64     FilterID = C.LZMA_FILTER_IA64     // BCJ for Itanium
+	FilterARM      FilterID = C.LZMA_FILTER_ARM      // BCJ for ARM
+	FilterARMThumb FilterID = C.LZMA_FILTER_ARMTHUMB // BCJ for ARM-Thumb
+	FilterSPARC    FilterID = C.LZMA_FILTER_SPARC    // BCJ for SPARC
+	FilterARM64    FilterID = C.LZMA_FILTER_ARM64    // BCJ for ARM64. Since liblzma 5.4.0
+	FilterRISCV    FilterID = C.LZMA_FILTER_RISCV    // BCJ for RISC-V. Since liblzma 5.6.0
+)
+
+// FiltersMax is the maximum number of filters in a filter chain.
+const FiltersMax = C.LZMA_FILTERS_MAX
+
+// Mode is the compression mode of the LZMA1 and LZMA2 encoders.
+type Mode int
+
+const (
+	ModeFast   Mode = C.LZMA_MODE_FAST   // fast mode, used by the lower presets
+	ModeNormal Mode = C.LZMA_MODE_NORMAL // normal mode, slower but compresses better
+)
+
+// MatchFinder is the match finder of the LZMA1 and LZMA2 encoders.
+type MatchFinder int
+
+const (
+	MatchFinderHC3 MatchFinder = C.LZMA_MF_HC3 // hash chain with 2- and 3-byte hashing
+	MatchFinderHC4 MatchFinder = C.LZMA_MF_HC4 // hash chain with 2-, 3- and 4-byte hashing
+	MatchFinderBT2 MatchFinder = C.LZMA_MF_BT2 // binary tree with 2-byte hashing
+	MatchFinderBT3 MatchFinder = C.LZMA_MF_BT3 // binary tree with 2- and 3-byte hashing
+	MatchFinderBT4 MatchFinder = C.LZMA_MF_BT4 // binary tree with 2-, 3- and 4-byte hashing
+)
+
+// LZMA2Options are the options of the LZMA1 and LZMA2 filters.
+type LZMA2Options struct {
+	DictSize    uint32 // dictionary size in bytes
+	LC          uint32 // number of literal context bits
+	LP          uint32 // number of literal position bits
+	PB          uint32 // number of position bits
+	Mode        Mode
+	NiceLen     uint32 // length of a match that is good enough to stop searching
+	MatchFinder MatchFinder
+	Depth       uint32 // maximum search depth of the match finder, zero for automatic
+}
+
+// PresetOptions returns the options of the compression level preset,
+// optionally combined with PresetExtreme, to be modified and set as the
+// Options of a Filter.
+func PresetOptions(preset uint32) (*LZMA2Options, error) {
+	var options C.lzma_options_lzma
+	if C.lzma_lzma_preset(&options, C.uint32_t(preset)) != 0 {
+		return nil, fmt.Errorf("error unsupported preset %d", preset)
+	}
+	return &LZMA2Options{
+		DictSize:    uint32(options.dict_size),
+		LC:          uint32(options.lc),
+		LP:          uint32(options.lp),
+		PB:          uint32(options.pb),
+		Mode:        Mode(options.mode),
+		NiceLen:     uint32(options.nice_len),
+		MatchFinder: MatchFinder(options.mf),
+		Depth:       uint32(options.depth),
+	}, nil
+}
+
+// RecommendedDictSize returns the smallest power of two dictionary size that
+// holds inputSize bytes, between the minimum dictionary size of liblzma and the
+// dictionary size of PresetDefault. A dictionary larger than the input only
+// wastes memory of the encoder and decoder so it can be set as the DictSize of
+// the LZMA2Options to compress small inputs.
+func RecommendedDictSize(inputSize uint64) uint32 {
+	size := uint32(C.LZMA_DICT_SIZE_MIN)
+	for size < C.LZMA_DICT_SIZE_DEFAULT && uint64(size) < inputSize {
+		size <<= 1
+	}
+	return size
+}
+
+// Filter is a filter of a filter chain and its options.
+type Filter struct {
+	ID     FilterID
+	Preset uint32 // compression level of FilterLZMA1 and FilterLZMA2
+	// Options replaces Preset of FilterLZMA1 and FilterLZMA2 if set.
+	Options *LZMA2Options
+	// StartOffset is the offset of the start of the data for BCJ filters, when
+	// the filtered data does not begin at offset zero of the executable. It
+	// must be a multiple of the instruction alignment of the filter.
+	StartOffset uint32
+	// Distance is the distance in bytes, from 1 to 256, of FilterDelta. Zero
+	// is one.
+	Distance uint32
+}
+
+// LZMA2Filter returns a LZMA2 filter using the compression level preset,
+// optionally combined with PresetExtreme.
+func LZMA2Filter(preset uint32) Filter {
+	return Filter{ID: FilterLZMA2, Preset: preset}
+}
+
+// DeltaFilter returns a delta filter that stores the difference of every byte
+// from the byte distance bytes before it, from 1 to 256, to precede LZMA2 for
+// data such as samples of a fixed width.
+func DeltaFilter(distance uint32) Filter {
+	return Filter{ID: FilterDelta, Distance: distance}
+}
+
+// X86Filter returns a BCJ filter for x86 and x86-64 executables.
+func X86Filter() Filter {
+	return Filter{ID: FilterX86}
+}
+
+// PowerPCFilter returns a BCJ filter for big endian PowerPC executables.
+func PowerPCFilter() Filter {
+	return Filter{ID: FilterPowerPC}
+}
+
+// IA64Filter returns a BCJ filter for Itanium executables.
+func IA64Filter() Filter {
+	return Filter{ID: FilterIA64}
+}
+
+// ARMFilter returns a BCJ filter for ARM executables.
+func ARMFilter() Filter {
+	return Filter{ID: FilterARM}
+}
+
+// ARMThumbFilter returns a BCJ filter for ARM-Thumb executables.
+func ARMThumbFilter() Filter {
+	return Filter{ID: FilterARMThumb}
+}
+
+// SPARCFilter returns a BCJ filter for SPARC executables.
+func SPARCFilter() Filter {
+	return Filter{ID: FilterSPARC}
+}
+
+// ARM64Filter returns a BCJ filter for ARM64 executables. It requires liblzma
+// 5.4.0 or later.
+func ARM64Filter() Filter {
+	return Filter{ID: FilterARM64}
+}
+
+// RISCVFilter returns a BCJ filter for RISC-V executables. It requires liblzma
+// 5.6.0 or later.
+func RISCVFilter() Filter {
+	return Filter{ID: FilterRISCV}
+}
+
+// X86FilterOffset returns an X86Filter for code that starts at offset start
+// of the executable. Any offset is valid as x86 instructions are not aligned.
+func X86FilterOffset(start uint32) Filter {
+	return Filter{ID: FilterX86, StartOffset: start}
+}
+
+// PowerPCFilterOffset returns a PowerPCFilter for code that starts at offset
+// start of the executable, which must be a multiple of 4.
+func PowerPCFilterOffset(start uint32) Filter {
+	return Filter{ID: FilterPowerPC, StartOffset: start}
+}
+
+// IA64FilterOffset returns an IA64Filter for code that starts at offset start
+// of the executable, which must be a multiple of the 16 byte instruction
+// bundles.
+func IA64FilterOffset(start uint32) Filter {
+	return Filter{ID: FilterIA64, StartOffset: start}
+}
+
+// ARMFilterOffset returns an ARMFilter for code that starts at offset start of
+// the executable, which must be a multiple of 4.
+func ARMFilterOffset(start uint32) Filter {
+	return Filter{ID: FilterARM, StartOffset: start}
+}
+
+// ARMThumbFilterOffset returns an ARMThumbFilter for code that starts at offset
+// start of the executable, which must be a multiple of 2.
+func ARMThumbFilterOffset(start uint32) Filter {
+	return Filter{ID: FilterARMThumb, StartOffset: start}
+}
+
+// SPARCFilterOffset returns a SPARCFilter for code that starts at offset start
+// of the executable, which must be a multiple of 4.
+func SPARCFilterOffset(start uint32) Filter {
+	return Filter{ID: FilterSPARC, StartOffset: start}
+}
+
+// ARM64FilterOffset returns an ARM64Filter for code that starts at offset start
+// of the executable, which must be a multiple of 4. Like ARM64Filter, it
+// requires liblzma 5.4.0 or later.
+func ARM64FilterOffset(start uint32) Filter {
+	return Filter{ID: FilterARM64, StartOffset: start}
+}
+
+// RISCVFilterOffset returns a RISCVFilter for code that starts at offset start
+// of the executable, which must be a multiple of 2. Like RISCVFilter, it
+// requires liblzma 5.6.0 or later.
+func RISCVFilterOffset(start uint32) Filter {
+	return Filter{ID: FilterRISCV, StartOffset: start}
+}
+
+// FilterEncoderIsSupported reports whether the linked liblzma can encode with
+// the filter.
+func FilterEncoderIsSupported(id FilterID) bool {
+	return C.lzma_filter_encoder_is_supported(C.lzma_vli(id)) != 0
+}
+
+// FilterDecoderIsSupported reports whether the linked liblzma can decode data
+// encoded with the filter.
+func FilterDecoderIsSupported(id FilterID) bool {
+	return C.lzma_filter_decoder_is_supported(C.lzma_vli(id)) != 0
+}
+
+// newFilterChain allocates a LZMA_VLI_UNKNOWN terminated filter chain for
+// encoding outside the Go heap. It must be freed with freeFilterChain.
+func newFilterChain(filters []Filter) (*C.lzma_filter, error) {
+	if len(filters) == 0 || len(filters) > C.LZMA_FILTERS_MAX {
+		return nil, fmt.Errorf("error filter chain of %d filters", len(filters))
+	}
+	for _, filter := range filters {
+		if !FilterEncoderIsSupported(filter.ID) {
+			return nil, fmt.Errorf("error filter id=%#x unsupported by linked liblzma", uint64(filter.ID))
+		}
+	}
+	chain := (*C.lzma_filter)(C.calloc(C.LZMA_FILTERS_MAX+1, C.sizeof_lzma_filter))
+	chainSlice := unsafe.Slice(chain, C.LZMA_FILTERS_MAX+1)
+	for i, filter := range filters {
+		chainSlice[i].id = C.lzma_vli(filter.ID)
+		if filter.ID == FilterLZMA1 || filter.ID == FilterLZMA2 {
+			options := (*C.lzma_options_lzma)(C.calloc(1, C.sizeof_lzma_options_lzma))
+			chainSlice[i].options = unsafe.Pointer(options)
+			if filter.Options != nil {
+				options.dict_size = C.uint32_t(filter.Options.DictSize)
+				options.lc = C.uint32_t(filter.Options.LC)
+				options.lp = C.uint32_t(filter.Options.LP)
+				options.pb = C.uint32_t(filter.Options.PB)
+				options.mode = C.lzma_mode(filter.Options.Mode)
+				options.nice_len = C.uint32_t(filter.Options.NiceLen)
+				options.mf = C.lzma_match_finder(filter.Options.MatchFinder)
+				options.depth = C.uint32_t(filter.Options.Depth)
+			} else if C.lzma_lzma_preset(options, C.uint32_t(filter.Preset)) != 0 {
+				chainSlice[i+1].id = C.LZMA_VLI_UNKNOWN
+				freeFilterChain(chain)
+				return nil, fmt.Errorf("error unsupported preset %d", filter.Preset)
+			}
+		} else if filter.ID == FilterDelta {
+			options := (*C.lzma_options_delta)(C.calloc(1, C.sizeof_lzma_options_delta))
+			options._type = C.LZMA_DELTA_TYPE_BYTE
+			options.dist = C.uint32_t(max(filter.Distance, 1))
+			chainSlice[i].options = unsafe.Pointer(options)
+		} else if filter.StartOffset != 0 {
+			options := (*C.lzma_options_bcj)(C.calloc(1, C.sizeof_lzma_options_bcj))
+			options.start_offset = C.uint32_t(filter.StartOffset)
+			chainSlice[i].options = unsafe.Pointer(options)
+		}
+	}
+	chainSlice[len(filters)].id = C.LZMA_VLI_UNKNOWN
+	return chain, nil
+}
+
+func freeFilterChain(chain *C.lzma_filter) {
+	C.free_filter_options(chain)
+	C.free(unsafe.Pointer(chain))
+}
+
+// NewStreamEncoder initializes a Stream that encodes a single .xz stream with
+// the given filter chain. The last filter must be FilterLZMA2.
+func NewStreamEncoder(filters []Filter, check Check) (*Stream, error) {
+	if CheckSize(check) < 0 || !CheckIsSupported(check) {
+		return nil, fmt.Errorf("error check id=%d unsupported by linked liblzma", check)
+	}
+	chain, err := newFilterChain(filters)
+	if err != nil {
+		return nil, err
+	}
+	// The encoder copies the filter chain when initialized.
+	defer freeFilterChain(chain)
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	ret := Return(
+		C.lzma_stream_encoder(
+			(*C.lzma_stream)(&stream.internal),
+			chain,
+			C.lzma_check(check),
+		),
+	)
+	if ret != Ok {
+		return nil, fmt.Errorf("error init stream encoder code=%v", ret)
+	}
+	return &stream, nil
+}
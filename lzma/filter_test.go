@@ -0,0 +1,144 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewStreamEncoder_bcj(t *testing.T) {
+	in := append(incompressible(1<<12), bytes.Repeat([]byte{0x97, 0x00, 0x00, 0x00, 0xe7, 0x80, 0x00, 0x00}, 1<<10)...)
+	tests := []struct {
+		name   string
+		filter Filter
+	}{
+		{name: "x86", filter: X86Filter()},
+		{name: "ARM64", filter: ARM64Filter()},
+		{name: "RISC-V", filter: RISCVFilter()},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stream, err := NewStreamEncoder([]Filter{tt.filter, LZMA2Filter(PresetDefault)}, CheckCRC64)
+				if !FilterEncoderIsSupported(tt.filter.ID) {
+					if err == nil || !strings.Contains(err.Error(), "unsupported by linked liblzma") {
+						t.Fatalf("NewStreamEncoder() error = %v, want unsupported filter", err)
+					}
+					t.Skipf("filter %#x unsupported by linked liblzma", uint64(tt.filter.ID))
+				}
+				if err != nil {
+					t.Fatalf("NewStreamEncoder() error = %v", err)
+				}
+				if got := decode(t, codeAll(t, stream, in)); !bytes.Equal(got, in) {
+					t.Errorf("decoded %d bytes, want %d", len(got), len(in))
+				}
+			},
+		)
+	}
+}
+
+func TestNewStreamEncoder_bcjStartOffset(t *testing.T) {
+	// x86 CALL and ARM-Thumb BL instructions whose targets are converted
+	// relative to their position, which depends on the start offset.
+	code := bytes.Repeat([]byte{0xe8, 0x10, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x00, 0xf8}, 1<<10)
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr bool
+	}{
+		{name: "x86", filter: X86FilterOffset(0x1000)},
+		{name: "ARM-Thumb", filter: ARMThumbFilterOffset(0x8000)},
+		{name: "ARM unaligned", filter: ARMFilterOffset(2), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stream, err := NewStreamEncoder([]Filter{tt.filter, LZMA2Filter(PresetDefault)}, CheckCRC64)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("NewStreamEncoder() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if got := decode(t, codeAll(t, stream, code)); !bytes.Equal(got, code) {
+					t.Errorf("decoded %d bytes, want %d", len(got), len(code))
+				}
+			},
+		)
+	}
+}
+
+func TestFilterIsSupported(t *testing.T) {
+	if !FilterEncoderIsSupported(FilterLZMA2) || !FilterDecoderIsSupported(FilterLZMA2) {
+		t.Error("FilterLZMA2 is not supported")
+	}
+	const bogus FilterID = 0x4000000000000000
+	if FilterEncoderIsSupported(bogus) || FilterDecoderIsSupported(bogus) {
+		t.Errorf("filter %#x is supported", uint64(bogus))
+	}
+}
+
+func TestRecommendedDictSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputSize uint64
+		want      uint32
+	}{
+		{name: "empty", inputSize: 0, want: 4 << 10},
+		{name: "10KB", inputSize: 10_000, want: 16 << 10},
+		{name: "power of two", inputSize: 64 << 10, want: 64 << 10},
+		{name: "100MB", inputSize: 100_000_000, want: 8 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RecommendedDictSize(tt.inputSize); got != tt.want {
+				t.Errorf("RecommendedDictSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	options, err := PresetOptions(PresetDefault)
+	if err != nil {
+		t.Fatalf("PresetOptions() error = %v", err)
+	}
+	if got := RecommendedDictSize(1 << 40); got != options.DictSize {
+		t.Errorf("RecommendedDictSize() = %d, want preset default %d", got, options.DictSize)
+	}
+}
+
+func TestPresetOptions(t *testing.T) {
+	options, err := PresetOptions(6)
+	if err != nil {
+		t.Fatalf("PresetOptions() error = %v", err)
+	}
+	if options.DictSize != 8<<20 {
+		t.Errorf("PresetOptions() DictSize = %d, want %d", options.DictSize, 8<<20)
+	}
+	extreme, err := PresetOptions(6 | PresetExtreme)
+	if err != nil {
+		t.Fatalf("PresetOptions() error = %v", err)
+	}
+	if extreme.Depth == options.Depth {
+		t.Errorf("PresetOptions() extreme Depth = %d, want other than %d", extreme.Depth, options.Depth)
+	}
+	if _, err := PresetOptions(10); err == nil {
+		t.Error("PresetOptions() expected error for preset 10")
+	}
+
+	options.NiceLen = 273
+	in := bytes.Repeat([]byte("Hello\nWorld!\n"), 1<<10)
+	stream, err := NewStreamEncoder([]Filter{{ID: FilterLZMA2, Options: options}}, CheckCRC64)
+	if err != nil {
+		t.Fatalf("NewStreamEncoder() error = %v", err)
+	}
+	if got := decode(t, codeAll(t, stream, in)); !bytes.Equal(got, in) {
+		t.Errorf("decoded %d bytes, want %d", len(got), len(in))
+	}
+
+	options.LC, options.LP = 4, 4
+	if _, err := NewStreamEncoder([]Filter{{ID: FilterLZMA2, Options: options}}, CheckCRC64); err == nil {
+		t.Error("NewStreamEncoder() expected error for lc + lp > 4")
+	}
+}
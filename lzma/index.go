@@ -0,0 +1,304 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <stdlib.h>
+#include <lzma.h>
+
+lzma_stream stream_init();
+
+// The file info decoder and the seek_pos field of lzma_stream were added in
+// liblzma 5.4.0. Older versions report the decoder as unsupported.
+#if LZMA_VERSION >= 50040002
+#define HAS_FILE_INFO_DECODER 1
+static lzma_ret file_info_decoder(lzma_stream *strm, lzma_index **dest, uint64_t memlimit, uint64_t file_size) {
+	return lzma_file_info_decoder(strm, dest, memlimit, file_size);
+}
+static uint64_t seek_pos(lzma_stream *strm) {
+	return strm->seek_pos;
+}
+#else
+#define HAS_FILE_INFO_DECODER 0
+static lzma_ret file_info_decoder(lzma_stream *strm, lzma_index **dest, uint64_t memlimit, uint64_t file_size) {
+	return LZMA_OPTIONS_ERROR;
+}
+static uint64_t seek_pos(lzma_stream *strm) {
+	return 0;
+}
+#endif
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Index holds the block and stream records of one or more .xz streams.
+type Index struct {
+	internal *C.lzma_index
+}
+
+// NewIndex allocates an empty Index of a single stream.
+func NewIndex() (*Index, error) {
+	internal := C.lzma_index_init(nil)
+	if internal == nil {
+		return nil, fmt.Errorf("error init index code=%v", MemError)
+	}
+	return &Index{internal: internal}, nil
+}
+
+// NewFileInfoDecoder initializes a Stream that decodes the Index of every
+// stream in a .xz file of the given size. The decoder returns SeekNeeded when
+// the caller must seek the input to Stream.SeekPos before continuing. Once
+// StreamEnd is returned the decoded Index is available from Stream.Index. It
+// requires liblzma 5.4.0 or later, as reported by HasFileInfoDecoder, and
+// fails with OptionsError otherwise.
+func NewFileInfoDecoder(memlimit uint64, fileSize uint64) (*Stream, error) {
+	stream := Stream{
+		internal: C.stream_init(),
+		index:    (**C.lzma_index)(C.calloc(1, C.size_t(unsafe.Sizeof((*C.lzma_index)(nil))))),
+	}
+	ret := Return(
+		C.file_info_decoder(
+			(*C.lzma_stream)(&stream.internal),
+			stream.index,
+			C.uint64_t(memlimit),
+			C.uint64_t(fileSize),
+		),
+	)
+	if ret != Ok {
+		C.free(unsafe.Pointer(stream.index))
+		return nil, fmt.Errorf("error init file info decoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// HasFileInfoDecoder reports whether the linked liblzma provides the decoder
+// of NewFileInfoDecoder, which was added in liblzma 5.4.0.
+func HasFileInfoDecoder() bool {
+	return C.HAS_FILE_INFO_DECODER != 0
+}
+
+// SeekPos is the input position requested by the decoder when Stream.Code
+// returns SeekNeeded.
+func (stream *Stream) SeekPos() uint64 {
+	return uint64(C.seek_pos((*C.lzma_stream)(&stream.internal)))
+}
+
+// Index returns the Index decoded by a file info decoder after it has returned
+// StreamEnd, or nil otherwise. The caller owns the returned Index and must
+// Close it.
+func (stream *Stream) Index() *Index {
+	if stream.index == nil || *stream.index == nil {
+		return nil
+	}
+	index := &Index{internal: *stream.index}
+	*stream.index = nil
+	return index
+}
+
+// IndexBlock describes a block recorded in an Index. Offsets are from the start
+// of the file, sizes include the block header, padding and check.
+type IndexBlock struct {
+	CompressedFileOffset   uint64
+	UncompressedFileOffset uint64
+	TotalSize              uint64
+	UnpaddedSize           uint64
+	UncompressedSize       uint64
+}
+
+// Blocks returns the records of every block in the Index, in the order of the
+// file.
+func (index *Index) Blocks() []IndexBlock {
+	blocks := make([]IndexBlock, 0, index.BlockCount())
+	var iter C.lzma_index_iter
+	C.lzma_index_iter_init(&iter, index.internal)
+	for C.lzma_index_iter_next(&iter, C.LZMA_INDEX_ITER_BLOCK) == 0 {
+		blocks = append(
+			blocks, IndexBlock{
+				CompressedFileOffset:   uint64(iter.block.compressed_file_offset),
+				UncompressedFileOffset: uint64(iter.block.uncompressed_file_offset),
+				TotalSize:              uint64(iter.block.total_size),
+				UnpaddedSize:           uint64(iter.block.unpadded_size),
+				UncompressedSize:       uint64(iter.block.uncompressed_size),
+			},
+		)
+	}
+	return blocks
+}
+
+// UncompressedSize is the total uncompressed size of all streams.
+func (index *Index) UncompressedSize() uint64 {
+	return uint64(C.lzma_index_uncompressed_size(index.internal))
+}
+
+// FileSize is the total size of the .xz file described by the Index.
+func (index *Index) FileSize() uint64 {
+	return uint64(C.lzma_index_file_size(index.internal))
+}
+
+// StreamCount is the number of streams in the Index.
+func (index *Index) StreamCount() uint64 {
+	return uint64(C.lzma_index_stream_count(index.internal))
+}
+
+// BlockCount is the number of blocks in the Index.
+func (index *Index) BlockCount() uint64 {
+	return uint64(C.lzma_index_block_count(index.internal))
+}
+
+// Size is the size of the Index field of the last stream in the Index.
+func (index *Index) Size() uint64 {
+	return uint64(C.lzma_index_size(index.internal))
+}
+
+// AppendBlock records a block of the last stream in the Index. It fails with
+// DataError if the sizes, or the totals of the Index, would overflow.
+func (index *Index) AppendBlock(unpaddedSize, uncompressedSize uint64) error {
+	ret := Return(
+		C.lzma_index_append(
+			index.internal,
+			nil,
+			C.lzma_vli(unpaddedSize),
+			C.lzma_vli(uncompressedSize),
+		),
+	)
+	if ret != Ok {
+		return fmt.Errorf("error index append code=%v", ret)
+	}
+	return nil
+}
+
+// IndexCat appends the streams of src to dst, separated by padding bytes of
+// Stream Padding, which must be a multiple of four. On success src is consumed
+// and must not be used, though it may still be closed.
+func IndexCat(dst, src *Index, padding uint64) error {
+	ret := Return(C.lzma_index_stream_padding(dst.internal, C.lzma_vli(padding)))
+	if ret != Ok {
+		return fmt.Errorf("error index stream padding code=%v", ret)
+	}
+	ret = Return(C.lzma_index_cat(dst.internal, src.internal, nil))
+	if ret != Ok {
+		return fmt.Errorf("error index cat code=%v", ret)
+	}
+	src.internal = nil
+	return nil
+}
+
+// IndexDup returns a copy of src, or nil if memory could not be allocated. The
+// caller owns the returned Index and must Close it.
+func IndexDup(src *Index) *Index {
+	internal := C.lzma_index_dup(src.internal, nil)
+	if internal == nil {
+		return nil
+	}
+	return &Index{internal: internal}
+}
+
+// IndexBufferEncode encodes the Index field of index into out, which should be
+// at least Index.Size bytes, returning the number of bytes written.
+func IndexBufferEncode(index *Index, out []byte) (int, error) {
+	var outPos C.size_t
+	ret := Return(
+		C.lzma_index_buffer_encode(
+			index.internal,
+			(*C.uint8_t)(unsafe.SliceData(out)),
+			&outPos,
+			C.size_t(len(out)),
+		),
+	)
+	if ret != Ok {
+		return 0, fmt.Errorf("error index buffer encode code=%v", ret)
+	}
+	return int(outPos), nil
+}
+
+// IndexBufferDecode decodes an Index field from the start of in, returning the
+// Index and the number of bytes read. The caller owns the returned Index and
+// must Close it.
+func IndexBufferDecode(in []byte, memlimit uint64) (*Index, int, error) {
+	var internal *C.lzma_index
+	var inPos C.size_t
+	limit := C.uint64_t(memlimit)
+	ret := Return(
+		C.lzma_index_buffer_decode(
+			&internal,
+			&limit,
+			nil,
+			(*C.uint8_t)(unsafe.SliceData(in)),
+			&inPos,
+			C.size_t(len(in)),
+		),
+	)
+	if ret != Ok {
+		return nil, 0, fmt.Errorf("error index buffer decode code=%v", ret)
+	}
+	return &Index{internal: internal}, int(inPos), nil
+}
+
+// Close frees memory allocated for the Index.
+func (index *Index) Close() error {
+	if index.internal == nil {
+		return nil
+	}
+	C.lzma_index_end(index.internal, nil)
+	index.internal = nil
+	return nil
+}
+
+// IndexHash verifies an Index field against the blocks of a stream without
+// keeping a record of every block in memory.
+type IndexHash struct {
+	internal *C.lzma_index_hash
+}
+
+// NewIndexHash allocates an empty IndexHash.
+func NewIndexHash() *IndexHash {
+	return &IndexHash{internal: C.lzma_index_hash_init(nil, nil)}
+}
+
+// Reset clears the IndexHash to verify the Index of another stream.
+func (hash *IndexHash) Reset() {
+	hash.internal = C.lzma_index_hash_init(hash.internal, nil)
+}
+
+// Append records a decoded block. It must not be called once Decode has been.
+func (hash *IndexHash) Append(unpaddedSize, uncompressedSize uint64) Return {
+	return Return(
+		C.lzma_index_hash_append(
+			hash.internal,
+			C.lzma_vli(unpaddedSize),
+			C.lzma_vli(uncompressedSize),
+		),
+	)
+}
+
+// Decode consumes the Index field from in, returning the number of bytes read.
+// StreamEnd is returned once the whole Index has been decoded and matches the
+// appended blocks, and DataError if it does not.
+func (hash *IndexHash) Decode(in []byte) (int, Return) {
+	var pos C.size_t
+	ret := Return(
+		C.lzma_index_hash_decode(
+			hash.internal,
+			(*C.uint8_t)(unsafe.SliceData(in)),
+			&pos,
+			C.size_t(len(in)),
+		),
+	)
+	return int(pos), ret
+}
+
+// Size is the size of the Index field computed from the appended blocks.
+func (hash *IndexHash) Size() uint64 {
+	return uint64(C.lzma_index_hash_size(hash.internal))
+}
+
+// Close frees memory allocated for the IndexHash.
+func (hash *IndexHash) Close() error {
+	C.lzma_index_hash_end(hash.internal, nil)
+	hash.internal = nil
+	return nil
+}
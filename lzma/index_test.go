@@ -0,0 +1,126 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHasFileInfoDecoder(t *testing.T) {
+	stream, err := NewFileInfoDecoder(math.MaxUint64, 0)
+	if err == nil {
+		_ = stream.Close()
+	}
+	if got, want := HasFileInfoDecoder(), err == nil; got != want {
+		t.Errorf("HasFileInfoDecoder() = %v, want %v as NewFileInfoDecoder() error = %v", got, want, err)
+	}
+}
+
+func TestIndexBufferEncode(t *testing.T) {
+	index, err := NewIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	// The blocks of good-2-lzma2.xz.
+	for _, size := range [][2]uint64{{26, 6}, {27, 7}} {
+		if err := index.AppendBlock(size[0], size[1]); err != nil {
+			t.Fatalf("AppendBlock() error = %v", err)
+		}
+	}
+	out := make([]byte, index.Size())
+	n, err := IndexBufferEncode(index, out)
+	if err != nil {
+		t.Fatalf("IndexBufferEncode() error = %v", err)
+	}
+	if n != len(out) {
+		t.Errorf("IndexBufferEncode() = %d, want %d", n, len(out))
+	}
+
+	decoded, n, err := IndexBufferDecode(out, math.MaxUint64)
+	if err != nil {
+		t.Fatalf("IndexBufferDecode() error = %v", err)
+	}
+	defer decoded.Close()
+	if n != len(out) {
+		t.Errorf("IndexBufferDecode() read %d bytes, want %d", n, len(out))
+	}
+	if decoded.BlockCount() != 2 || decoded.UncompressedSize() != 13 || decoded.FileSize() != index.FileSize() {
+		t.Errorf(
+			"IndexBufferDecode() = %d blocks of %d bytes in %d, want 2 of 13 in %d",
+			decoded.BlockCount(), decoded.UncompressedSize(), decoded.FileSize(), index.FileSize(),
+		)
+	}
+	if _, _, err := IndexBufferDecode(out[:n-1], math.MaxUint64); err == nil {
+		t.Error("IndexBufferDecode() expected error for truncated input")
+	}
+}
+
+func TestIndex_AppendBlock_overflow(t *testing.T) {
+	index, err := NewIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	// The uncompressed sizes recorded by bad-3-index-uncomp-overflow.xz.
+	const maxVLI = math.MaxInt64
+	if err := index.AppendBlock(26, maxVLI); err != nil {
+		t.Fatalf("AppendBlock() error = %v", err)
+	}
+	if err := index.AppendBlock(26, maxVLI); err == nil {
+		t.Error("AppendBlock() expected error for overflowing uncompressed size")
+	}
+	if index.BlockCount() != 1 {
+		t.Errorf("BlockCount() = %d, want 1", index.BlockCount())
+	}
+}
+
+func TestIndexCat(t *testing.T) {
+	newIndex := func(unpaddedSize, uncompressedSize uint64) *Index {
+		index, err := NewIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := index.AppendBlock(unpaddedSize, uncompressedSize); err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+	dst, src := newIndex(26, 6), newIndex(27, 7)
+	defer dst.Close()
+	defer src.Close()
+	wantFileSize := dst.FileSize() + src.FileSize() + 4
+
+	dup := IndexDup(dst)
+	if dup == nil {
+		t.Fatal("IndexDup() = nil")
+	}
+	defer dup.Close()
+
+	if err := IndexCat(dst, src, 4); err != nil {
+		t.Fatalf("IndexCat() error = %v", err)
+	}
+	if dst.StreamCount() != 2 || dst.BlockCount() != 2 {
+		t.Errorf("IndexCat() = %d streams of %d blocks, want 2 of 2", dst.StreamCount(), dst.BlockCount())
+	}
+	if dst.UncompressedSize() != 13 || dst.FileSize() != wantFileSize {
+		t.Errorf(
+			"IndexCat() = %d bytes in %d, want 13 in %d",
+			dst.UncompressedSize(), dst.FileSize(), wantFileSize,
+		)
+	}
+	// The copy is unaffected by changes to the original.
+	if dup.StreamCount() != 1 || dup.BlockCount() != 1 || dup.UncompressedSize() != 6 {
+		t.Errorf(
+			"IndexDup() = %d streams of %d blocks of %d bytes, want 1 of 1 of 6",
+			dup.StreamCount(), dup.BlockCount(), dup.UncompressedSize(),
+		)
+	}
+	other := newIndex(26, 6)
+	defer other.Close()
+	if err := IndexCat(dup, other, 3); err == nil {
+		t.Error("IndexCat() expected error for padding not a multiple of four")
+	}
+}
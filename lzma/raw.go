@@ -0,0 +1,199 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <stdlib.h>
+#include <lzma.h>
+
+lzma_stream stream_init();
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// LZMA1PropsSize is the size of the properties of a LZMA1 filter.
+const LZMA1PropsSize = 5
+
+// NewLZMA1RawDecoder initializes a Stream that decodes bare LZMA1 data, as
+// embedded in formats that store the filter properties and sizes outside the
+// compressed data. props are the LZMA1PropsSize bytes of encoded properties.
+// If dictSize is nonzero it overrides the dictionary size decoded from props.
+// The data must end with an end of payload marker, which is when the Stream
+// returns StreamEnd.
+func NewLZMA1RawDecoder(props []byte, dictSize uint32) (*Stream, error) {
+	filters := [2]C.lzma_filter{
+		{id: C.LZMA_FILTER_LZMA1},
+		{id: C.LZMA_VLI_UNKNOWN},
+	}
+	ret := Return(
+		C.lzma_properties_decode(
+			&filters[0],
+			nil,
+			(*C.uint8_t)(unsafe.SliceData(props)),
+			C.size_t(len(props)),
+		),
+	)
+	if ret != Ok {
+		return nil, fmt.Errorf("error decode lzma1 properties code=%v", ret)
+	}
+	// The decoder copies the options it needs when initialized.
+	defer C.free(filters[0].options)
+	if dictSize != 0 {
+		(*C.lzma_options_lzma)(filters[0].options).dict_size = C.uint32_t(dictSize)
+	}
+
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	ret = Return(C.lzma_raw_decoder((*C.lzma_stream)(&stream.internal), &filters[0]))
+	if ret != Ok {
+		return nil, fmt.Errorf("error init lzma1 raw decoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// LZMA2State holds the dictionary and state of a LZMA2 decoder between the
+// chunks decoded by DecodeLZMA2Chunk, for protocols that frame messages as
+// raw LZMA2 chunks.
+type LZMA2State struct {
+	stream *Stream
+	// ended is set once the end marker chunk has been decoded.
+	ended bool
+}
+
+// NewLZMA2RawDecoder initializes a Stream that decodes bare LZMA2 data with a
+// dictionary of dictSize bytes, which must be at least the dictionary size the
+// data was encoded with. The first chunk must reset the dictionary. The Stream
+// returns StreamEnd at the end marker chunk.
+func NewLZMA2RawDecoder(dictSize uint32) (*Stream, error) {
+	stream := Stream{
+		internal: C.stream_init(),
+		rawLZMA2: true,
+		dictSize: dictSize,
+	}
+	if ret := stream.initLZMA2Raw(); ret != Ok {
+		return nil, fmt.Errorf("error init lzma2 raw decoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// initLZMA2Raw initializes the Stream as a raw LZMA2 decoder, reusing the
+// memory of a previous raw LZMA2 decoder of the same dictionary size.
+func (stream *Stream) initLZMA2Raw() Return {
+	options := (*C.lzma_options_lzma)(C.calloc(1, C.sizeof_lzma_options_lzma))
+	// The decoder copies the options it needs when initialized.
+	defer C.free(unsafe.Pointer(options))
+	options.dict_size = C.uint32_t(stream.dictSize)
+	filters := [2]C.lzma_filter{
+		{id: C.LZMA_FILTER_LZMA2, options: unsafe.Pointer(options)},
+		{id: C.LZMA_VLI_UNKNOWN},
+	}
+	return Return(C.lzma_raw_decoder((*C.lzma_stream)(&stream.internal), &filters[0]))
+}
+
+// ResetDictionary discards the dictionary and state of a Stream created by
+// NewLZMA2RawDecoder, also once it has returned StreamEnd, so that the data
+// that follows decodes in isolation from the data before it. As at the start
+// of LZMA2 data, the next chunk must then reset the dictionary; one that
+// refers to the discarded dictionary fails with DataError. liblzma has no
+// reset of its own so the decoder is reinitialized, keeping the next input and
+// output, and TotalIn and TotalOut restart from zero. Other Streams return
+// ProgError.
+func (stream *Stream) ResetDictionary() Return {
+	if !stream.rawLZMA2 || stream.closed {
+		return ProgError
+	}
+	stream.finished, stream.finishing = false, false
+	return stream.initLZMA2Raw()
+}
+
+// NewLZMA2State initializes a LZMA2State with a dictionary of dictSize bytes,
+// which must be at least the dictionary size the chunks were encoded with.
+// The first chunk decoded must reset the dictionary.
+func NewLZMA2State(dictSize uint32) (*LZMA2State, error) {
+	stream, err := NewLZMA2RawDecoder(dictSize)
+	if err != nil {
+		return nil, err
+	}
+	return &LZMA2State{stream: stream}, nil
+}
+
+// Close frees memory allocated for the LZMA2State.
+func (state *LZMA2State) Close() error {
+	return state.stream.Close()
+}
+
+// DecodeLZMA2Chunk decodes chunk, which must hold exactly one LZMA2 chunk, and
+// returns its output. The control byte of the chunk determines whether the
+// dictionary, state and properties are reset or continue from the previous
+// chunk decoded with state. The end marker chunk returns no output, after which
+// no more chunks can be decoded with state.
+func DecodeLZMA2Chunk(state *LZMA2State, chunk []byte) ([]byte, error) {
+	size, uncompressedSize, err := lzma2ChunkSize(chunk)
+	if err != nil {
+		return nil, err
+	}
+	if size != len(chunk) {
+		return nil, fmt.Errorf("error lzma2 chunk of %d bytes, header gives %d", len(chunk), size)
+	}
+	if state.ended {
+		return nil, fmt.Errorf("error lzma2 chunk after end marker code=%v", ProgError)
+	}
+	out := make([]byte, uncompressedSize)
+	state.stream.SetNextIn(chunk)
+	state.stream.SetNextOut(out)
+	for {
+		ret := state.stream.Code(Run)
+		switch ret {
+		case Ok:
+			if state.stream.AvailableIn() == 0 && state.stream.AvailableOut() == 0 {
+				return out, nil
+			}
+		case StreamEnd:
+			state.ended = true
+			return out, nil
+		default:
+			return nil, fmt.Errorf("error decode lzma2 chunk code=%v", ret)
+		}
+	}
+}
+
+// lzma2ChunkSize decodes the header at the start of a LZMA2 chunk, returning
+// the size of the whole chunk and of its uncompressed data.
+func lzma2ChunkSize(chunk []byte) (size, uncompressedSize int, err error) {
+	if len(chunk) == 0 {
+		return 0, 0, fmt.Errorf("error empty lzma2 chunk")
+	}
+	control := chunk[0]
+	switch {
+	case control == 0x00:
+		// End marker.
+		return 1, 0, nil
+	case control == 0x01 || control == 0x02:
+		// Uncompressed chunk, with or without a dictionary reset.
+		if len(chunk) < 3 {
+			return 0, 0, fmt.Errorf("error truncated lzma2 chunk header")
+		}
+		uncompressedSize = (int(chunk[1])<<8 | int(chunk[2])) + 1
+		return 3 + uncompressedSize, uncompressedSize, nil
+	case control >= 0x80:
+		// LZMA chunk, with properties if the state reset is of at least
+		// new properties.
+		headerSize := 5
+		if control >= 0xc0 {
+			headerSize = 6
+		}
+		if len(chunk) < headerSize {
+			return 0, 0, fmt.Errorf("error truncated lzma2 chunk header")
+		}
+		uncompressedSize = (int(control&0x1f)<<16 | int(chunk[1])<<8 | int(chunk[2])) + 1
+		compressedSize := (int(chunk[3])<<8 | int(chunk[4])) + 1
+		return headerSize + compressedSize, uncompressedSize, nil
+	default:
+		return 0, 0, fmt.Errorf("error invalid lzma2 control byte %#x code=%v", control, DataError)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"strings"
+	"testing"
+)
+
+// loremText is the decoded data of the good-1-lzma2-*.xz test files.
+const loremText = "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n"
+
+func TestNewLZMA1RawDecoder(t *testing.T) {
+	// "Hello\nWorld!\n" compressed with xz --format=lzma. The .lzma header is
+	// the properties followed by the uncompressed size, unknown in this case.
+	in, err := base64.StdEncoding.DecodeString("XQAAgAD//////////wAkGUmYbwUVJycNdnjQKmgXFf//dfgAAA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, payload := in[:LZMA1PropsSize], in[LZMA1PropsSize+8:]
+	for _, dictSize := range []uint32{0, 1 << 16} {
+		stream, err := NewLZMA1RawDecoder(props, dictSize)
+		if err != nil {
+			t.Fatalf("NewLZMA1RawDecoder() error = %v", err)
+		}
+		if got := codeAll(t, stream, payload); string(got) != "Hello\nWorld!\n" {
+			t.Errorf("Code() got = '%v', want %v", string(got), "Hello\nWorld!\n")
+		}
+	}
+	if _, err := NewLZMA1RawDecoder(props[:4], 0); err == nil {
+		t.Error("NewLZMA1RawDecoder() expected error for truncated properties")
+	}
+}
+
+func TestDecodeLZMA2Chunk(t *testing.T) {
+	// The block data of good-1-lzma2-4.xz, which starts after the stream
+	// header and a block header of 12 bytes each. Its first chunk is LZMA with
+	// a dictionary reset, the second uncompressed with a dictionary reset and
+	// the third LZMA with new properties continuing the dictionary.
+	in, err := base64.StdEncoding.DecodeString("/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKwADlAL1dADGbyhnFVOy2VOexfcRXnmyJrUptFtg8BZQQFpk4IaO5xYD//O7U1T/djNc9j3bsiKoyq2XUOO/3+Yq/9/ilVtdt1z+FC54/4kdoIggFNbhBcvnbvreOhr9DS44NQy9Bad9hDMToNwhK3sJ2FrhITp65U1AfM4PoKaBnyGY6fyISYvtH5Lz0UQ8ViEnYygsli17o2v04wM5Mcxv/0JvoTLcT+DeZ4tqcL7XquKWN6leCmyXK+/aICpvfQQNuAAAAsgdE6RczS4QAAasDyQMAAPVQLf6xxGf7AgAAAAAEWVo=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks [][]byte
+	for data := in[24:]; ; {
+		size, _, err := lzma2ChunkSize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, data[:size])
+		if data[0] == 0x00 {
+			break
+		}
+		data = data[size:]
+	}
+	if len(chunks) != 4 {
+		t.Fatalf("split %d chunks, want 3 and the end marker", len(chunks))
+	}
+
+	state, err := NewLZMA2State(1 << 20)
+	if err != nil {
+		t.Fatalf("NewLZMA2State() error = %v", err)
+	}
+	defer state.Close()
+	var got []byte
+	for i, chunk := range chunks {
+		out, err := DecodeLZMA2Chunk(state, chunk)
+		if err != nil {
+			t.Fatalf("DecodeLZMA2Chunk() chunk %d error = %v", i, err)
+		}
+		got = append(got, out...)
+	}
+	if string(got) != loremText {
+		t.Errorf("DecodeLZMA2Chunk() got = '%v', want %v", string(got), loremText)
+	}
+	if _, err := DecodeLZMA2Chunk(state, chunks[1]); err == nil {
+		t.Error("DecodeLZMA2Chunk() expected error after the end marker")
+	}
+
+	tests := []struct {
+		name    string
+		chunk   []byte
+		wantErr bool
+	}{
+		// The second chunk resets the dictionary so can be decoded first.
+		{name: "reset", chunk: chunks[1]},
+		// The third continues the dictionary of the chunks before it.
+		{name: "continue", chunk: chunks[2], wantErr: true},
+		{name: "two chunks", chunk: append(append([]byte{}, chunks[1]...), 0x00), wantErr: true},
+		{name: "truncated", chunk: chunks[1][:len(chunks[1])-1], wantErr: true},
+		{name: "invalid control", chunk: []byte{0x03, 0x00, 0x00, 0x00}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				state, err := NewLZMA2State(1 << 20)
+				if err != nil {
+					t.Fatalf("NewLZMA2State() error = %v", err)
+				}
+				defer state.Close()
+				out, err := DecodeLZMA2Chunk(state, tt.chunk)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("DecodeLZMA2Chunk() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && !strings.Contains(loremText, string(out)) {
+					t.Errorf("DecodeLZMA2Chunk() got = '%v', want part of %v", string(out), loremText)
+				}
+			},
+		)
+	}
+}
+
+func TestStream_ResetDictionary(t *testing.T) {
+	// The chunks of good-1-lzma2-4.xz as in TestDecodeLZMA2Chunk.
+	in, err := base64.StdEncoding.DecodeString("/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKwADlAL1dADGbyhnFVOy2VOexfcRXnmyJrUptFtg8BZQQFpk4IaO5xYD//O7U1T/djNc9j3bsiKoyq2XUOO/3+Yq/9/ilVtdt1z+FC54/4kdoIggFNbhBcvnbvreOhr9DS44NQy9Bad9hDMToNwhK3sJ2FrhITp65U1AfM4PoKaBnyGY6fyISYvtH5Lz0UQ8ViEnYygsli17o2v04wM5Mcxv/0JvoTLcT+DeZ4tqcL7XquKWN6leCmyXK+/aICpvfQQNuAAAAsgdE6RczS4QAAasDyQMAAPVQLf6xxGf7AgAAAAAEWVo=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks [][]byte
+	for data := in[24:]; data[0] != 0x00; {
+		size, _, err := lzma2ChunkSize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	message := func(chunks ...[]byte) []byte {
+		return append(bytes.Join(chunks, nil), 0x00)
+	}
+	decode := func(stream *Stream, in []byte) ([]byte, Return) {
+		out := make([]byte, 1024)
+		stream.SetNextIn(in)
+		stream.SetNextOut(out)
+		ret := stream.Code(Finish)
+		return out[:len(out)-stream.AvailableOut()], ret
+	}
+
+	stream, err := NewLZMA2RawDecoder(1 << 20)
+	if err != nil {
+		t.Fatalf("NewLZMA2RawDecoder() error = %v", err)
+	}
+	defer stream.Close()
+	first, ret := decode(stream, message(chunks[0]))
+	if ret != StreamEnd {
+		t.Fatalf("Code() first message = %v, want %v", ret, StreamEnd)
+	}
+	if ret := stream.ResetDictionary(); ret != Ok {
+		t.Fatalf("ResetDictionary() = %v", ret)
+	}
+	// The second message starts with a dictionary reset of its own.
+	second, ret := decode(stream, message(chunks[1], chunks[2]))
+	if ret != StreamEnd {
+		t.Fatalf("Code() second message = %v, want %v", ret, StreamEnd)
+	}
+	if got := string(first) + string(second); got != loremText {
+		t.Errorf("Code() got = '%v', want %v", got, loremText)
+	}
+	if stream.TotalOut() != uint64(len(second)) {
+		t.Errorf("TotalOut() = %d, want %d since the reset", stream.TotalOut(), len(second))
+	}
+
+	// The third chunk continues the dictionary of the chunks before it, which
+	// is no longer available.
+	if ret := stream.ResetDictionary(); ret != Ok {
+		t.Fatalf("ResetDictionary() = %v", ret)
+	}
+	if out, ret := decode(stream, message(chunks[2])); ret != DataError || len(out) != 0 {
+		t.Errorf("Code() continuing message = %d bytes, %v, want 0 bytes, %v", len(out), ret, DataError)
+	}
+
+	decoder, err := NewStreamDecoder(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	if ret := decoder.ResetDictionary(); ret != ProgError {
+		t.Errorf("ResetDictionary() of a stream decoder = %v, want %v", ret, ProgError)
+	}
+}
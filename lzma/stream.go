@@ -2,10 +2,18 @@
 // SPDX-License-Identifier: MIT
 
 // Package lzma decompresses data with C-lzma library.
+//
+// Buffers are passed to liblzma with their lengths as size_t, which is 32 bits
+// wide on 32-bit platforms. A Stream gives liblzma at most 4 GiB - 1 of its
+// input and output in one call on every platform, coding the rest of a larger
+// buffer over later calls, and the one-shot encoders fail rather than size
+// their output to a bound that overflowed. Memory limits are passed as
+// uint64_t and are not truncated.
 package lzma
 
 /*
 #cgo !nopkgconfig pkg-config: liblzma
+#cgo nothreads CFLAGS: -DXZ_NO_THREADS
 
 #include <stdlib.h>
 #include <lzma.h>
@@ -15,32 +23,75 @@ lzma_stream stream_init() {
 	return (lzma_stream) LZMA_STREAM_INIT;
 }
 
-lzma_ret safe_lzma_code(lzma_stream *stream, lzma_action action) {
+// safe_lzma_code runs lzma_code over the input and output buffers, which are
+// only held by the stream during the call so that it never keeps Go pointers.
+lzma_ret safe_lzma_code(lzma_stream *stream, const uint8_t *in, uint8_t *out, lzma_action action) {
+	stream->next_in = in;
+	stream->next_out = out;
 	lzma_ret ret = lzma_code(stream, action);
-
-	// lzma_code advances the pointers which is not safe in go if it exceeds the
-	// original slice bounds. Therefore, if we reach the end of stream->avail_*
-	// assume we have gone off the end of the slice and therefore must null the
-	// now invalid reference out.
-	if (stream->avail_out == 0) {
-		stream->next_out = NULL;
-	}
-	if (stream->avail_in == 0) {
-		stream->next_in = NULL;
-	}
+	stream->next_in = NULL;
+	stream->next_out = NULL;
 	return ret;
 }
+
+// The multithreaded decoder was added in liblzma 5.4.0, along with the
+// memlimit_threading and memlimit_stop fields of lzma_mt. Older versions, and
+// builds with the nothreads tag, report it as unsupported.
+#if LZMA_VERSION >= 50040002 && !defined(XZ_NO_THREADS)
+#define HAS_DECODER_MT 1
+static lzma_ret stream_decoder_mt(lzma_stream *strm, uint32_t flags, uint32_t threads, uint32_t timeout,
+		uint64_t memlimit_threading, uint64_t memlimit_stop) {
+	lzma_mt mt = {
+		.flags = flags,
+		.threads = threads,
+		.timeout = timeout,
+		.memlimit_threading = memlimit_threading,
+		.memlimit_stop = memlimit_stop,
+	};
+	return lzma_stream_decoder_mt(strm, &mt);
+}
+#else
+#define HAS_DECODER_MT 0
+static lzma_ret stream_decoder_mt(lzma_stream *strm, uint32_t flags, uint32_t threads, uint32_t timeout,
+		uint64_t memlimit_threading, uint64_t memlimit_stop) {
+	return LZMA_OPTIONS_ERROR;
+}
+#endif
 */
 import "C"
 import (
 	"fmt"
+	"math"
 	"runtime"
+	"runtime/cgo"
 	"unsafe"
 )
 
 type Stream struct {
 	internal C.lzma_stream
-	pinner   runtime.Pinner
+	// in and out are the next input and output. They are only set in internal
+	// during Code, which gives liblzma at most maxCodeLen bytes of each.
+	in, out []byte
+	pinner  runtime.Pinner
+	index   **C.lzma_index
+	// handle refers to the Allocator of the stream, if any.
+	handle cgo.Handle
+	// finished is set once Code has returned StreamEnd.
+	finished bool
+	// finishing is set by SignalEnd.
+	finishing bool
+	// session is set between BeginSession and EndSession, while the buffers
+	// in pinned stay pinned.
+	session bool
+	pinned  [][]byte
+	// closed is set by Close, keeping the integrity check of the stream in
+	// check.
+	closed bool
+	check  Check
+	// rawLZMA2 is set for a decoder created by NewLZMA2RawDecoder, which
+	// ResetDictionary reinitializes with a dictionary of dictSize bytes.
+	rawLZMA2 bool
+	dictSize uint32
 }
 
 // Return values used by several functions in liblzma.
@@ -62,6 +113,40 @@ const (
 	SeekNeeded                     // request to change the input file position
 )
 
+var returnNames = [...]string{
+	Ok:               "ok",
+	StreamEnd:        "stream end",
+	NoCheck:          "no check",
+	UnsupportedCheck: "unsupported check",
+	GetCheck:         "get check",
+	MemError:         "mem error",
+	MemLimitError:    "mem limit error",
+	FormatError:      "format error",
+	OptionsError:     "options error",
+	DataError:        "data error",
+	BufError:         "buf error",
+	ProgError:        "prog error",
+	SeekNeeded:       "seek needed",
+}
+
+// String returns the name of the return value, such as "data error".
+func (r Return) String() string {
+	if r >= 0 && int(r) < len(returnNames) {
+		return returnNames[r]
+	}
+	return fmt.Sprintf("Return(%d)", int(r))
+}
+
+// IsError reports whether the return value is an error. Ok, StreamEnd,
+// SeekNeeded and the informational returns of the Tell decoder flags are not.
+func (r Return) IsError() bool {
+	switch r {
+	case Ok, StreamEnd, NoCheck, UnsupportedCheck, GetCheck, SeekNeeded:
+		return false
+	}
+	return true
+}
+
 // Action used by Stream.Code.
 type Action int
 
@@ -102,52 +187,279 @@ func NewStreamDecoder(memlimit uint64, flags ...DecoderOpt) (*Stream, error) {
 		),
 	)
 	if ret != Ok {
-		return nil, fmt.Errorf("error init stream decoder code=%d", ret)
+		return nil, fmt.Errorf("error init stream decoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// MTDecoderOptions configures the multithreaded stream decoder.
+type MTDecoderOptions struct {
+	Threads uint32 // maximum number of worker threads
+	Timeout uint32 // milliseconds Code may block for, zero to disable
+	// MemlimitThreading is the memory usage above which fewer threads are
+	// used, down to single-threaded decoding. PhysMem()/4 is a reasonable
+	// starting point.
+	MemlimitThreading uint64
+	// MemlimitStop is the memory usage above which decoding fails with
+	// MemLimitError.
+	MemlimitStop uint64
+}
+
+// NewStreamDecoderMT initializes an .xz Stream configured as a decoder that
+// decodes blocks in parallel. Only blocks whose headers store their sizes, as
+// written by multithreaded encoders, are decoded in parallel; others are
+// decoded in a single thread. It requires liblzma 5.4.0 or later, as reported
+// by HasDecoderThreadsSupport, and fails with OptionsError otherwise.
+func NewStreamDecoderMT(options MTDecoderOptions, flags ...DecoderOpt) (*Stream, error) {
+	var decoderFlag int32
+	for _, flag := range flags {
+		decoderFlag |= int32(flag)
+	}
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	ret := Return(
+		C.stream_decoder_mt(
+			(*C.lzma_stream)(&stream.internal),
+			C.uint32_t(decoderFlag),
+			C.uint32_t(options.Threads),
+			C.uint32_t(options.Timeout),
+			C.uint64_t(options.MemlimitThreading),
+			C.uint64_t(options.MemlimitStop),
+		),
+	)
+	if ret != Ok {
+		return nil, fmt.Errorf("error init multithreaded stream decoder code=%v", ret)
+	}
+	return &stream, nil
+}
+
+// HasDecoderThreadsSupport reports whether the linked liblzma provides the
+// multithreaded decoder of NewStreamDecoderMT, which was added in liblzma
+// 5.4.0. It is false when built with the nothreads tag, as HasThreadsSupport.
+func HasDecoderThreadsSupport() bool {
+	return C.HAS_DECODER_MT != 0
+}
+
+// PhysMem is the amount of physical memory in bytes, or zero if it cannot be
+// determined.
+func PhysMem() uint64 {
+	return uint64(C.lzma_physmem())
+}
+
+// NewAloneDecoder initializes a Stream configured as a decoder of the legacy
+// .lzma format.
+func NewAloneDecoder(memlimit uint64) (*Stream, error) {
+	stream := Stream{
+		internal: C.stream_init(),
+	}
+	ret := Return(C.lzma_alone_decoder((*C.lzma_stream)(&stream.internal), C.uint64_t(memlimit)))
+	if ret != Ok {
+		return nil, fmt.Errorf("error init alone decoder code=%v", ret)
 	}
 	return &stream, nil
 }
 
+// maxCodeLen is the most input or output given to liblzma in one call of
+// Code, the largest size_t of 32-bit platforms.
+var maxCodeLen uint64 = math.MaxUint32
+
+// codeLen is the length of a buffer given to liblzma by Code, capped at
+// maxCodeLen.
+func codeLen(b []byte) C.size_t {
+	return C.size_t(min(uint64(len(b)), maxCodeLen))
+}
+
 func (stream *Stream) SetNextIn(in []byte) {
-	stream.internal.next_in = (*C.uint8_t)(unsafe.SliceData(in))
-	stream.internal.avail_in = C.size_t(len(in))
+	if stream.session {
+		stream.sessionPin(in)
+	}
+	stream.in = in
 }
 
 func (stream *Stream) AvailableIn() int {
-	return int(stream.internal.avail_in)
+	return len(stream.in)
 }
 
 func (stream *Stream) SetNextOut(out []byte) {
-	stream.internal.next_out = (*C.uint8_t)(unsafe.SliceData(out))
-	stream.internal.avail_out = C.size_t(len(out))
+	if stream.session {
+		stream.sessionPin(out)
+	}
+	stream.out = out
 }
 
 func (stream *Stream) AvailableOut() int {
-	return int(stream.internal.avail_out)
+	return len(stream.out)
+}
+
+// NeedsInput reports whether Code cannot continue until more input is set by
+// SetNextIn, which is when the next input is empty and the coding has not
+// finished with StreamEnd.
+func (stream *Stream) NeedsInput() bool {
+	return len(stream.in) == 0 && !stream.finished
+}
+
+// HasOutputSpace reports whether the next output set by SetNextOut has space
+// left for Code to write to.
+func (stream *Stream) HasOutputSpace() bool {
+	return len(stream.out) > 0
+}
+
+// SignalEnd declares that the input set by the last call to SetNextIn, and any
+// not yet consumed, is the end of the input. Every following call to Code with
+// Run is then made with Finish, so a drive loop can call Code(Run) throughout
+// and signal the end of the input once it is known, such as when its source
+// returns io.EOF.
+func (stream *Stream) SignalEnd() {
+	stream.finishing = true
+}
+
+// Finishing reports whether SignalEnd has been called.
+func (stream *Stream) Finishing() bool {
+	return stream.finishing
+}
+
+// TotalIn is the total input consumed by Stream.Code since the Stream was
+// initialized, as counted by liblzma.
+func (stream *Stream) TotalIn() uint64 {
+	return uint64(stream.internal.total_in)
+}
+
+// TotalOut is the total output produced by Stream.Code since the Stream was
+// initialized, as counted by liblzma.
+func (stream *Stream) TotalOut() uint64 {
+	return uint64(stream.internal.total_out)
 }
 
 // Code encodes or decodes data based on how the Stream has been initialized,
 // and it's current state as set by Stream.SetNextIn and Stream.SetNextOut.
 func (stream *Stream) Code(action Action) Return {
-	stream.pin()
-	defer stream.pinner.Unpin()
+	if !stream.session {
+		stream.pin()
+		defer stream.pinner.Unpin()
+	}
 
-	return Return(C.safe_lzma_code((*C.lzma_stream)(&stream.internal), C.lzma_action(action)))
+	if stream.finishing && action == Run {
+		action = Finish
+	}
+	// liblzma rejects more input following a flush or Finish, so they are
+	// only passed on once the rest of the input fits in one call.
+	if uint64(len(stream.in)) > maxCodeLen {
+		action = Run
+	}
+	availIn, availOut := codeLen(stream.in), codeLen(stream.out)
+	stream.internal.avail_in, stream.internal.avail_out = availIn, availOut
+	// The buffers are passed as byte pointers rather than unsafe.Pointer, as
+	// cgo then knows they hold no Go pointers and does not check the rest of
+	// the objects holding them.
+	ret := Return(
+		C.safe_lzma_code(
+			(*C.lzma_stream)(&stream.internal),
+			(*C.uint8_t)(unsafe.SliceData(stream.in)),
+			(*C.uint8_t)(unsafe.SliceData(stream.out)),
+			C.lzma_action(action),
+		),
+	)
+	stream.in = stream.in[availIn-stream.internal.avail_in:]
+	stream.out = stream.out[availOut-stream.internal.avail_out:]
+	if ret == StreamEnd {
+		stream.finished = true
+	}
+	return ret
 }
 
-// Close frees memory allocated for the coder data structures used internally.
-func (stream *Stream) Close() error {
-	stream.pin()
-	defer stream.pinner.Unpin()
+// Drain finishes the coding once the last of the input has been set by
+// SetNextIn, running Code with Finish to write the remaining output to out. It
+// returns the number of bytes written and done once StreamEnd is reached, or
+// with out full, in which case Drain must be called again with more space. The
+// informational returns of the Tell decoder flags are passed over, any other
+// return is given as err with Ok otherwise.
+func (stream *Stream) Drain(out []byte) (n int, done bool, err Return) {
+	stream.SetNextOut(out)
+	for {
+		ret := stream.Code(Finish)
+		n = len(out) - stream.AvailableOut()
+		switch ret {
+		case StreamEnd:
+			return n, true, Ok
+		case Ok, NoCheck, UnsupportedCheck, GetCheck:
+			if stream.AvailableOut() == 0 {
+				return n, false, Ok
+			}
+		default:
+			return n, false, ret
+		}
+	}
+}
+
+// BeginSession pins the buffers set by SetNextIn and SetNextOut, and any set
+// later, until EndSession so that Code does not pin and unpin them on every
+// call. A loop that reuses the same input and output buffers, or slices of
+// them, then calls Code without allocating. The buffers of a session must not
+// be reslices beyond their capacity and must not be freed before EndSession.
+func (stream *Stream) BeginSession() {
+	if stream.session {
+		return
+	}
+	stream.session = true
+	stream.sessionPin(stream.in)
+	stream.sessionPin(stream.out)
+}
 
+// EndSession unpins the buffers pinned since BeginSession. Code pins the
+// buffers on every call again afterward.
+func (stream *Stream) EndSession() {
+	if !stream.session {
+		return
+	}
+	stream.session = false
+	stream.pinner.Unpin()
+	clear(stream.pinned)
+	stream.pinned = stream.pinned[:0]
+}
+
+// sessionPin pins b unless it lies within a buffer already pinned in the
+// session.
+func (stream *Stream) sessionPin(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	p := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	for _, q := range stream.pinned {
+		start := uintptr(unsafe.Pointer(unsafe.SliceData(q)))
+		if p >= start && p < start+uintptr(cap(q)) {
+			return
+		}
+	}
+	stream.pinner.Pin(unsafe.SliceData(b))
+	stream.pinned = append(stream.pinned, b)
+}
+
+// Close frees memory allocated for the coder data structures used internally,
+// ending any session.
+func (stream *Stream) Close() error {
+	stream.EndSession()
+	if !stream.closed {
+		stream.check = stream.Check()
+		stream.closed = true
+	}
 	C.lzma_end((*C.lzma_stream)(&stream.internal))
+	stream.freeAllocator()
+	if stream.index != nil {
+		if *stream.index != nil {
+			C.lzma_index_end(*stream.index, nil)
+		}
+		C.free(unsafe.Pointer(stream.index))
+		stream.index = nil
+	}
 	return nil
 }
 
 func (stream *Stream) pin() {
-	if stream.internal.next_in != nil {
-		stream.pinner.Pin(stream.internal.next_in)
+	if len(stream.in) > 0 {
+		stream.pinner.Pin(unsafe.SliceData(stream.in))
 	}
-	if stream.internal.next_out != nil {
-		stream.pinner.Pin(stream.internal.next_out)
+	if len(stream.out) > 0 {
+		stream.pinner.Pin(unsafe.SliceData(stream.out))
 	}
 }
@@ -0,0 +1,76 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <lzma.h>
+*/
+import "C"
+import (
+	"unsafe"
+)
+
+// StreamHeaderSize is the size of both the stream header and footer.
+const StreamHeaderSize = C.LZMA_STREAM_HEADER_SIZE
+
+// StreamFlags are the options stored in a stream header and footer.
+type StreamFlags struct {
+	Check        Check
+	BackwardSize uint64 // size of the Index field, VLIUnknown for headers
+}
+
+// DecodeStreamHeader decodes the StreamFlags from the StreamHeaderSize bytes
+// of a stream header.
+func DecodeStreamHeader(in []byte) (StreamFlags, Return) {
+	var flags C.lzma_stream_flags
+	ret := Return(C.lzma_stream_header_decode(&flags, (*C.uint8_t)(unsafe.SliceData(in))))
+	return newStreamFlags(flags), ret
+}
+
+// DecodeStreamFooter decodes the StreamFlags from the StreamHeaderSize bytes
+// of a stream footer.
+func DecodeStreamFooter(in []byte) (StreamFlags, Return) {
+	var flags C.lzma_stream_flags
+	ret := Return(C.lzma_stream_footer_decode(&flags, (*C.uint8_t)(unsafe.SliceData(in))))
+	return newStreamFlags(flags), ret
+}
+
+// EncodeStreamHeader encodes flags as a stream header, ignoring BackwardSize.
+func EncodeStreamHeader(flags StreamFlags) ([]byte, Return) {
+	c := flags.c()
+	out := make([]byte, StreamHeaderSize)
+	ret := Return(C.lzma_stream_header_encode(&c, (*C.uint8_t)(unsafe.SliceData(out))))
+	return out, ret
+}
+
+// EncodeStreamFooter encodes flags as a stream footer, whose BackwardSize must
+// be the size of the Index field.
+func EncodeStreamFooter(flags StreamFlags) ([]byte, Return) {
+	c := flags.c()
+	out := make([]byte, StreamHeaderSize)
+	ret := Return(C.lzma_stream_footer_encode(&c, (*C.uint8_t)(unsafe.SliceData(out))))
+	return out, ret
+}
+
+// Compare returns Ok if the flags are equal or DataError otherwise. The
+// BackwardSize is only compared if it is known in both.
+func (flags StreamFlags) Compare(other StreamFlags) Return {
+	a, b := flags.c(), other.c()
+	return Return(C.lzma_stream_flags_compare(&a, &b))
+}
+
+func newStreamFlags(flags C.lzma_stream_flags) StreamFlags {
+	return StreamFlags{
+		Check:        Check(flags.check),
+		BackwardSize: uint64(flags.backward_size),
+	}
+}
+
+func (flags StreamFlags) c() C.lzma_stream_flags {
+	return C.lzma_stream_flags{
+		version:       0,
+		check:         C.lzma_check(flags.Check),
+		backward_size: C.lzma_vli(flags.BackwardSize),
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// sessionDecoder decodes in to out with a Stream in a session, taking the
+// output in windows of 256 bytes.
+type sessionDecoder struct {
+	stream  *Stream
+	in, out []byte
+}
+
+func newSessionDecoder(tb testing.TB, in, out []byte) *sessionDecoder {
+	tb.Helper()
+	stream, err := NewStreamDecoder(math.MaxUint64)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stream.SetNextIn(in)
+	stream.SetNextOut(out)
+	stream.BeginSession()
+	return &sessionDecoder{stream: stream, in: in, out: out}
+}
+
+// step runs Code once over the next window of the output.
+func (d *sessionDecoder) step() Return {
+	n := int(d.stream.TotalOut())
+	d.stream.SetNextOut(d.out[n:min(n+256, len(d.out))])
+	return d.stream.Code(Finish)
+}
+
+func (d *sessionDecoder) close() {
+	d.stream.EndSession()
+	_ = d.stream.Close()
+}
+
+func TestStream_BeginSession(t *testing.T) {
+	want := bytes.Repeat([]byte("Hello\nWorld!\n"), 1<<12)
+	in, err := EasyBufferEncode(PresetDefault, CheckCRC64, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newSessionDecoder(t, in, make([]byte, len(want)))
+	defer d.close()
+
+	allocs := testing.AllocsPerRun(
+		100, func() {
+			if ret := d.step(); ret != Ok {
+				t.Fatalf("Code() = %d", ret)
+			}
+		},
+	)
+	if allocs != 0 {
+		t.Errorf("Code() allocated %v times per call in a session, want 0", allocs)
+	}
+	ret := Ok
+	for ret == Ok {
+		ret = d.step()
+	}
+	if ret != StreamEnd {
+		t.Fatalf("Code() = %d, want %d", ret, StreamEnd)
+	}
+	if !bytes.Equal(d.out, want) {
+		t.Errorf("Code() got output differing from the input")
+	}
+}
+
+func BenchmarkStream_Code_session(b *testing.B) {
+	want := bytes.Repeat([]byte("Hello\nWorld!\n"), 1<<12)
+	in, err := EasyBufferEncode(PresetDefault, CheckCRC64, want)
+	if err != nil {
+		b.Fatal(err)
+	}
+	out := make([]byte, len(want))
+	d := newSessionDecoder(b, in, out)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		switch ret := d.step(); ret {
+		case Ok:
+		case StreamEnd:
+			b.StopTimer()
+			d.close()
+			d = newSessionDecoder(b, in, out)
+			b.StartTimer()
+		default:
+			b.Fatalf("Code() = %d", ret)
+		}
+	}
+	b.StopTimer()
+	d.close()
+}
+
+func TestHasDecoderThreadsSupport(t *testing.T) {
+	stream, err := NewStreamDecoderMT(MTDecoderOptions{Threads: 2, MemlimitThreading: math.MaxUint64, MemlimitStop: math.MaxUint64})
+	if err == nil {
+		_ = stream.Close()
+	}
+	if got, want := HasDecoderThreadsSupport(), err == nil; got != want {
+		t.Errorf("HasDecoderThreadsSupport() = %v, want %v as NewStreamDecoderMT() error = %v", got, want, err)
+	}
+}
+
+func TestReturn_String(t *testing.T) {
+	tests := []struct {
+		ret     Return
+		want    string
+		wantErr bool
+	}{
+		{ret: Ok, want: "ok"},
+		{ret: StreamEnd, want: "stream end"},
+		{ret: NoCheck, want: "no check"},
+		{ret: UnsupportedCheck, want: "unsupported check"},
+		{ret: GetCheck, want: "get check"},
+		{ret: MemError, want: "mem error", wantErr: true},
+		{ret: MemLimitError, want: "mem limit error", wantErr: true},
+		{ret: FormatError, want: "format error", wantErr: true},
+		{ret: OptionsError, want: "options error", wantErr: true},
+		{ret: DataError, want: "data error", wantErr: true},
+		{ret: BufError, want: "buf error", wantErr: true},
+		{ret: ProgError, want: "prog error", wantErr: true},
+		{ret: SeekNeeded, want: "seek needed"},
+		{ret: 13, want: "Return(13)", wantErr: true},
+		{ret: -1, want: "Return(-1)", wantErr: true},
+	}
+	for _, tt := range tests {
+		if got := tt.ret.String(); got != tt.want {
+			t.Errorf("Return(%d).String() = %q, want %q", int(tt.ret), got, tt.want)
+		}
+		if got := tt.ret.IsError(); got != tt.wantErr {
+			t.Errorf("Return(%d).IsError() = %v, want %v", int(tt.ret), got, tt.wantErr)
+		}
+	}
+}
+
+func TestStream_Drain(t *testing.T) {
+	want := bytes.Repeat([]byte("Hello\nWorld!\n"), 100)
+	in, err := EasyBufferEncode(PresetDefault, CheckCRC64, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := NewStreamDecoder(math.MaxUint64, TellAnyCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	stream.SetNextIn(in)
+	var got []byte
+	out := make([]byte, 100)
+	for done := false; !done; {
+		var n int
+		var ret Return
+		n, done, ret = stream.Drain(out)
+		if ret != Ok {
+			t.Fatalf("Drain() err = %v", ret)
+		}
+		if !done && n != len(out) {
+			t.Fatalf("Drain() = %d, %v, want a full output before done", n, done)
+		}
+		got = append(got, out[:n]...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Drain() got %d bytes differing from %d bytes", len(got), len(want))
+	}
+
+	truncated, err := NewStreamDecoder(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer truncated.Close()
+	truncated.SetNextIn(in[:len(in)-1])
+	if _, done, ret := truncated.Drain(make([]byte, len(want)+1)); done || ret != BufError {
+		t.Errorf("Drain() truncated = %v, %v, want false, %v", done, ret, BufError)
+	}
+}
+
+func TestStream_Code_maxCodeLen(t *testing.T) {
+	// Buffers larger than the most given to liblzma in one call, as for
+	// buffers of 4 GiB or more, are coded over several calls of Code. Finish
+	// is held back until the rest of the input fits.
+	defer func(n uint64) { maxCodeLen = n }(maxCodeLen)
+	maxCodeLen = 7
+	want := bytes.Repeat([]byte("Hello\nWorld!\n"), 100)
+	code := func(stream *Stream, in []byte) []byte {
+		t.Helper()
+		defer stream.Close()
+		out := make([]byte, len(want)+1024)
+		stream.SetNextIn(in)
+		stream.SetNextOut(out)
+		for {
+			availIn, availOut := stream.AvailableIn(), stream.AvailableOut()
+			ret := stream.Code(Finish)
+			if availIn-stream.AvailableIn() > 7 || availOut-stream.AvailableOut() > 7 {
+				t.Fatalf(
+					"Code() consumed %d and produced %d bytes, want at most 7",
+					availIn-stream.AvailableIn(), availOut-stream.AvailableOut(),
+				)
+			}
+			if ret == StreamEnd {
+				return out[:len(out)-stream.AvailableOut()]
+			}
+			if ret != Ok {
+				t.Fatalf("Code() = %v", ret)
+			}
+		}
+	}
+	encoder, err := NewEasyEncoder(PresetDefault, CheckCRC64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := code(encoder, want)
+	decoder, err := NewStreamDecoder(math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := code(decoder, compressed); !bytes.Equal(got, want) {
+		t.Errorf("Code() got %d bytes differing from %d bytes", len(got), len(want))
+	}
+}
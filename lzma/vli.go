@@ -0,0 +1,30 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+/*
+#include <lzma.h>
+*/
+import "C"
+import (
+	"unsafe"
+)
+
+// VLIDecode decodes a variable-length integer from the start of in, returning
+// it and the number of bytes read. DataError is returned if the encoding is
+// longer than allowed, is not minimal or is cut short by the end of in.
+func VLIDecode(in []byte) (uint64, int, Return) {
+	var vli C.lzma_vli
+	var inPos C.size_t
+	ret := Return(
+		C.lzma_vli_decode(
+			&vli,
+			nil,
+			(*C.uint8_t)(unsafe.SliceData(in)),
+			&inPos,
+			C.size_t(len(in)),
+		),
+	)
+	return uint64(vli), int(inPos), ret
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package lzma
+
+import (
+	"testing"
+)
+
+func TestVLIDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []byte
+		want  uint64
+		wantN int
+		ret   Return
+	}{
+		{name: "one byte", in: []byte{0x7f, 0xff}, want: 0x7f, wantN: 1, ret: Ok},
+		{name: "two bytes", in: []byte{0x8d, 0x01}, want: 0x8d, wantN: 2, ret: Ok},
+		{name: "not minimal", in: []byte{0x8d, 0x00}, ret: DataError},
+		{name: "too long", in: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, ret: DataError},
+		{name: "truncated", in: []byte{0x80}, ret: DataError},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, n, ret := VLIDecode(tt.in)
+				if ret != tt.ret {
+					t.Fatalf("VLIDecode() ret = %d, want %d", ret, tt.ret)
+				}
+				if ret == Ok && (got != tt.want || n != tt.wantN) {
+					t.Errorf("VLIDecode() = %d, %d, want %d, %d", got, n, tt.want, tt.wantN)
+				}
+			},
+		)
+	}
+}
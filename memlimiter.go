@@ -0,0 +1,78 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"sync"
+)
+
+// MemLimiter bounds the memory used by the decoders of many readers together.
+// Each reader given the MemLimiter with WithMemLimiter acquires the memory of a
+// block decoder from it before the decoder is allocated, and releases it once
+// the reader is done or closed. A MemLimiter is safe for concurrent use.
+type MemLimiter struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	// budget is the total memory that may be acquired, used the memory held by
+	// readers.
+	budget uint64
+	used   uint64
+	wait   bool
+}
+
+// NewMemLimiter creates a MemLimiter of budget bytes. If wait is set a reader
+// that needs more memory than is available blocks in Read until other readers
+// release enough of it, otherwise Read fails with an error matching
+// ErrMemLimiterExhausted. A reader releases the memory it holds while it waits,
+// and one that needs more than the whole budget always fails.
+func NewMemLimiter(budget uint64, wait bool) *MemLimiter {
+	l := &MemLimiter{budget: budget, wait: wait}
+	l.freed = sync.NewCond(&l.mu)
+	return l
+}
+
+// Available returns the memory in bytes that is not held by any reader.
+func (l *MemLimiter) Available() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.budget - l.used
+}
+
+// acquire grows a reservation of held bytes to n bytes in total, waiting for
+// them if the limiter is configured to and wait is set. It reports whether n
+// bytes are reserved, leaving held reserved otherwise. A reader that waits
+// releases what it holds first, so that it never waits on memory held by
+// itself or by another waiting reader.
+func (l *MemLimiter) acquire(held, n uint64, wait bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n > l.budget {
+		return false
+	}
+	if l.budget-l.used >= n-held {
+		l.used += n - held
+		return true
+	}
+	if !wait || !l.wait {
+		return false
+	}
+	l.used -= held
+	l.freed.Broadcast()
+	for l.budget-l.used < n {
+		l.freed.Wait()
+	}
+	l.used += n
+	return true
+}
+
+// release returns n bytes reserved by acquire.
+func (l *MemLimiter) release(n uint64) {
+	if n == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.used -= n
+	l.mu.Unlock()
+	l.freed.Broadcast()
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestWithMemLimiter(t *testing.T) {
+	// PresetDefault needs about 9 MiB to decode, so only one reader fits.
+	input, err := lzma.EasyBufferEncode(lzma.PresetDefault, lzma.CheckCRC64, []byte(loremText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const budget = 16 << 20
+
+	t.Run(
+		"fail", func(t *testing.T) {
+			l := NewMemLimiter(budget, false)
+			first := NewReader(bytes.NewReader(input), WithMemLimiter(l))
+			if _, err := first.Read(make([]byte, 1)); err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if l.Available() == budget {
+				t.Errorf("Available() = %d, want less than %d", l.Available(), budget)
+			}
+
+			_, err := io.ReadAll(NewReader(bytes.NewReader(input), WithMemLimiter(l)))
+			var xzErr *Error
+			if !errors.Is(err, ErrMemLimiterExhausted) || !errors.As(err, &xzErr) || xzErr.Code != lzma.MemLimitError {
+				t.Errorf("Read() error = %v, want %v", err, ErrMemLimiterExhausted)
+			}
+
+			if _, err := io.ReadAll(first); err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if l.Available() != budget {
+				t.Errorf("Available() = %d, want %d", l.Available(), budget)
+			}
+			got, err := io.ReadAll(NewReader(bytes.NewReader(input), WithMemLimiter(l)))
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if string(got) != loremText {
+				t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+			}
+		},
+	)
+
+	t.Run(
+		"wait", func(t *testing.T) {
+			l := NewMemLimiter(budget, true)
+			first := NewReader(bytes.NewReader(input), WithMemLimiter(l))
+			if _, err := first.Read(make([]byte, 1)); err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+
+			done := make(chan error)
+			go func() {
+				_, err := io.ReadAll(NewReader(bytes.NewReader(input), WithMemLimiter(l)))
+				done <- err
+			}()
+			if err := first.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Errorf("Read() error = %v, want nil once the first reader is closed", err)
+			}
+			if l.Available() != budget {
+				t.Errorf("Available() = %d, want %d", l.Available(), budget)
+			}
+		},
+	)
+
+	t.Run(
+		"later block larger than budget", func(t *testing.T) {
+			// A stream that fits the budget followed by one that does not,
+			// which fails rather than waiting on the memory of the first.
+			small, err := lzma.EasyBufferEncode(0, lzma.CheckCRC64, []byte(loremText))
+			if err != nil {
+				t.Fatal(err)
+			}
+			large, err := lzma.EasyBufferEncode(1, lzma.CheckCRC64, []byte(loremText))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := NewMemLimiter(1<<20, true)
+			xr := NewReader(bytes.NewReader(append(small, large...)), WithMemLimiter(l))
+			done := make(chan error)
+			go func() {
+				_, err := io.Copy(io.Discard, xr)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrMemLimiterExhausted) {
+					t.Errorf("Read() error = %v, want %v", err, ErrMemLimiterExhausted)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Read() blocked on the memory it holds")
+			}
+			if l.Available() != 1<<20 {
+				t.Errorf("Available() = %d, want %d", l.Available(), 1<<20)
+			}
+		},
+	)
+
+	t.Run(
+		"grow while waiting", func(t *testing.T) {
+			// Two readers holding the first stream's memory that both need
+			// more for the second do not wait on each other.
+			small, err := lzma.EasyBufferEncode(0, lzma.CheckCRC64, []byte(loremText))
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := append(small, input...)
+			// Enough for one reader to decode the second stream, but not for
+			// both to hold the first stream's memory while one does.
+			const budget = 8<<20 + 256<<10
+			l := NewMemLimiter(budget, true)
+			readers := []*Reader{
+				NewReader(bytes.NewReader(input), WithMemLimiter(l)),
+				NewReader(bytes.NewReader(input), WithMemLimiter(l)),
+			}
+			for _, xr := range readers {
+				if _, err := xr.Read(make([]byte, 1)); err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+			}
+			done := make(chan error, len(readers))
+			for _, xr := range readers {
+				go func(xr *Reader) {
+					_, err := io.Copy(io.Discard, xr)
+					done <- err
+				}(xr)
+			}
+			for range readers {
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Errorf("Read() error = %v", err)
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatal("Read() blocked on the memory of another waiting reader")
+				}
+			}
+			if l.Available() != budget {
+				t.Errorf("Available() = %d, want %d", l.Available(), budget)
+			}
+		},
+	)
+
+	t.Run(
+		"larger than budget", func(t *testing.T) {
+			l := NewMemLimiter(1<<20, true)
+			_, err := io.ReadAll(NewReader(bytes.NewReader(input), WithMemLimiter(l)))
+			if !errors.Is(err, ErrMemLimiterExhausted) {
+				t.Errorf("Read() error = %v, want %v", err, ErrMemLimiterExhausted)
+			}
+		},
+	)
+}
@@ -1,84 +1,1166 @@
 // Copyright 2024 Dillon Giacoppo
 // SPDX-License-Identifier: MIT
 
-// Package xz decompresses data with C-lzma library.
+// Package xz decompresses data with C-lzma library. The .xz container of
+// streams, blocks and the Index is parsed in Go, and the compressed data of each
+// block is decoded by liblzma.
 package xz
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math"
+	"os"
+	"runtime"
+	"sync"
+	"time"
 
 	"dill.foo/xz/lzma"
 )
 
 const defaultBufferSize = 32 * 1024
 
-type reader struct {
+// errReaderClosed is returned by Read once the reader has been closed.
+var errReaderClosed = errors.New("reader is closed")
+
+// decoder is implemented by lzma.Stream and xzDecoder.
+type decoder interface {
+	SetNextIn(in []byte)
+	AvailableIn() int
+	SetNextOut(out []byte)
+	AvailableOut() int
+	Code(action lzma.Action) lzma.Return
+	TotalIn() uint64
+	TotalOut() uint64
+	Check() lzma.Check
+	Close() error
+}
+
+// pauser is implemented by decoders whose Code returns at the end of every
+// frame, so that Read does not follow the output of one frame with the next.
+type pauser interface {
+	// unpause reports whether Code returned at the end of a frame, clearing it.
+	unpause() bool
+}
+
+// Reader decompresses the .xz data read from a source. It is created by
+// NewReader and the other constructors of this package.
+type Reader struct {
 	src     io.Reader
-	stream  *lzma.Stream
+	peeker  peeker
+	stream  decoder
 	buf     []byte
 	action  lzma.Action
 	lastErr error
+	// produced is the total output returned by Read.
+	produced int64
+	// remaining is the output left to return if limited to limit bytes by
+	// NewReaderN.
+	limited   bool
+	limit     int64
+	remaining int64
+
+	// newStream creates the decoder of the constructor, opts are the options
+	// it was given. Both are kept to Reset the reader.
+	newStream func(memlimit uint64) (decoder, error)
+	opts      []ReaderOption
+
+	// check is the integrity check of the current stream as told by the
+	// decoder, uncheckable is set if the linked liblzma cannot verify it.
+	check       lzma.Check
+	uncheckable bool
+	// warnings describes conditions that did not stop decoding.
+	warnings []string
+
+	// threads is the number of threads of a multithreaded decoder.
+	threads int
+	// frames is set by NewFrameReader to end every Read at the end of a
+	// stream.
+	frames bool
+	// pooled is the buffer borrowed from the pool of WithSharedBufferPool.
+	pooled *[]byte
+	// unread is the output decoded ahead into byteBuf by ReadByte that is
+	// returned before decoding more.
+	unread  []byte
+	byteBuf []byte
+
+	// Set by a ReaderOption.
+	dictSize             uint32
+	memlimit             uint64
+	readTimeout          time.Duration
+	limiter              *MemLimiter
+	pool                 *sync.Pool
+	onBlock              func(BlockInfo)
+	onStream             func(StreamInfo)
+	report               *Report
+	checkPolicy          func(lzma.Check) error
+	minFill              int
+	tap                  func(p []byte)
+	hashTap              hash.Hash
+	postVerifier         PostVerifier
+	logger               func(event string, kv ...any)
+	maxStreams           int
+	maxThreads           int
+	verifySize           bool
+	ignoreCheck          bool
+	computeChecks        bool
+	maxPerRead           int
+	align                int
+	maxCodeCalls         int
+	codeCalls            int
+	onSkip               func(offset, size int64)
+	completeInput        bool
+	allowTrailingGarbage bool
+	rejectTrailingData   bool
+}
+
+// A ReaderOption configures a reader.
+type ReaderOption func(*Reader)
+
+// WithMemLimit limits the memory the decoder may allocate to n bytes. Decoding
+// input that needs more fails with an Error of code lzma.MemLimitError before
+// the memory is allocated. A limit of zero, the default, is no limit.
+func WithMemLimit(n uint64) ReaderOption {
+	return func(r *Reader) {
+		r.memlimit = n
+	}
+}
+
+// WithMemLimiter acquires the memory of the decoder from l before it is
+// allocated, sharing the budget of l with the other readers using it. The memory
+// is released once the reader has returned an error, such as io.EOF, or is
+// closed. It has no effect on NewLZMAReader and NewReaderThreads, whose decoders
+// allocate memory within liblzma.
+func WithMemLimiter(l *MemLimiter) ReaderOption {
+	return func(r *Reader) {
+		r.limiter = l
+	}
+}
+
+// WithThreads replaces the number of threads given to NewReaderThreads, for
+// callers passing options to a multithreaded reader created elsewhere.
+// WithThreads(1) decodes in the calling goroutine without the memory and
+// overhead of the threads, failing in the same order as NewReader. It has no
+// effect on the other constructors.
+func WithThreads(n int) ReaderOption {
+	return func(r *Reader) {
+		r.maxThreads = n
+	}
+}
+
+// WithEagerAlloc allocates the dictionary of the decoder when the reader is
+// created rather than when the first block is decoded, so that the first Read
+// does not incur a large allocation. dictSize should be the dictionary size
+// the input was compressed with, for example 8 MiB for the default preset.
+//
+// This is best-effort: the allocation is only reused if the first block needs
+// a dictionary of the same size, and the operating system may still commit the
+// memory lazily as it is first written.
+func WithEagerAlloc(dictSize uint32) ReaderOption {
+	return func(r *Reader) {
+		r.dictSize = dictSize
+	}
+}
+
+// NewReader creates a XZ decoder reader from the given source. Concatenated
+// streams are decoded one after another as a single output. A source with no
+// input at all has nothing to decode, so the first Read returns io.EOF.
+//
+// A *bytes.Buffer source is held in memory and decoded in place, like the
+// input of NewBytesReader, rather than copied through the reader's buffer. A
+// *bytes.Reader is read like any other source, as it gives no access to its
+// contents without a copy; use NewBytesReader for a slice.
+func NewReader(src io.Reader, opts ...ReaderOption) *Reader {
+	return newReader(src, opts, lzma.Concatenated, lzma.TellUnsupportedCheck)
+}
+
+// NewSingleStreamReader creates a XZ decoder reader that only decodes the first
+// stream of the given source. Once the stream ends io.EOF is returned and any
+// remaining input is left unread by the decoder.
+func NewSingleStreamReader(src io.Reader, opts ...ReaderOption) *Reader {
+	return newReader(src, opts, lzma.TellUnsupportedCheck)
+}
+
+// NewReaderN creates a XZ decoder reader like NewReader that returns at most n
+// bytes of output. Once n bytes have been returned io.EOF is returned and the
+// decoder is freed without decoding the rest of the input.
+func NewReaderN(src io.Reader, n int64, opts ...ReaderOption) *Reader {
+	r := NewReader(src, opts...)
+	r.limited = true
+	r.limit = n
+	r.remaining = n
+	return r
+}
+
+// NewReaderThreads creates a XZ decoder reader like NewReader that decodes
+// blocks in parallel using up to the given number of threads, or one per CPU if
+// threads is zero. Threads beyond the number of CPUs are not used. Blocks are
+// only decoded in parallel if their headers store their sizes, as written by
+// NewWriterThreads or with WithExplicitSizes.
+//
+// The decoding is done by liblzma, so options that observe the .xz container,
+// such as WithBlockCallback and WithStreamCallback, have no effect. With a
+// single thread, such as with WithThreads(1), or if the linked liblzma has no
+// multithreaded decoder as reported by lzma.HasDecoderThreadsSupport, it
+// decodes like NewReader instead.
+func NewReaderThreads(src io.Reader, threads int, opts ...ReaderOption) *Reader {
+	r := &Reader{}
+	r.newStream = func(memlimit uint64) (decoder, error) {
+		r.threads = threads
+		if r.maxThreads > 0 {
+			r.threads = r.maxThreads
+		}
+		if r.threads <= 0 || r.threads > runtime.NumCPU() {
+			r.threads = runtime.NumCPU()
+		}
+		if !lzma.HasDecoderThreadsSupport() {
+			r.threads = 1
+		}
+		if r.threads == 1 {
+			return newXZDecoder(memlimit, lzma.Concatenated, lzma.TellUnsupportedCheck), nil
+		}
+		return lzma.NewStreamDecoderMT(
+			lzma.MTDecoderOptions{
+				Threads:           uint32(r.threads),
+				MemlimitThreading: min(lzma.PhysMem()/4, memlimit),
+				MemlimitStop:      memlimit,
+			},
+			lzma.Concatenated, lzma.TellUnsupportedCheck,
+		)
+	}
+	r.init(src, opts)
+	return r
+}
+
+// NewReaderSection creates a XZ decoder reader like NewReader that decodes the
+// length bytes at offset of ra, such as .xz data embedded in a larger file.
+// Nothing outside of the section is read.
+func NewReaderSection(ra io.ReaderAt, offset, length int64, opts ...ReaderOption) *Reader {
+	return NewReader(io.NewSectionReader(ra, offset, length), opts...)
+}
+
+// NewValidatingReaderAt creates a XZ decoder reader like NewReaderSection of the
+// first size bytes of ra that first decodes the stream footers and Indexes at
+// the end of the input, failing without decoding any block data if they are
+// corrupt. Corruption the Index does not describe, such as a wrong uncompressed
+// size, is still only found by decoding the blocks.
+func NewValidatingReaderAt(ra io.ReaderAt, size int64, opts ...ReaderOption) (*Reader, error) {
+	index, ret, err := decodeIndex(io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if ret != lzma.StreamEnd {
+		return nil, &Error{Code: ret}
+	}
+	_ = index.Close()
+	return NewReaderSection(ra, 0, size, opts...), nil
+}
+
+// NewVerifyingReader creates a XZ decoder reader like NewReader that also
+// recomputes the integrity check of every block from the decoded output,
+// failing with ErrCheckMismatch if it differs from the check stored in the
+// block. This is in addition to the verification done by liblzma.
+func NewVerifyingReader(src io.Reader, opts ...ReaderOption) *Reader {
+	return newDecoderReader(src, func(memlimit uint64) (decoder, error) {
+		d := newXZDecoder(memlimit, lzma.Concatenated, lzma.TellUnsupportedCheck)
+		d.verify = true
+		return d, nil
+	}, opts)
+}
+
+// NewBytesReader creates a XZ decoder reader like NewReader that decodes src in
+// place. src must not be modified until the Reader has returned an error.
+func NewBytesReader(src []byte, opts ...ReaderOption) *Reader {
+	return newReader(&slicePeeker{src}, opts, lzma.Concatenated, lzma.TellUnsupportedCheck)
+}
+
+// NewLZMAReader creates a decoder reader of the legacy .lzma format, as written
+// by NewLZMAWriter, from the given source.
+func NewLZMAReader(src io.Reader, opts ...ReaderOption) *Reader {
+	return newDecoderReader(src, func(memlimit uint64) (decoder, error) {
+		return lzma.NewAloneDecoder(memlimit)
+	}, opts)
+}
+
+// WithBlockCallback calls fn with each block of the input once it has been
+// decoded and its integrity check verified. fn is called from Read. It has no
+// effect on NewLZMAReader.
+func WithBlockCallback(fn func(BlockInfo)) ReaderOption {
+	return func(r *Reader) {
+		r.onBlock = fn
+	}
+}
+
+// WithStreamCallback calls fn with each stream of the input once it has been
+// decoded and its Index and footer verified. Concatenated streams may each use
+// a different integrity check. fn is called from Read. It has no effect on
+// NewLZMAReader.
+func WithStreamCallback(fn func(StreamInfo)) ReaderOption {
+	return func(r *Reader) {
+		r.onStream = fn
+	}
+}
+
+// WithReport accumulates every block and stream of the input in rep as it is
+// decoded, including the integrity check stored in each block, for inspecting
+// the input like xz --list --verbose once it has been read. A stream is added
+// once it has been decoded and its Index and footer verified. It has no effect
+// on NewLZMAReader and NewReaderThreads.
+func WithReport(rep *Report) ReaderOption {
+	return func(r *Reader) {
+		r.report = rep
+	}
+}
+
+// WithCheckPolicy calls policy with the integrity check of each stream as soon
+// as it is known, before any of the stream is decoded. If policy returns an
+// error decoding is aborted and Read returns the error as is. It has no effect
+// on NewLZMAReader.
+func WithCheckPolicy(policy func(lzma.Check) error) ReaderOption {
+	return func(r *Reader) {
+		r.checkPolicy = policy
+	}
+}
+
+// WithMinFill reads at least n bytes from the source, or as much as fits in
+// the reader's buffer, before decoding unless the source ends first. This
+// reduces the calls into liblzma when the source returns small reads, as is
+// typical of network connections, at the cost of latency.
+func WithMinFill(n int) ReaderOption {
+	return func(r *Reader) {
+		r.minFill = n
+	}
+}
+
+// WithReadAlignment only reads from the source into buffers whose length is a
+// multiple of align, which must be a power of two, for sources that require
+// aligned reads. It has no effect on sources held in memory, such as a
+// *bytes.Buffer, nor with WithCompleteInput which reads the source with
+// io.ReadAll.
+func WithReadAlignment(align int) ReaderOption {
+	return func(r *Reader) {
+		if align <= 0 || align&(align-1) != 0 {
+			r.lastErr = errors.New("xz: read alignment must be a power of two")
+		}
+		r.align = align
+	}
+}
+
+// WithMaxCodeCalls aborts decoding with ErrBudgetExceeded once the decoder has
+// been run n times, as a coarse bound on the work done for untrusted input.
+// Each Read runs the decoder at least once, and again for every refill of the
+// reader's buffer.
+func WithMaxCodeCalls(n int) ReaderOption {
+	return func(r *Reader) {
+		r.maxCodeCalls = n
+	}
+}
+
+// WithTap calls tap with the output of each Read before it is returned, for
+// example to hash or measure the decoded data as it streams. tap must not
+// modify or retain p.
+func WithTap(tap func(p []byte)) ReaderOption {
+	return func(r *Reader) {
+		r.tap = tap
+	}
+}
+
+// WithHashTap writes the output of each Read to h before it is returned, like
+// WithTap, such as to hash the decoded data with a hash other than the
+// integrity check of the stream.
+func WithHashTap(h hash.Hash) ReaderOption {
+	return func(r *Reader) {
+		r.hashTap = h
+	}
+}
+
+// PostVerifier verifies the decoded output of a reader created with
+// WithPostVerifier, such as against an integrity scheme layered over xz.
+type PostVerifier interface {
+	// Write is given the output of each Read before it is returned.
+	io.Writer
+	// Verify is called once all the output has been written.
+	Verify() error
+}
+
+// WithPostVerifier writes the output of each Read to v before it is returned
+// and calls v.Verify once the data has been decoded, returning its error in
+// place of io.EOF. An error from v.Write is returned by the Read it was given
+// the output of and ends decoding. Reset reuses v, which must be reset by the
+// caller.
+func WithPostVerifier(v PostVerifier) ReaderOption {
+	return func(r *Reader) {
+		r.postVerifier = v
+	}
+}
+
+// WithPostVerify is like WithPostVerifier for a function of the complete
+// decoded output, which the reader holds until the data has been decoded.
+// WithPostVerifier verifies the output as it streams without the copy.
+func WithPostVerify(verify func(decoded []byte) error) ReaderOption {
+	return func(r *Reader) {
+		r.postVerifier = &bufferedVerifier{verify: verify}
+	}
+}
+
+// bufferedVerifier holds the output of a reader for WithPostVerify.
+type bufferedVerifier struct {
+	decoded bytes.Buffer
+	verify  func(decoded []byte) error
+}
+
+func (v *bufferedVerifier) Write(p []byte) (int, error) {
+	return v.decoded.Write(p)
+}
+
+func (v *bufferedVerifier) Verify() error {
+	return v.verify(v.decoded.Bytes())
+}
+
+// WithMaxStreams limits the input to n concatenated streams, bounding the
+// work done on adversarial input made of many small streams. Decoding fails
+// with an Error of code lzma.DataError matching ErrTooManyStreams at the start
+// of stream n+1, also when corrupt streams are skipped.
+func WithMaxStreams(n int) ReaderOption {
+	return func(r *Reader) {
+		r.maxStreams = n
+	}
+}
+
+// WithLogger calls logger with the lifecycle events of the decoder, for tracing
+// the decompression of large inputs. The events and their key-value pairs are:
+//
+//   - "stream start" with "offset" and "check" once a stream header is decoded
+//   - "block decoded" with "offset", "size" and "uncompressed_size"
+//   - "check verified" with "offset" and "check" following "block decoded" if
+//     the integrity check of the block was verified
+//   - "stream end" with "offset", "size", "uncompressed_size" and "blocks"
+//   - "error" with "err" when decoding fails
+//
+// Offsets and sizes are int64 and checks are lzma.Check. Only "error" is
+// logged by the legacy .lzma reader.
+func WithLogger(logger func(event string, kv ...any)) ReaderOption {
+	return func(r *Reader) {
+		r.logger = logger
+	}
+}
+
+// WithReadTimeout fails decoding with os.ErrDeadlineExceeded if a read of the
+// source does not complete within d, for sources such as network connections
+// without their own deadlines. The source is read by another goroutine so the
+// Read of the reader can return; a read that timed out is left to complete in
+// the background and the source is not read again. It has no effect on sources
+// held in memory.
+func WithReadTimeout(d time.Duration) ReaderOption {
+	return func(r *Reader) {
+		r.readTimeout = d
+	}
+}
+
+// WithSharedBufferPool borrows the buffer the source is read into from p, which
+// must hold *[]byte of a nonzero length, rather than allocating one for each
+// reader. The buffer is returned to p once the reader has returned an error,
+// such as io.EOF, or is closed. A buffer is allocated as usual if p is empty and
+// has no New function. It has no effect on sources held in memory.
+func WithSharedBufferPool(p *sync.Pool) ReaderOption {
+	return func(r *Reader) {
+		r.pool = p
+	}
+}
+
+// WithIgnoreCheck decodes without verifying the integrity check of any block,
+// for data known to be intact whose stored checks are damaged. It has no effect
+// on NewReaderThreads and NewLZMAReader.
+func WithIgnoreCheck() ReaderOption {
+	return func(r *Reader) {
+		r.ignoreCheck = true
+	}
+}
+
+// WithComputedChecks recomputes the integrity check of every block from its
+// decoded output, to be returned by ComputedChecks. Combined with
+// WithIgnoreCheck it gives the checks a damaged file should have stored. It has
+// no effect on NewReaderThreads and NewLZMAReader.
+func WithComputedChecks() ReaderOption {
+	return func(r *Reader) {
+		r.computeChecks = true
+	}
+}
+
+// WithVerifyUncompressedSize compares the uncompressed size of every stream
+// with the sum of the uncompressed sizes recorded in its Index once the Index
+// is decoded, failing with an error matching ErrSizeMismatch if they differ.
+// liblzma rejects such an Index as corrupt regardless; the option identifies
+// the cause of the error, at the cost of buffering the Index.
+func WithVerifyUncompressedSize() ReaderOption {
+	return func(r *Reader) {
+		r.verifySize = true
+	}
+}
+
+// WithSkipCorruptStreams recovers from a corrupt stream of concatenated
+// streams by skipping the input up to the magic bytes of the next stream and
+// decoding from there, rather than failing. onSkip is called with the offset
+// and size of every range of input skipped, which runs from the start of the
+// corrupt stream, or the end of the last stream if the corruption is between
+// streams, to the start of the next stream or the end of the input.
+//
+// Output decoded from a corrupt stream before the corruption was detected has
+// already been returned and is not withdrawn. Only corruption reported as
+// lzma.DataError is skipped; the option has no effect on readers that decode a
+// single stream.
+func WithSkipCorruptStreams(onSkip func(offset, size int64)) ReaderOption {
+	return func(r *Reader) {
+		r.onSkip = onSkip
+	}
+}
+
+// WithMaxBytesPerRead caps the output of a single Read at n bytes, even if p
+// is larger, to bound the decoding done by each call when the caller needs to
+// yield to other work. It does not affect how much input is read at a time.
+func WithMaxBytesPerRead(n int) ReaderOption {
+	return func(r *Reader) {
+		r.maxPerRead = n
+	}
+}
+
+// WithCompleteInput declares that the source holds the complete compressed
+// input. It is read in full on the first Read and decoded with lzma.Finish from
+// the start. As with any reader, input ending before the end of the stream is
+// reported as an Error matching io.ErrUnexpectedEOF.
+func WithCompleteInput() ReaderOption {
+	return func(r *Reader) {
+		r.completeInput = true
+	}
+}
+
+// PaddingMode is how strictly the input following the last stream is checked.
+type PaddingMode int
+
+const (
+	// PaddingStrict requires the input after a stream to be Stream Padding of
+	// a multiple of four null bytes or another stream, like liblzma.
+	PaddingStrict PaddingMode = iota
+	// PaddingLenient ends decoding cleanly at any other trailing bytes, like
+	// WithAllowTrailingGarbage.
+	PaddingLenient
+)
+
+// WithPaddingMode sets how strictly the input following the last stream is
+// checked. The default is PaddingStrict.
+func WithPaddingMode(mode PaddingMode) ReaderOption {
+	return func(r *Reader) {
+		r.allowTrailingGarbage = mode == PaddingLenient
+	}
+}
+
+// WithAllowTrailingGarbage ends decoding with io.EOF, ignoring the rest of the
+// input, when the data following a complete stream is neither valid Stream
+// Padding nor the start of another stream. By default this is an error. Errors
+// within a stream, including the first, are still reported.
+func WithAllowTrailingGarbage() ReaderOption {
+	return func(r *Reader) {
+		r.allowTrailingGarbage = true
+	}
+}
+
+// WithRejectTrailingData makes NewSingleStreamReader read the input following
+// its stream to the end, failing with an error matching ErrTrailingData unless
+// it is valid Stream Padding, including when it is another stream. By default
+// the following input is left unread. WithAllowTrailingGarbage takes precedence.
+// It has no effect on the readers of concatenated streams, which decode the
+// following input.
+func WithRejectTrailingData() ReaderOption {
+	return func(r *Reader) {
+		r.rejectTrailingData = true
+	}
+}
+
+// withBuffer stages input read from the source in buf.
+func withBuffer(buf []byte) ReaderOption {
+	return func(r *Reader) {
+		r.buf = buf
+	}
+}
+
+func newReader(src io.Reader, opts []ReaderOption, flags ...lzma.DecoderOpt) *Reader {
+	return newDecoderReader(src, func(memlimit uint64) (decoder, error) {
+		return newXZDecoder(memlimit, flags...), nil
+	}, opts)
+}
+
+func newDecoderReader(src io.Reader, newStream func(memlimit uint64) (decoder, error), opts []ReaderOption) *Reader {
+	r := &Reader{newStream: newStream}
+	r.init(src, opts)
+	return r
+}
+
+// Reset discards the state of the Reader, freeing its decoder if it has not
+// finished, and makes it decode src as if newly created by the same constructor
+// with the same options. The buffer of the Reader is reused. It returns an
+// error if the new decoder could not be created, which is also returned by Read.
+func (r *Reader) Reset(src io.Reader) error {
+	return r.ResetWithOptions(src, r.opts...)
+}
+
+// ResetWithOptions is like Reset but re-initializes the decoder with opts in
+// place of the options the Reader was created with, such as a different
+// WithMemLimit for a particular untrusted input. The options also apply to later
+// calls to Reset.
+func (r *Reader) ResetWithOptions(src io.Reader, opts ...ReaderOption) error {
+	if r.lastErr == nil {
+		_ = r.stream.Close()
+	}
+	r.releaseBuffer()
+	*r = Reader{
+		buf:       r.buf,
+		newStream: r.newStream,
+		threads:   r.threads,
+		frames:    r.frames,
+		limited:   r.limited,
+		limit:     r.limit,
+		remaining: r.limit,
+	}
+	r.init(src, opts)
+	if r.lastErr != nil {
+		return r.lastErr
+	}
+	return nil
+}
+
+// init applies opts and creates the decoder of a new or reset Reader.
+func (r *Reader) init(src io.Reader, opts []ReaderOption) {
+	r.src = src
+	r.action = lzma.Run
+	r.opts = opts
+	buf := r.buf
+	r.buf = nil
+	switch src := src.(type) {
+	case peeker:
+		r.peeker = src
+	case *bytes.Buffer:
+		r.peeker = bufferPeeker{src}
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.readTimeout > 0 && r.peeker == nil {
+		r.src = &timeoutReader{src: src, timeout: r.readTimeout, done: make(chan timeoutResult, 1)}
+	}
+	// Options report invalid arguments through lastErr, in which case no
+	// decoder is created.
+	if r.lastErr == nil {
+		memlimit := r.memlimit
+		if memlimit == 0 {
+			memlimit = math.MaxUint64
+		}
+		r.stream, r.lastErr = r.newStream(memlimit)
+	}
+	if r.peeker == nil && r.buf == nil {
+		r.buf = buf
+		if r.buf == nil && r.pool != nil {
+			if b, _ := r.pool.Get().(*[]byte); b != nil && len(*b) > 0 {
+				r.pooled = b
+				r.buf = *b
+			}
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, defaultBufferSize)
+		}
+	}
+	if r.align > 1 && r.buf != nil {
+		r.buf = r.buf[:len(r.buf)&^(r.align-1)]
+		if len(r.buf) == 0 {
+			r.buf = make([]byte, r.align)
+		}
+	}
+	if d, ok := r.stream.(*xzDecoder); ok {
+		d.onBlock = r.onBlock
+		d.onStream = r.onStream
+		d.report = r.report
+		d.log = r.logger
+		d.maxStreams = r.maxStreams
+		d.pauseAtStreamEnd = r.frames
+		d.limiter = r.limiter
+		d.verifySize = r.verifySize
+		d.ignoreCheck = d.ignoreCheck || r.ignoreCheck
+		d.recompute = r.computeChecks
+		if d.concatenated {
+			d.onSkip = r.onSkip
+		}
+		if r.checkPolicy != nil {
+			d.tellAnyCheck = true
+		}
+		d.allowTrailingGarbage = r.allowTrailingGarbage
+		d.rejectTrailingData = r.rejectTrailingData
+		if r.dictSize > 0 {
+			d.preallocate(r.dictSize)
+		}
+	}
 }
 
-// NewReader creates a XZ decoder reader from the given source.
-func NewReader(src io.Reader) io.ReadCloser {
-	stream, err := lzma.NewStreamDecoder(math.MaxUint64, lzma.Concatenated, lzma.TellUnsupportedCheck)
-	return &reader{
-		src:     src,
-		stream:  stream,
-		buf:     make([]byte, defaultBufferSize),
-		action:  lzma.Run,
-		lastErr: err,
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(r.unread) > 0 {
+		n := copy(p, r.unread)
+		r.unread = r.unread[n:]
+		return n, nil
+	}
+	if r.limited {
+		if r.remaining <= 0 {
+			r.finish()
+			r.releaseBuffer()
+			return 0, r.lastErr
+		}
+		if int64(len(p)) > r.remaining {
+			p = p[:r.remaining]
+		}
+	}
+	if r.maxPerRead > 0 && len(p) > r.maxPerRead {
+		p = p[:r.maxPerRead]
+	}
+	failed := r.lastErr != nil
+	n, err := r.read(p)
+	if r.logger != nil && !failed && err != nil && err != io.EOF {
+		r.logger("error", "err", err)
+	}
+	r.produced += int64(n)
+	if r.limited {
+		r.remaining -= int64(n)
+		if r.remaining <= 0 && err == nil {
+			r.finish()
+		}
+	}
+	if r.tap != nil && n > 0 {
+		r.tap(p[:n])
+	}
+	if r.hashTap != nil && n > 0 {
+		r.hashTap.Write(p[:n])
+	}
+	if r.postVerifier != nil {
+		err = r.postVerify(p[:n], err)
+	}
+	if r.lastErr != nil {
+		r.releaseBuffer()
 	}
+	return n, err
 }
 
-func (r *reader) Read(p []byte) (int, error) {
+// postVerify writes the output of a Read to the PostVerifier, verifying it
+// once decoding has ended, and returns the error of the Read.
+func (r *Reader) postVerify(p []byte, err error) error {
+	if len(p) > 0 {
+		if _, werr := r.postVerifier.Write(p); werr != nil {
+			if r.lastErr == nil {
+				_ = r.stream.Close()
+			}
+			r.lastErr = werr
+			r.postVerifier = nil
+			return werr
+		}
+	}
+	if r.lastErr != io.EOF {
+		return err
+	}
+	// The output is only verified once.
+	verify := r.postVerifier
+	r.postVerifier = nil
+	if verr := verify.Verify(); verr != nil {
+		r.lastErr = verr
+		return verr
+	}
+	return err
+}
+
+// ReadByte implements io.ByteReader. It decodes ahead into a small buffer of
+// the reader, returned by the following calls of ReadByte and Read, so that
+// consumers reading a byte at a time do not code the stream for every byte.
+func (r *Reader) ReadByte() (byte, error) {
+	for len(r.unread) == 0 {
+		if r.byteBuf == nil {
+			r.byteBuf = make([]byte, 512)
+		}
+		n, err := r.Read(r.byteBuf)
+		r.unread = r.byteBuf[:n]
+		if n == 0 && err != nil {
+			return 0, err
+		}
+	}
+	b := r.unread[0]
+	r.unread = r.unread[1:]
+	return b, nil
+}
+
+// releaseBuffer returns the buffer borrowed from the pool of
+// WithSharedBufferPool once the source is no longer read.
+func (r *Reader) releaseBuffer() {
+	if r.pooled == nil {
+		return
+	}
+	r.pool.Put(r.pooled)
+	r.pooled = nil
+	r.buf = nil
+}
+
+// finish frees the decoder once the output limit is reached.
+func (r *Reader) finish() {
+	if r.lastErr == nil {
+		_ = r.stream.Close()
+		r.lastErr = io.EOF
+	}
+}
+
+func (r *Reader) read(p []byte) (int, error) {
 	if r.lastErr != nil || len(p) == 0 {
 		return 0, r.lastErr
 	}
+	if r.completeInput && r.action != lzma.Finish {
+		if err := r.readComplete(); err != nil {
+			// Source errors are returned unwrapped so callers can match them.
+			r.lastErr = err
+			return 0, err
+		}
+	}
 	r.stream.SetNextOut(p)
 	for {
-		if r.stream.AvailableIn() == 0 {
-			n, err := r.src.Read(r.buf)
+		var in []byte
+		if r.peeker != nil {
+			// The contents are peeked for every call as the source may be
+			// modified between calls to Read.
+			in = r.peeker.peek()
+			if len(in) == 0 {
+				if r.stream.TotalIn() == 0 {
+					return r.endEmpty()
+				}
+				r.action = lzma.Finish
+			}
+			r.stream.SetNextIn(in)
+		} else if r.stream.AvailableIn() == 0 && r.action != lzma.Finish {
+			// The source is not read again once it has returned io.EOF.
+			n, err := r.fill()
 			if err != nil && err != io.EOF {
+				// Source errors are returned unwrapped so callers can match
+				// them, along with the output already decoded into p.
 				r.lastErr = err
-				return 0, err
+				written := len(p) - r.stream.AvailableOut()
+				_ = r.stream.Close()
+				return written, err
 			}
 			if err == io.EOF {
+				if n == 0 && r.stream.TotalIn() == 0 {
+					return r.endEmpty()
+				}
 				r.action = lzma.Finish
 			}
 			r.stream.SetNextIn(r.buf[:n])
 		}
+		if r.maxCodeCalls > 0 && r.codeCalls >= r.maxCodeCalls {
+			r.lastErr = ErrBudgetExceeded
+			_ = r.stream.Close()
+			return len(p) - r.stream.AvailableOut(), r.lastErr
+		}
+		r.codeCalls++
 		ret := r.stream.Code(r.action)
+		if r.peeker != nil {
+			r.peeker.discard(len(in) - r.stream.AvailableIn())
+		}
 		written := len(p) - r.stream.AvailableOut()
 		switch ret {
 		case lzma.Ok:
 			if r.stream.AvailableOut() == 0 {
 				return written, nil
 			}
+			if d, ok := r.stream.(pauser); ok && d.unpause() && written > 0 {
+				return written, nil
+			}
+		case lzma.NoCheck, lzma.UnsupportedCheck, lzma.GetCheck:
+			// Tells are informational and decoding continues as normal.
+			r.check = r.stream.Check()
+			r.uncheckable = ret == lzma.UnsupportedCheck
+			if r.uncheckable {
+				r.warnings = append(
+					r.warnings,
+					fmt.Sprintf("integrity check %d is not supported and is not verified", r.check),
+				)
+			}
+			if r.checkPolicy != nil {
+				if err := r.checkPolicy(r.check); err != nil {
+					r.lastErr = err
+					_ = r.stream.Close()
+					return written, err
+				}
+			}
+			if r.stream.AvailableOut() == 0 {
+				return written, nil
+			}
 		case lzma.StreamEnd:
 			r.lastErr = io.EOF
 			_ = r.stream.Close()
 			return written, io.EOF
 		default:
-			r.lastErr = fmt.Errorf("lzma return error code=%d", ret)
+			r.lastErr = r.codeError(ret, r.produced+int64(written))
 			_ = r.stream.Close()
 			return written, r.lastErr
 		}
 	}
 }
 
+// fill reads from the source into buf until it holds at least minFill bytes.
+// With WithReadAlignment, reads are only made into an aligned length of buf.
+func (r *Reader) fill() (int, error) {
+	n := 0
+	for {
+		size := len(r.buf) - n
+		if r.align > 1 {
+			size &^= r.align - 1
+		}
+		m, err := r.src.Read(r.buf[n : n+size])
+		n += m
+		if err != nil || n >= r.minFill || len(r.buf)-n < max(r.align, 1) {
+			return n, err
+		}
+	}
+}
+
+// endEmpty ends decoding of a source that holds no input at all, which has
+// nothing to decode rather than being a truncated stream.
+func (r *Reader) endEmpty() (int, error) {
+	r.lastErr = io.EOF
+	_ = r.stream.Close()
+	return 0, io.EOF
+}
+
+// readComplete reads the whole source, unless it is already held in memory, to
+// be decoded with lzma.Finish.
+func (r *Reader) readComplete() error {
+	if r.peeker == nil {
+		b, err := io.ReadAll(r.src)
+		if err != nil {
+			return err
+		}
+		r.peeker = &slicePeeker{b}
+		r.buf = nil
+	}
+	r.action = lzma.Finish
+	return nil
+}
+
+// peeker is implemented by sources whose contents are held in memory so that
+// they can be decoded in place rather than copied through the reader's buffer.
+type peeker interface {
+	// peek returns the unread contents without advancing the source.
+	peek() []byte
+	// discard advances the source by n bytes.
+	discard(n int)
+}
+
+type bufferPeeker struct {
+	buf *bytes.Buffer
+}
+
+func (p bufferPeeker) peek() []byte  { return p.buf.Bytes() }
+func (p bufferPeeker) discard(n int) { p.buf.Next(n) }
+
+// slicePeeker is the source of NewBytesReader.
+type slicePeeker struct {
+	b []byte
+}
+
+func (p *slicePeeker) Read(b []byte) (int, error) {
+	if len(p.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.b)
+	p.b = p.b[n:]
+	return n, nil
+}
+
+func (p *slicePeeker) peek() []byte  { return p.b }
+func (p *slicePeeker) discard(n int) { p.b = p.b[n:] }
+
+// timeoutReader fails reads of src that do not complete within timeout. Reads
+// are made into buf, owned by the reading goroutine, so that one that times out
+// does not write to the buffer of the caller once it has returned.
+type timeoutReader struct {
+	src     io.Reader
+	timeout time.Duration
+	buf     []byte
+	done    chan timeoutResult
+	err     error
+}
+
+type timeoutResult struct {
+	n   int
+	err error
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if len(t.buf) < len(p) {
+		t.buf = make([]byte, len(p))
+	}
+	buf := t.buf[:len(p)]
+	go func() {
+		n, err := t.src.Read(buf)
+		t.done <- timeoutResult{n, err}
+	}()
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-t.done:
+		return copy(p, buf[:res.n]), res.err
+	case <-timer.C:
+		t.err = os.ErrDeadlineExceeded
+		return 0, t.err
+	}
+}
+
+// Threads is the maximum number of threads the decoder uses, which is one
+// unless created by NewReaderThreads. liblzma starts threads as blocks need
+// them, and uses fewer if decoding with all of them would use more than a
+// quarter of the physical memory.
+func (r *Reader) Threads() int {
+	if r.threads == 0 {
+		return 1
+	}
+	return r.threads
+}
+
+// TotalIn is the total compressed input consumed by the decoder. Input read from
+// the source but not yet decoded is not counted.
+func (r *Reader) TotalIn() uint64 {
+	if r.stream == nil {
+		return 0
+	}
+	return r.stream.TotalIn()
+}
+
+// CompressedOffset is the offset in the source, from where the reader started
+// reading it, of the next byte of input to decode. It is the input consumed as
+// counted by TotalIn, excluding input read ahead from the source. Once decoding
+// fails it locates roughly where in the source the problem is, at or shortly
+// after the corrupt data.
+func (r *Reader) CompressedOffset() int64 {
+	return int64(r.TotalIn())
+}
+
+// CurrentBlock is the zero-based index of the block being decoded, or of the
+// last block decoded between blocks, counting the blocks of every stream. The
+// next block may already have been started by the Read that returns the end of
+// the output of a block. It is zero before the first block and for readers
+// created by NewReaderThreads or NewLZMAReader.
+func (r *Reader) CurrentBlock() int {
+	d, ok := r.stream.(*xzDecoder)
+	if !ok || d.blocks == 0 {
+		return 0
+	}
+	return d.blocks - 1
+}
+
+// CheckType is the integrity check of the stream being decoded, once its
+// header has been decoded, or of the last stream decoded.
+func (r *Reader) CheckType() lzma.Check {
+	if r.stream == nil {
+		return lzma.CheckNone
+	}
+	return r.stream.Check()
+}
+
+// CheckValue is the integrity check stored after the data of the last block
+// decoded, which liblzma verified against the check it computed of the block.
+// liblzma computes the check internally so its running value is not available
+// while a block is decoded; WithHashTap hashes the output as it is read
+// instead. It is nil before the first block has been decoded and for
+// NewLZMAReader and NewReaderThreads.
+func (r *Reader) CheckValue() []byte {
+	if d, ok := r.stream.(*xzDecoder); ok {
+		return d.lastCheck
+	}
+	return nil
+}
+
+// Warnings returns a description of every condition that did not stop
+// decoding but may matter to the caller, in the order they occurred, such as a
+// stream whose integrity check the linked liblzma does not support. The data of
+// such a stream is still decoded, without being verified.
+func (r *Reader) Warnings() []string {
+	return r.warnings
+}
+
+// ComputedChecks returns the integrity check recomputed for every block decoded
+// so far, in the byte order it is stored in, if the reader was created with
+// WithComputedChecks. The check of a block is nil unless it is one of
+// lzma.CheckCRC32, lzma.CheckCRC64 and lzma.CheckSHA256.
+func (r *Reader) ComputedChecks() [][]byte {
+	d, ok := r.stream.(*xzDecoder)
+	if !ok {
+		return nil
+	}
+	return d.computed
+}
+
+// StreamBoundaryOffset is the offset in the source just after the footer of the
+// last stream decoded, and false if no stream has been decoded yet or the input
+// is not .xz. Decoding can be resumed at a stream boundary by reopening the
+// source at the offset, skipping any Stream Padding that follows it, with the
+// output continuing from that of the streams already decoded.
+//
+// It may be called from a WithStreamCallback callback to checkpoint each stream
+// as it ends.
+func (r *Reader) StreamBoundaryOffset() (int64, bool) {
+	d, ok := r.stream.(*xzDecoder)
+	if !ok || !d.streamEnded {
+		return 0, false
+	}
+	return int64(d.streamEnd), true
+}
+
+// TotalOut is the total decompressed output produced by the decoder.
+func (r *Reader) TotalOut() uint64 {
+	if r.stream == nil {
+		return 0
+	}
+	return r.stream.TotalOut()
+}
+
+// codeError returns the error for a failing Return from the decoder after
+// produced bytes of output.
+func (r *Reader) codeError(ret lzma.Return, produced int64) error {
+	err := &Error{Code: ret, BytesProduced: produced}
+	if d, ok := r.stream.(*xzDecoder); ok {
+		err.Err = d.err
+	}
+	if ret == lzma.BufError && err.Err == nil {
+		err.Err = ErrNoProgress
+		// No progress once the input has ended means it was truncated.
+		if r.action == lzma.Finish {
+			err.Err = io.ErrUnexpectedEOF
+		}
+	}
+	return err
+}
+
 // Close closes the reader. If the caller consumes the entire Reader until io.EOF
 // (or other error) as is typical with methods such as io.ReadAll then the
 // resources will have been freed from the terminal Read call and close will
 // have no effect.
-func (r *reader) Close() error {
-	if r.lastErr == nil {
-		r.lastErr = errors.New("reader is closed")
+//
+// If decoding failed, the first call to Close returns the error so that it is
+// not lost by callers that only check Close. It returns nil after io.EOF.
+func (r *Reader) Close() error {
+	err := r.lastErr
+	switch err {
+	case nil:
 		_ = r.stream.Close()
+	case io.EOF, errReaderClosed:
+		err = nil
 	}
-	return nil
+	r.lastErr = errReaderClosed
+	r.unread = nil
+	r.releaseBuffer()
+	return err
 }
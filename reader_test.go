@@ -4,14 +4,43 @@
 package xz
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/iotest"
+	"time"
+
+	"dill.foo/xz/lzma"
+)
+
+// loremBase64 is good-1-lzma2-1.xz, which decodes to loremText.
+const (
+	loremBase64 = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAwADlAK0LAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg=="
+	loremText   = "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n"
 )
 
+func decodeBase64(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
 func TestReader(t *testing.T) {
 	const base64Input = "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla"
 	r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(base64Input))
@@ -21,515 +50,2537 @@ func TestReader(t *testing.T) {
 	}
 }
 
+// readerTests are decoded by TestReader_Read and seed FuzzReader.
+var readerTests = []struct {
+	name, base64Input, want string
+	wantErr                 bool
+	srcReader, outReader    func(io.Reader) io.Reader
+}{
+	{
+		name:        "behaves with DataErrReader",
+		base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
+		want:        "Hello\nWorld!\n",
+		srcReader:   iotest.DataErrReader,
+	},
+	{
+		name:        "behaves with OneByteReader",
+		base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
+		want:        "Hello\nWorld!\n",
+		srcReader:   iotest.OneByteReader,
+	},
+	{
+		name:        "behaves with HalfReader",
+		base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
+		want:        "Hello\nWorld!\n",
+		srcReader:   iotest.HalfReader,
+	},
+	{
+		name:        "behaves with ErrReader",
+		base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
+		srcReader: func(r io.Reader) io.Reader {
+			return iotest.ErrReader(errors.New("error"))
+		},
+		wantErr: true,
+	},
+	{
+		name:        "behaves with output to OneByteReader",
+		base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
+		want:        "Hello\nWorld!\n",
+		outReader:   iotest.OneByteReader,
+	},
+	// The remaining test cases ensures the reader is compliant with up
+	// stream XZ-utils spec. Cases are from:
+	// https://github.com/tukaani-project/xz/tree/fbb3ce541ef79cad1710e88a27a5babb5f6f8e5b/tests/files
+	{
+		// has one stream with no blocks.
+		name:        "good-0-empty.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=",
+	},
+	{
+		// has one stream with no blocks followed by four-byte stream padding.
+		name:        "good-0pad-empty.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAA",
+	},
+	{
+		// has two zero-block streams concatenated without stream padding.
+		name:        "good-0cat-empty.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg==",
+	},
+	{
+		// has two zero-block streams concatenated with four-byte stream
+		// padding between the streams
+		name:        "good-0catpad-empty.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAA/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=",
+	},
+	{
+		// has one stream with one block with two uncompressed LZMA2 chunks
+		// and no integrity check
+		name:        "good-1-check-none.xz",
+		base64Input: "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg==",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// has one stream with one block with two uncompressed LZMA2 chunks
+		// and CRC32 check.
+		name:        "good-1-check-crc32.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// is like good-1-check-crc32.xz but with CRC64.
+		name:        "good-1-check-crc64.xz",
+		base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// is like good-1-check-crc32.xz but with SHA256.
+		name:        "good-1-check-sha256.xz",
+		base64Input: "/Td6WFoAAArh+wyhAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgCOWTXn4TNozZaI/o9IoJVSk2dqAhViWCx+hI2v4T+wRgABQA2Thk6uGJtLmgEAAAAAClla",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// has one stream with two blocks with one uncompressed LZMA2 chunk
+		// in each block.
+		name:        "good-2-lzma2.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// has both Compressed Size and Uncompressed Size in the block
+		// Header. This has also four extra bytes of Header padding.
+		name:        "good-1-block_header-1.xz",
+		base64Input: "/Td6WFoAAAFpIt42A8ARDSEBCAAAAAAAf9456wEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// has known Compressed Size.
+		name:        "good-1-block_header-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42AkARIQEIAAA6TIjhAQAMSGVsbG8KV29ybGQhCgAAAABDo6IVAAEhDXXcqNKQQpkNAQAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// has known Uncompressed Size.
+		name:        "good-1-block_header-3.xz",
+		base64Input: "/Td6WFoAAAFpIt42AoANIQEIAABREYFZAQAMSGVsbG8KV29ybGQhCgAAAABDo6IVAAEhDXXcqNKQQpkNAQAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+	},
+	{
+		// has two LZMA2 chunks, of which the second sets new properties.
+		name:        "good-1-lzma2-1.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAwADlAK0LAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg==",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+	},
+	{
+		// has two LZMA2 chunks, of which the second resets the state
+		// without specifying new properties.
+		name:        "good-1-lzma2-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAoADlAK8AjPGdQH2CTyRyFPGdhMtaMmyXakCDi/CvMcK0ZW+J/fvYi1RBghZUEtFN1YbFwFr6SWOREf7/9Y8UAoVheThKS09BY/iHLyzm4ukxj4sU06F+gehVAu8hMaJ7BcwfpGDngaqn2XiC5hiyqxyqGS/ChxTF2cs/0BimzSpLXajHXwFnKEws5MzVUp6TAn4QXfUDsZgvJu2Ge1Z/E3lYj0QQ2dkPluk7v7W42ivh1oHxyQAA+0dI2wABgwPJAwAArtfSFT4wDYsCAAAAAAFZWg==",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+	},
+	{
+		// has two LZMA2 chunks, of which the first is uncompressed and the
+		// second is LZMA. The first chunk resets dictionary and the second
+		// sets new properties.
+		name:        "good-1-lzma2-3.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIArAAZMBJF0AMpsJbFTXLpVs+fc31RziRgKCdftJdo1zU7b9bdvK29lEC7EuvhO2uqji8+11VNxBIMy/NlsgmV0PIaEGo5Ytt5ec8Hv+4hKMLVHw23Z3faR705Xp+wXm9ZePYunbMLu0cD0WeAN3Oot61bj4Sicl9Y6qJBSmKShrL3PgoXG0e6SAUEDK79u0lf27wYyOYJfby38h7cAQcRp9y80J0Nn/bYDAZ30/xpTPW91REdHL1CDXK4ROqEW7QngaaEBfJF6JOjZ925gozPmD7DIGMUdHO2wc9GI0QLMou1Q23XoOHDYlOFgG+BWjzhjI/ZYeaSkDw70n8+eP23O0K084WCS/gxQ5fnPu/s/KvfMhaiiAyI5dgce8F9Ask7UIlboOkoJmrv+4AwD7R0jbAAH0AskDAABnw5U+PjANiwIAAAAAAVla",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+	},
+	{
+		// has three LZMA2 chunks: First is LZMA, second is uncompressed
+		// with dictionary reset, and third is LZMA with new properties but
+		// without dictionary reset.
+		name:        "good-1-lzma2-4.xz",
+		base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKwADlAL1dADGbyhnFVOy2VOexfcRXnmyJrUptFtg8BZQQFpk4IaO5xYD//O7U1T/djNc9j3bsiKoyq2XUOO/3+Yq/9/ilVtdt1z+FC54/4kdoIggFNbhBcvnbvreOhr9DS44NQy9Bad9hDMToNwhK3sJ2FrhITp65U1AfM4PoKaBnyGY6fyISYvtH5Lz0UQ8ViEnYygsli17o2v04wM5Mcxv/0JvoTLcT+DeZ4tqcL7XquKWN6leCmyXK+/aICpvfQQNuAAAAsgdE6RczS4QAAasDyQMAAPVQLf6xxGf7AgAAAAAEWVo=",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+	},
+	{
+		// has an empty LZMA2 stream with only the end of payload marker.
+		// XZ Utils 5.0.1 and older incorrectly see this file as corrupt.
+		name:        "good-1-lzma2-5.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhARAAAACocI6GAAAAAAAAAAAAAREAO5Zfc5BCmQ0BAAAAAAFZWg==",
+	},
+	{
+		// has three Delta filters and LZMA2.
+		name:        "good-1-3delta-lzma2.xz",
+		base64Input: "/Td6WFoAAATm1rRGBAMDAQADAQEDAQIhAQgAALwVZcYBAchMI7eE4glxT/q6ofdRYwisrvJYQg1m7qgBzWAuiFjXbts9JgAF8fuvNGcXwJ8/+fwNDgOk5q9psWKeR5dDwy9Ho6P1BFrAmz0BzFs6+rPCTJ1PV/27r1P/Bv/1p1FepJxjtLRi90egUG6v4wtSw6c3wFRJAbm0/ztfBK+7KMz/hGRxvjA/1VswqWF/pidTtb8AUz37urNeu/mBSbt0qaFO/bymTPG/VGbvpK1RIOMP7gwCpGM7/6jHVgKv3bFQwWf3S++0WkcGt1+jTarjF2W7qDAGtVJgp/TxFxX5Qa23OhW46p9mx1HRYRntCLz/W3Hxb3pnjgWmVZpx/pyiBF1g+6e28k5RvgfqUMKnSPse+O4R/Qae6bVmdJ4sVL+3VOIRCbZWMAmp0P4sXgyqWZZnBam7OLBGYA+srjfATGWuiFy/vELhe8E1SvW+oxZiNAKrtVsDA5/sf4bRZt88F+wKuEo8FLpflzgKwbxP8BGuNlEKt5pMMfD8p+e4WMT5OrX8p65aFgeo4JZfuGmlnVW2+wdLtJoHbkvoUxad/rG6UvK/751ewlboXfsEoltT/beqW7E2VgvBV4tRuwUKSVT5jRfNuUHdvAQ0AAAAALIHROkXM0uEAAHpA8kDAACS+728scRn+wIAAAAABFla",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+	},
+	{
+		// has one stream with no blocks followed by five-byte stream
+		// padding. stream padding must be a multiple of four bytes, thus
+		// this file is corrupt
+		name:        "bad-0pad-empty.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAAAA==",
+		wantErr:     true,
+	},
+	{
+		// has two zero-block streams concatenated with five-byte stream
+		// padding between the streams.
+		name:        "bad-0catpad-empty.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAAAP03elhaAAABaSLeNgAAAAAc30QhkEKZDQEAAAAAAVla",
+		wantErr:     true,
+	},
+	{
+		// is good-0-empty.xz concatenated with an empty LZMA_Alone file.
+		name:        "bad-0cat-alone.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWQAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg==",
+		wantErr:     true,
+	},
+	{
+		// is good-0cat-empty.xz but with one byte wrong in the Header
+		// Magic Bytes field of the second stream. liblzma gives
+		// LZMA_DATA_ERROR for this. (LZMA_FORMAT_ERROR is used only if the
+		// first stream of a file has invalid Header Magic Bytes.)
+		name:        "bad-0cat-header_magic.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVpdAAABAP//////////AIP/+///wAAAAA==",
+		wantErr:     true,
+	},
+	{
+		// is good-0-empty.xz but with one byte wrong in the Header Magic
+		// Bytes field. liblzma gives LZMA_FORMAT_ERROR for this.
+		name:        "bad-0-header_magic.xz",
+		base64Input: "/Td6WFkAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=",
+		wantErr:     true,
+	},
+	{
+		// is good-0-empty.xz but with one byte wrong in the Footer Magic
+		// Bytes field. liblzma gives LZMA_DATA_ERROR for this.
+		name:        "bad-0-footer_magic.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVg=",
+		wantErr:     true,
+	},
+	{
+		// is good-0-empty.xz without the last byte of the file.
+		name:        "bad-0-empty-truncated.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWQ==",
+		wantErr:     true,
+	},
+	{
+		// has no blocks but Index claims that there is one block.
+		name:        "bad-0-nonempty_index.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAEAACu1hiCQQpkNAQAAAAABWVo=",
+		wantErr:     true,
+	},
+	{
+		// has wrong Backward Size in stream Footer.
+		name:        "bad-0-backward_size.xz",
+		base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCE1kcXGAAAAAAABWVo=",
+		wantErr:     true,
+	},
+	{
+		// has different stream Flags in stream Header and stream Footer.
+		name:        "bad-1- stream_flags-1.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo368qE5CUAQAAAAACWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has wrong CRC32 in stream Header.
+		name:        "bad-1- stream_flags-2.xz",
+		base64Input: "/Td6WFoAAAFpIt52AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
+		wantErr:     true,
+	},
+	{
+		// has wrong CRC32 in stream Footer.
+		name:        "bad-1- stream_flags-3.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpgNAQAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has two-byte variable-length integer in the Uncompressed Size
+		// field in block Header while one-byte would be enough for that
+		// value. It's important that the file gets rejected due to too big
+		// integer encoding instead of due to Uncompressed Size not matching
+		// the value stored in the block Header. That is, the decoder must
+		// not try to decode the Compressed Data field.
+		name:        "bad-1-vli-1.xz",
+		base64Input: "/Td6WFoAAAFpIt42A4CNACEBCAAAAAAAoEipFwEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
+		wantErr:     true,
+	},
+	{
+		// as ten-byte variable-length integer as Uncompressed Size in block
+		// Header. It's important that the file gets rejected due to too big
+		// integer encoding instead of due to Uncompressed Size not matching
+		// the value stored in the block Header. That is, the decoder must
+		// not try to decode the Compressed Data field.
+		name:        "bad-1-vli-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42BICNgICAgICAgIABIQEIANJk8FwBAAxIZWxsbwpXb3JsZCEKAAAAAEOjohUAASkNfVZxGpBCmQ0BAAAAAAFZWg==",
+		wantErr:     true,
+	},
+	{
+		// has block Header that ends in the middle of the Filter Flags field.
+		name:        "bad-1-block_header-1.xz",
+		base64Input: "/Td6WFoAAAFpIt42AQAhAQydYGIBAAVIZWxsbwoCAAZXb3JsZCEKAEOjohUAASQNMCjfr5BCmQ0BAAAAAAFZWg==",
+		wantErr:     true,
+	},
+	{
+		// has block Header that has Compressed Size and Uncompressed Size
+		// but no List of Filter Flags field.
+		name:        "bad-1-block_header-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42AcAEDYCXihIBAAVIZWxsbwoCAAZXb3JsZCEKAEOjohUAASQNMCjfr5BCmQ0BAAAAAAFZWg==",
+		wantErr:     true,
+	},
+	{
+		// has wrong CRC32 in block Header.
+		name:        "bad-1-block_header-3.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMzAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
+		wantErr:     true,
+	},
+	{
+		// has too big Compressed Size in block Header (2^63 - 1 bytes while
+		// maximum is a little less, because the whole block must stay
+		// smaller than 2^63). It's important that the file gets rejected
+		// due to invalid Compressed Size value; the decoder must not try
+		// decoding the Compressed Data field.
+		name:        "bad-1-block_header-4.xz",
+		base64Input: "/Td6WFoAAAFpIt42BED//////////38hAQgAAGPiOnABAAxIZWxsbwpXb3JsZCEKAAAAAEOjohUAASkNfVZxGpBCmQ0BAAAAAAFZWg==",
+		wantErr:     true,
+	},
+	{
+		// has zero as Compressed Size in block Header.
+		name:        "bad-1-block_header-5.xz",
+		base64Input: "/Td6WFoAAAFpIt42A8AADSEBCAAAAAAAqTRVIwEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
+		wantErr:     true,
+	},
+	{
+		// has corrupt block Header which may crash xz -lvv in XZ Utils 5.0.3 and earlier.
+		name:        "bad-1-block_header-6.xz",
+		base64Input: "/Td6WFoAAAFpIt42AMARDSEBCAAAAAAAf9456wEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
+		wantErr:     true,
+	},
+	{
+		// has wrong Unpadded Sizes in Index.
+		name:        "bad-2-index-1.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhsGGgcAAMZoBy4+MA2LAgAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has wrong Uncompressed Sizes in Index.
+		name:        "bad-2-index-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoNGwAAAJL7eC8+MA2LAgAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has non-null byte in Index padding.
+		name:        "bad-2-index-3.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAZDs4Co+MA2LAgAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// wrong CRC32 in Index.
+		name:        "bad-2-index-4.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc51w+MA2LAgAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has zero as Unpadded Size. It is important that the file gets
+		// rejected specifically due to Unpadded Size having an invalid value.
+		name:        "bad-2-index-5.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAjUGAAcAAHu7BSw+MA2LAgAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has Index whose Uncompressed Size fields have huge values whose
+		// sum exceeds the maximum allowed size of 2^63 - 1 bytes. In this
+		// file the sum is exactly 2^64. lzma_index_append() in
+		// liblzma <= 5.2.6 lacks the integer overflow check for the
+		// uncompressed size and thus doesn't catch the error when decoding
+		// the Index field in this file. This makes "xz -l" not detect the
+		// error and will display 0 as the uncompressed size. Note that
+		// regular decompression isn't affected by this bug because it uses
+		// lzma_index_hash_append() instead.
+		name:        "bad-3-index-uncomp-overflow.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQwAAACPmEGcAQAFSGVsbG8KAAAAFjWWMQIAIQEMAAAAj5hBnAEABFdvcmxkAAAAAEc+tvsCACEBDAAAAI+YQZwBAAEhCgAAAALuky0AAxr//////////38Z//////////9/FgIyic40KHKcEAYAAAAAAVla",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has non-null byte in the padding of the Compressed Data field of
+		// the first block.
+		name:        "bad-2-compressed_data_padding.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAABFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
+		want:        "Hello\n",
+		wantErr:     true,
+	},
+	{
+		// has wrong Check (CRC32).
+		name:        "bad-1-check-crc32.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has Compressed Size and Uncompressed Size in block Header but
+		// wrong Check (CRC32) in the actual data. This file differs by one
+		// byte from good-1-block_header-1.xz: the last byte of the Check
+		// field is wrong. This file is useful for testing error detection
+		// in the threaded decoder when a worker thread is configured to
+		// pass base64Input one byte at a time to the block decoder.
+		name:        "bad-1-check-crc32-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42A8ARDSEBCAAAAAAAf9456wEADEhlbGxvCldvcmxkIQoAAAAAQ6Oi/wABJQ1xGcS2kEKZDQEAAAAAAVla",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has wrong Check (CRC64).
+		name:        "bad-1-check-crc64.xz",
+		base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WywABKA08Z2oDH7bzfQEAAAAABFla",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has wrong Check (SHA-256).
+		name:        "bad-1-check-sha256.xz",
+		base64Input: "/Td6WFoAAArh+wyhAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgCOWTXn4TNozZaI/o9IoJVSk2dqAhViWCx+hI2v4T+wRwABQA2Thk6uGJtLmgEAAAAAClla",
+		want:        "Hello\nWorld!\n",
+		wantErr:     true,
+	},
+	{
+		// has LZMA2 stream whose first chunk (uncompressed) doesn't reset
+		// the dictionary.
+		name:        "bad-1-lzma2-1.xz",
+		base64Input: "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAgAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg==",
+		wantErr:     true,
+	},
+	{
+		// has two LZMA2 chunks, of which the second chunk indicates
+		// dictionary reset, but the LZMA compressed data tries to repeat
+		// data from the previous chunk.
+		name:        "bad-1-lzma2-2.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoA4ADlAK0LAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg==",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \n",
+		wantErr:     true,
+	},
+	{
+		// sets new invalid properties (lc=8, lp=0, pb=0) in the middle of
+		// block.
+		name:        "bad-1-lzma2-3.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAwADlAK0IAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg==",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \n",
+		wantErr:     true,
+	},
+	{
+		// has two LZMA2 chunks, of which the first is uncompressed and the
+		// second is LZMA. The first chunk resets dictionary as it should,
+		// but the second chunk tries to reset state without specifying
+		// properties for LZMA.
+		name:        "bad-1-lzma2-4.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIAqgAZMBJAAymwlsVNculWz59zfVHOJGAoJ1+0l2jXNTtv1t28rb2UQLsS6+E7a6qOLz7XVU3EEgzL82WyCZXQ8hoQajli23l5zwe/7iEowtUfDbdnd9pHvTlen7Beb1l49i6dswu7RwPRZ4A3c6i3rVuPhKJyX1jqokFKYpKGsvc+ChcbR7pIBQQMrv27SV/bvBjI5gl9vLfyHtwBBxGn3LzQnQ2f9tgMBnfT/GlM9b3VER0cvUINcrhE6oRbtCeBpoQF8kXok6Nn3bmCjM+YPsMgYxR0c7bBz0YjRAsyi7VDbdeg4cNiU4WAb4FaPOGMj9lh5pKQPDvSfz54/bc7QrTzhYJL+DFDl+c+7+z8q98yFqKIDIjl2Bx7wX0CyTtQiVug6Sgmau/7gDAAD7R0jbAAHzAskDAADf85AjPjANiwIAAAAAAVla",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \n",
+		wantErr:     true,
+	},
+	{
+		// is like bad-1-lzma2-4.xz but doesn't try to reset anything in the
+		// header of the second chunk.
+		name:        "bad-1-lzma2-5.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIAqAAZMBJAAymwlsVNculWz59zfVHOJGAoJ1+0l2jXNTtv1t28rb2UQLsS6+E7a6qOLz7XVU3EEgzL82WyCZXQ8hoQajli23l5zwe/7iEowtUfDbdnd9pHvTlen7Beb1l49i6dswu7RwPRZ4A3c6i3rVuPhKJyX1jqokFKYpKGsvc+ChcbR7pIBQQMrv27SV/bvBjI5gl9vLfyHtwBBxGn3LzQnQ2f9tgMBnfT/GlM9b3VER0cvUINcrhE6oRbtCeBpoQF8kXok6Nn3bmCjM+YPsMgYxR0c7bBz0YjRAsyi7VDbdeg4cNiU4WAb4FaPOGMj9lh5pKQPDvSfz54/bc7QrTzhYJL+DFDl+c+7+z8q98yFqKIDIjl2Bx7wX0CyTtQiVug6Sgmau/7gDAAD7R0jbAAHzAskDAADf85AjPjANiwIAAAAAAVla",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \n",
+		wantErr:     true,
+	},
+	{
+		// has reserved LZMA2 control byte value (0x03).
+		name:        "bad-1-lzma2-6.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAwAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
+		want:        "Hello\n",
+		wantErr:     true,
+	},
+	{
+		// has EOPM at LZMA level.
+		name:        "bad-1-lzma2-7.xz",
+		base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIAqAAZMBJAAymwlsVNculWz59zfVHOJGAoJ1+0l2jXNTtv1t28rb2UQLsS6+E7a6qOLz7XVU3EEgzL82WyCZXQ8hoQajli23l5zwe/7iEowtUfDbdnd9pHvTlen7Beb1l49i6dswu7RwPRZ4A3c6i3rVuPhKJyX1jqokFKYpKGsvc+ChcbR7pIBQQMrv27SV/bvBjI5gl9vLfyHtwBBxGn3LzQnQ2f9tgMBnfT/GlM9b3VER0cvUINcrhE6oRbtCeBpoQF8kXok6Nn3bmCjM+YPsMgYxR0c7bBz0YjRAsyi7VDbdeg4cNiU4WAb4FaPOGMj9lh5pKQPDvSfz54/bc7QrTzhYJL+DFDl+c+7+z8q98yFqKIDIjl2Bx7wX0CyTtQiVug6Sgmau/7gDAAD7R0jbAAHzAskDAADf85AjPjANiwIAAAAAAVla",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \n",
+		wantErr:     true,
+	},
+	{
+		// is like good-1-lzma2-4.xz but doesn't set new properties in the
+		// third LZMA2 chunk.
+		name:        "bad-1-lzma2-8.xz",
+		base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKoADlAL0AMZvKGcVU7LZU57F9xFeebImtSm0W2DwFlBAWmTgho7nFgP/87tTVP92M1z2PduyIqjKrZdQ47/f5ir/3+KVW123XP4ULnj/iR2giCAU1uEFy+du+t46Gv0NLjg1DL0Fp32EMxOg3CErewnYWuEhOnrlTUB8zg+gpoGfIZjp/IhJi+0fkvPRRDxWISdjKCyWLXuja/TjAzkxzG//Qm+hMtxP4N5ni2pwvteq4pY3qV4KbJcr79ogKm99BA24AAAAAsgdE6RczS4QAAaoDyQMAAFCDcTWxxGf7AgAAAAAEWVo=",
+		want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \n",
+		wantErr:     true,
+	},
+	// Flaky test on ubuntu-latest liblzma v5.2.5
+	// {
+	// 	// as LZMA2 stream that is truncated at the end of a LZMA2 chunk (no end marker).
+	// 	// The uncompressed size of the partial LZMA2 stream exceeds the
+	// 	// value stored in the block Header.
+	// 	name:        "bad-1-lzma2-9.xz",
+	// 	base64Input: "/Td6WFoAAAFpIt42A8AUDSEBCAAAAAAAOxUQDQEADEhlbGxvCldvcmxkIQoC//94Q6OiFQABKA08Z2oDkEKZDQEAAAAAAVla",
+	// 	want:        "Hello\nWorld!\n",
+	// 	wantErr:     true,
+	// },
+	// Flaky test on ubuntu-latest liblzma v5.2.5
+	// {
+	// 	// has LZMA2 stream that, from point of view of a LZMA2 decoder,
+	// 	// extends past the end of block (and even the end of the file).
+	// 	// Uncompressed Size in block Header is bigger than the invalid
+	// 	// LZMA2 stream may produce (even if a decoder reads until the end
+	// 	// of the file). The Check type is None to nullify certain simple
+	// 	// size-based sanity checks in a block decoder.
+	// 	name:        "bad-1-lzma2-10.xz",
+	// 	base64Input: "/Td6WFoAAAFpIt42A8AUDSEBCAAAAAAAOxUQDQEADEhlbGxvCldvcmxkIQoC//94Q6OiFQABKA08Z2oDkEKZDQEAAAAAAVla",
+	// 	want:        "Hello\nWorld!\n",
+	// 	wantErr:     true,
+	// },
+	{
+		// has LZMA2 stream that lacks the end of payload marker. When
+		// Compressed Size bytes have been decoded, Uncompressed Size bytes
+		// of output will have been produced but the LZMA2 decoder doesn't
+		// indicate end of stream.
+		name:        "bad-1-lzma2-11.xz",
+		base64Input: "/Td6WFoAAAD/EtlBA8AQDSEBDAAAAAAAV/dqnwEADEhlbGxvIFdvcmxkIQoAASANNO2zywZynnoBAAAAAABZWg==",
+		want:        "Hello World!\n",
+		wantErr:     true,
+	},
+}
+
 func TestReader_Read(t *testing.T) {
+	for _, tt := range readerTests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(tt.base64Input))
+				if tt.srcReader != nil {
+					r = tt.srcReader(r)
+				}
+				var xr io.Reader = NewReader(r)
+				if tt.outReader != nil {
+					xr = tt.outReader(xr)
+				}
+				got, err := io.ReadAll(xr)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Read() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if string(got) != tt.want {
+					t.Errorf("Read() got = '%v', want %v", string(got), tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestNewReaderN(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	tests := []struct {
+		name string
+		n    int64
+		want string
+	}{
+		{name: "preview", n: 5, want: "Lorem"},
+		{name: "zero", n: 0, want: ""},
+		{name: "longer than output", n: int64(len(loremText)) + 10, want: loremText},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				xr := NewReaderN(bytes.NewReader(input), tt.n)
+				closes := 0
+				xr.stream = closeCounter{decoder: xr.stream, closes: &closes}
+				got, err := io.ReadAll(xr)
+				if err != nil {
+					t.Errorf("Read() error = %v", err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("Read() got = '%v', want %v", string(got), tt.want)
+				}
+				if closes != 1 {
+					t.Errorf("decoder closed %d times before Close, want 1", closes)
+				}
+				if err := xr.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+				if closes != 1 {
+					t.Errorf("decoder closed %d times, want 1", closes)
+				}
+			},
+		)
+	}
+}
+
+func TestNewReaderThreads(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 1000)
+	var buf bytes.Buffer
+	w, err := NewWriterThreads(&buf, 1, 4, WithBlockSize(64<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(input); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for _, threads := range []int{0, 1, 2, 1000} {
+		xr := NewReaderThreads(bytes.NewReader(buf.Bytes()), threads)
+		got, err := io.ReadAll(xr)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("Read() got %d bytes, want %d", len(got), len(input))
+		}
+		want := threads
+		if threads == 0 || threads > runtime.NumCPU() {
+			want = runtime.NumCPU()
+		}
+		if xr.Threads() != want {
+			t.Errorf("NewReaderThreads(%d) Threads() = %d, want %d", threads, xr.Threads(), want)
+		}
+	}
+	if got := NewReader(bytes.NewReader(buf.Bytes())).Threads(); got != 1 {
+		t.Errorf("NewReader() Threads() = %d, want 1", got)
+	}
+
+	// bad-1-check-crc32.xz
+	input = decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=")
+	if _, err := io.ReadAll(NewReaderThreads(bytes.NewReader(input), 2)); !errors.Is(err, ErrData) {
+		t.Errorf("Read() error = %v, want %v", err, ErrData)
+	}
+}
+
+func TestWithThreads(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "lorem", input: loremBase64},
+		// bad-1-check-crc32.xz
+		{name: "bad check", input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo="},
+		// bad-1-check-crc32.xz with a corrupt block
+		{name: "bad data", input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgAAAAAWNZYxAAEkDTAo36+QQpkNAQAAAAABWVo="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := decodeBase64(t, tt.input)
+			want, wantErr := io.ReadAll(NewReader(bytes.NewReader(input)))
+			xr := NewReaderThreads(bytes.NewReader(input), 4, WithThreads(1))
+			got, err := io.ReadAll(xr)
+			if !bytes.Equal(got, want) {
+				t.Errorf("Read() got %q, want %q", got, want)
+			}
+			if !reflect.DeepEqual(err, wantErr) {
+				t.Errorf("Read() error = %v, want %v", err, wantErr)
+			}
+			if xr.Threads() != 1 {
+				t.Errorf("Threads() = %d, want 1", xr.Threads())
+			}
+		})
+	}
+}
+
+func TestWithRejectTrailingData(t *testing.T) {
+	const crc32Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo="
 	tests := []struct {
-		name, base64Input, want string
-		wantErr                 bool
-		srcReader, outReader    func(io.Reader) io.Reader
+		name    string
+		input   []byte
+		want    string
+		wantErr error
 	}{
 		{
-			name:        "behaves with DataErrReader",
-			base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
-			want:        "Hello\nWorld!\n",
-			srcReader:   iotest.DataErrReader,
+			name:  "padding",
+			input: append(decodeBase64(t, crc32Input), 0, 0, 0, 0),
+			want:  "Hello\nWorld!\n",
 		},
 		{
-			name:        "behaves with OneByteReader",
-			base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
-			want:        "Hello\nWorld!\n",
-			srcReader:   iotest.OneByteReader,
+			name:    "good-0cat-empty.xz",
+			input:   decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg=="),
+			wantErr: ErrTrailingData,
 		},
 		{
-			name:        "behaves with HalfReader",
-			base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
-			want:        "Hello\nWorld!\n",
-			srcReader:   iotest.HalfReader,
+			name:    "garbage",
+			input:   append(decodeBase64(t, crc32Input), "garbage"...),
+			want:    "Hello\nWorld!\n",
+			wantErr: ErrTrailingData,
 		},
 		{
-			name:        "behaves with ErrReader",
-			base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
-			srcReader: func(r io.Reader) io.Reader {
-				return iotest.ErrReader(errors.New("error"))
-			},
-			wantErr: true,
+			name:    "unaligned padding",
+			input:   append(decodeBase64(t, crc32Input), 0, 0),
+			want:    "Hello\nWorld!\n",
+			wantErr: ErrTrailingData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xr := NewSingleStreamReader(iotest.HalfReader(bytes.NewReader(tt.input)), WithRejectTrailingData())
+			got, err := io.ReadAll(xr)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrData) {
+				t.Errorf("Read() error = %v, want not %v", err, ErrData)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Read() got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewValidatingReaderAt(t *testing.T) {
+	tests := []struct {
+		name        string
+		base64Input string
+		wantErr     bool
+	}{
+		{name: "lorem", base64Input: loremBase64},
+		{name: "bad-0-footer_magic.xz", base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVg=", wantErr: true},
+		{
+			name:        "bad-2-index-3.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAZDs4Co+MA2LAgAAAAABWVo=",
+			wantErr:     true,
 		},
 		{
-			name:        "behaves with output to OneByteReader",
-			base64Input: "/Td6WFoAAATm1rRGAgAhARYAAAB0L+WjAQAMSGVsbG8KV29ybGQhCgAAAADvLogRnT+WygABJQ1xGcS2H7bzfQEAAAAABFla",
-			want:        "Hello\nWorld!\n",
-			outReader:   iotest.OneByteReader,
+			name:        "bad-2-index-4.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc51w+MA2LAgAAAAABWVo=",
+			wantErr:     true,
 		},
-		// The remaining test cases ensures the reader is compliant with up
-		// stream XZ-utils spec. Cases are from:
-		// https://github.com/tukaani-project/xz/tree/fbb3ce541ef79cad1710e88a27a5babb5f6f8e5b/tests/files
 		{
-			// has one stream with no blocks.
-			name:        "good-0-empty.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=",
+			name:        "bad-2-index-5.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAjUGAAcAAHu7BSw+MA2LAgAAAAABWVo=",
+			wantErr:     true,
 		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := decodeBase64(t, tt.base64Input)
+			xr, err := NewValidatingReaderAt(bytes.NewReader(input), int64(len(input)))
+			if tt.wantErr {
+				if !errors.Is(err, ErrData) {
+					t.Errorf("NewValidatingReaderAt() error = %v, want %v", err, ErrData)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewValidatingReaderAt() error = %v", err)
+			}
+			got, err := io.ReadAll(xr)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if string(got) != loremText {
+				t.Errorf("Read() got %q, want %q", got, loremText)
+			}
+		})
+	}
+
+	// The Uncompressed Sizes of the Index are only checked against the
+	// blocks once they are decoded.
+	// bad-2-index-2.xz
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoNGwAAAJL7eC8+MA2LAgAAAAABWVo=")
+	xr, err := NewValidatingReaderAt(bytes.NewReader(input), int64(len(input)))
+	if err != nil {
+		t.Fatalf("NewValidatingReaderAt() error = %v", err)
+	}
+	if _, err := io.ReadAll(xr); !errors.Is(err, ErrIndex) {
+		t.Errorf("Read() error = %v, want %v", err, ErrIndex)
+	}
+}
+
+func TestNewReaderSection(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	prefix, suffix := bytes.Repeat([]byte{0xaa}, 100), bytes.Repeat([]byte{0x55}, 100)
+	file := bytes.Join([][]byte{prefix, input, suffix}, nil)
+	ra := boundedReaderAt{ReaderAt: bytes.NewReader(file), t: t, min: int64(len(prefix)), max: int64(len(prefix) + len(input))}
+	got, err := io.ReadAll(NewReaderSection(ra, int64(len(prefix)), int64(len(input))))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+
+	// A section cutting the stream short fails rather than reading further.
+	_, err = io.ReadAll(NewReaderSection(ra, int64(len(prefix)), int64(len(input)-1)))
+	if err == nil {
+		t.Error("Read() of a truncated section expected error")
+	}
+}
+
+// boundedReaderAt fails the test if read outside of [min, max).
+type boundedReaderAt struct {
+	io.ReaderAt
+	t        *testing.T
+	min, max int64
+}
+
+func (r boundedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.min || off+int64(len(p)) > r.max {
+		r.t.Errorf("ReadAt(%d bytes, %d) outside of [%d, %d)", len(p), off, r.min, r.max)
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestWithMaxBytesPerRead(t *testing.T) {
+	xr := NewReader(bytes.NewReader(decodeBase64(t, loremBase64)), WithMaxBytesPerRead(4))
+	var got []byte
+	p := make([]byte, 100)
+	for {
+		n, err := xr.Read(p)
+		if n > 4 {
+			t.Fatalf("Read() = %d bytes, want at most 4", n)
+		}
+		got = append(got, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+}
+
+func TestWithReadAlignment(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(input)
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, align := range []int{1, 512, 4096, 64 << 10} {
+		src := &sizeRecorder{r: iotest.HalfReader(bytes.NewReader(buf.Bytes()))}
+		got, err := io.ReadAll(NewReader(src, WithReadAlignment(align), WithMinFill(1<<20)))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("Read() got %d bytes, want %d", len(got), len(input))
+		}
+		for _, size := range src.sizes {
+			if size%align != 0 {
+				t.Fatalf("WithReadAlignment(%d) read %d bytes", align, size)
+			}
+		}
+	}
+
+	for _, align := range []int{0, -4, 3, 4097} {
+		xr := NewReader(bytes.NewReader(buf.Bytes()), WithReadAlignment(align))
+		if _, err := xr.Read(make([]byte, 1)); err == nil {
+			t.Errorf("WithReadAlignment(%d) expected error", align)
+		}
+		if err := xr.Close(); err == nil {
+			t.Errorf("WithReadAlignment(%d) Close() expected error", align)
+		}
+	}
+}
+
+// sizeRecorder records the length of every buffer read into.
+type sizeRecorder struct {
+	r     io.Reader
+	sizes []int
+}
+
+func (r *sizeRecorder) Read(p []byte) (int, error) {
+	r.sizes = append(r.sizes, len(p))
+	return r.r.Read(p)
+}
+
+func TestWithMaxCodeCalls(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	xr := NewReader(iotest.OneByteReader(bytes.NewReader(input)), WithMaxCodeCalls(10))
+	got, err := io.ReadAll(xr)
+	if err != ErrBudgetExceeded {
+		t.Errorf("Read() error = %v, want %v", err, ErrBudgetExceeded)
+	}
+	if !strings.HasPrefix(loremText, string(got)) {
+		t.Errorf("Read() got = '%v', want a prefix of %v", string(got), loremText)
+	}
+	if err := xr.Close(); err != ErrBudgetExceeded {
+		t.Errorf("Close() error = %v, want %v", err, ErrBudgetExceeded)
+	}
+
+	got, err = io.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(input)), WithMaxCodeCalls(10_000)))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+}
+
+func TestReader_ResetWithOptions(t *testing.T) {
+	// PresetDefault needs an 8 MiB dictionary.
+	input, err := lzma.EasyBufferEncode(lzma.PresetDefault, lzma.CheckCRC64, []byte(loremText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xr := NewReader(bytes.NewReader(input))
+	got, err := io.ReadAll(xr)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+
+	if err := xr.ResetWithOptions(bytes.NewReader(input), WithMemLimit(1<<20)); err != nil {
+		t.Fatalf("ResetWithOptions() error = %v", err)
+	}
+	got, err = io.ReadAll(xr)
+	var xzErr *Error
+	if !errors.As(err, &xzErr) || xzErr.Code != lzma.MemLimitError {
+		t.Errorf("Read() error = %v, want code %v", err, lzma.MemLimitError)
+	}
+	if len(got) != 0 {
+		t.Errorf("Read() got %d bytes, want 0", len(got))
+	}
+
+	// Reset keeps the options of the last reset.
+	if err := xr.Reset(bytes.NewReader(input)); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if _, err := io.ReadAll(xr); !errors.As(err, &xzErr) || xzErr.Code != lzma.MemLimitError {
+		t.Errorf("Read() error = %v, want code %v", err, lzma.MemLimitError)
+	}
+
+	if err := xr.ResetWithOptions(bytes.NewReader(input)); err != nil {
+		t.Fatalf("ResetWithOptions() error = %v", err)
+	}
+	got, err = io.ReadAll(xr)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+}
+
+func TestWithMemLimit(t *testing.T) {
+	input, err := lzma.EasyBufferEncode(lzma.PresetDefault, lzma.CheckCRC64, []byte(loremText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lzmaInput := decodeBase64(t, "XQAAgAD//////////wAkGUmYbwUVJycNdnjQKmgXFf//dfgAAA==")
+	tests := []struct {
+		name string
+		xr   *Reader
+	}{
+		{name: "NewReader", xr: NewReader(bytes.NewReader(input), WithMemLimit(1<<20))},
+		{name: "NewVerifyingReader", xr: NewVerifyingReader(bytes.NewReader(input), WithMemLimit(1<<20))},
+		{name: "NewReaderThreads", xr: NewReaderThreads(bytes.NewReader(input), 2, WithMemLimit(1<<20))},
+		{name: "NewLZMAReader", xr: NewLZMAReader(bytes.NewReader(lzmaInput), WithMemLimit(1<<10))},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := io.ReadAll(tt.xr)
+				var xzErr *Error
+				if !errors.As(err, &xzErr) || xzErr.Code != lzma.MemLimitError {
+					t.Errorf("Read() error = %v, want code %v", err, lzma.MemLimitError)
+				}
+			},
+		)
+	}
+}
+
+func TestNewSingleStreamReader(t *testing.T) {
+	const (
+		crc32Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo="
+		crc64Input = "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla"
+		// good-0cat-empty.xz
+		emptyCatInput = "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg=="
+	)
+	tests := []struct {
+		name                      string
+		input                     []byte
+		wantSingle, wantConcatted string
+		wantConcattedErr          bool
+	}{
 		{
-			// has one stream with no blocks followed by four-byte stream padding.
-			name:        "good-0pad-empty.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAA",
+			name:          "good-0cat-empty.xz",
+			input:         decodeBase64(t, emptyCatInput),
+			wantSingle:    "",
+			wantConcatted: "",
 		},
 		{
-			// has two zero-block streams concatenated without stream padding.
-			name:        "good-0cat-empty.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg==",
+			name:          "two streams",
+			input:         append(decodeBase64(t, crc32Input), decodeBase64(t, crc64Input)...),
+			wantSingle:    "Hello\nWorld!\n",
+			wantConcatted: "Hello\nWorld!\nHello\nWorld!\n",
 		},
 		{
-			// has two zero-block streams concatenated with four-byte stream
-			// padding between the streams
-			name:        "good-0catpad-empty.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAA/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=",
+			name:             "trailing garbage is not decoded",
+			input:            append(decodeBase64(t, crc32Input), "garbage"...),
+			wantSingle:       "Hello\nWorld!\n",
+			wantConcattedErr: true,
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewSingleStreamReader(bytes.NewReader(tt.input)))
+				if err != nil {
+					t.Errorf("NewSingleStreamReader() error = %v", err)
+				}
+				if string(got) != tt.wantSingle {
+					t.Errorf("NewSingleStreamReader() got = '%v', want %v", string(got), tt.wantSingle)
+				}
+				got, err = io.ReadAll(NewReader(bytes.NewReader(tt.input)))
+				if (err != nil) != tt.wantConcattedErr {
+					t.Errorf("NewReader() error = %v, wantErr %v", err, tt.wantConcattedErr)
+					return
+				}
+				if !tt.wantConcattedErr && string(got) != tt.wantConcatted {
+					t.Errorf("NewReader() got = '%v', want %v", string(got), tt.wantConcatted)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_Read_errors(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	_, err := io.ReadAll(NewReader(iotest.ErrReader(sentinel)))
+	if !errors.Is(err, sentinel) {
+		t.Errorf("Read() error = %v, want %v", err, sentinel)
+	}
+	var xzErr *Error
+	if errors.As(err, &xzErr) {
+		t.Errorf("Read() source error wrapped in %T", xzErr)
+	}
+
+	_, err = io.ReadAll(NewReader(strings.NewReader("this is not xz compressed data")))
+	if !errors.As(err, &xzErr) || xzErr.Code != lzma.FormatError {
+		t.Errorf("Read() error = %v, want Error with code %d", err, lzma.FormatError)
+	}
+}
+
+func TestReader_Read_tells(t *testing.T) {
+	tests := []struct {
+		name, base64Input string
+		want              lzma.Check
+	}{
 		{
-			// has one stream with one block with two uncompressed LZMA2 chunks
-			// and no integrity check
 			name:        "good-1-check-none.xz",
 			base64Input: "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg==",
-			want:        "Hello\nWorld!\n",
+			want:        lzma.CheckNone,
 		},
 		{
-			// has one stream with one block with two uncompressed LZMA2 chunks
-			// and CRC32 check.
 			name:        "good-1-check-crc32.xz",
 			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-		},
-		{
-			// is like good-1-check-crc32.xz but with CRC64.
-			name:        "good-1-check-crc64.xz",
-			base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla",
-			want:        "Hello\nWorld!\n",
+			want:        lzma.CheckCRC32,
 		},
 		{
-			// is like good-1-check-crc32.xz but with SHA256.
 			name:        "good-1-check-sha256.xz",
 			base64Input: "/Td6WFoAAArh+wyhAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgCOWTXn4TNozZaI/o9IoJVSk2dqAhViWCx+hI2v4T+wRgABQA2Thk6uGJtLmgEAAAAAClla",
-			want:        "Hello\nWorld!\n",
-		},
-		{
-			// has one stream with two blocks with one uncompressed LZMA2 chunk
-			// in each block.
-			name:        "good-2-lzma2.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-		},
-		{
-			// has both Compressed Size and Uncompressed Size in the block
-			// Header. This has also four extra bytes of Header padding.
-			name:        "good-1-block_header-1.xz",
-			base64Input: "/Td6WFoAAAFpIt42A8ARDSEBCAAAAAAAf9456wEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
-			want:        "Hello\nWorld!\n",
-		},
-		{
-			// has known Compressed Size.
-			name:        "good-1-block_header-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42AkARIQEIAAA6TIjhAQAMSGVsbG8KV29ybGQhCgAAAABDo6IVAAEhDXXcqNKQQpkNAQAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-		},
-		{
-			// has known Uncompressed Size.
-			name:        "good-1-block_header-3.xz",
-			base64Input: "/Td6WFoAAAFpIt42AoANIQEIAABREYFZAQAMSGVsbG8KV29ybGQhCgAAAABDo6IVAAEhDXXcqNKQQpkNAQAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
+			want:        lzma.CheckSHA256,
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				xr := newReader(
+					bytes.NewReader(decodeBase64(t, tt.base64Input)), nil,
+					lzma.Concatenated, lzma.TellNoCheck, lzma.TellUnsupportedCheck, lzma.TellAnyCheck,
+				)
+				got, err := io.ReadAll(xr)
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if string(got) != "Hello\nWorld!\n" {
+					t.Errorf("Read() got = '%v', want %v", string(got), "Hello\nWorld!\n")
+				}
+				if xr.check != tt.want {
+					t.Errorf("Read() check = %v, want %v", xr.check, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_Warnings(t *testing.T) {
+	// good-1-check-crc32.xz with its check changed to the reserved ID 2, which
+	// also has a 4 byte check field, and the CRC32 of the stream header and
+	// footer updated to match.
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=")
+	footer := input[len(input)-12:]
+	input[7], footer[9] = 2, 2
+	binary.LittleEndian.PutUint32(input[8:], lzma.CRC32(input[6:8], 0))
+	binary.LittleEndian.PutUint32(footer, lzma.CRC32(footer[4:10], 0))
+
+	xr := NewReader(bytes.NewReader(input))
+	got, err := io.ReadAll(xr)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != "Hello\nWorld!\n" {
+		t.Errorf("Read() got = '%v', want %v", string(got), "Hello\nWorld!\n")
+	}
+	if warnings := xr.Warnings(); len(warnings) != 1 {
+		t.Errorf("Warnings() = %q, want one warning", warnings)
+	}
+
+	xr = NewReader(bytes.NewReader(decodeBase64(t, loremBase64)))
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if warnings := xr.Warnings(); len(warnings) != 0 {
+		t.Errorf("Warnings() = %q, want none", warnings)
+	}
+}
+
+func TestReader_Read_indexErrors(t *testing.T) {
+	tests := []struct {
+		name, base64Input string
+		wantIndex         bool
+		wantFlags         bool
+	}{
 		{
-			// has two LZMA2 chunks, of which the second sets new properties.
-			name:        "good-1-lzma2-1.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAwADlAK0LAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg==",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+			name:        "bad-0-nonempty_index.xz",
+			base64Input: "/Td6WFoAAAFpIt42AAEAACu1hiCQQpkNAQAAAAABWVo=",
+			wantIndex:   true,
 		},
 		{
-			// has two LZMA2 chunks, of which the second resets the state
-			// without specifying new properties.
-			name:        "good-1-lzma2-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAoADlAK8AjPGdQH2CTyRyFPGdhMtaMmyXakCDi/CvMcK0ZW+J/fvYi1RBghZUEtFN1YbFwFr6SWOREf7/9Y8UAoVheThKS09BY/iHLyzm4ukxj4sU06F+gehVAu8hMaJ7BcwfpGDngaqn2XiC5hiyqxyqGS/ChxTF2cs/0BimzSpLXajHXwFnKEws5MzVUp6TAn4QXfUDsZgvJu2Ge1Z/E3lYj0QQ2dkPluk7v7W42ivh1oHxyQAA+0dI2wABgwPJAwAArtfSFT4wDYsCAAAAAAFZWg==",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+			name:        "bad-2-index-1.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhsGGgcAAMZoBy4+MA2LAgAAAAABWVo=",
+			wantIndex:   true,
 		},
 		{
-			// has two LZMA2 chunks, of which the first is uncompressed and the
-			// second is LZMA. The first chunk resets dictionary and the second
-			// sets new properties.
-			name:        "good-1-lzma2-3.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIArAAZMBJF0AMpsJbFTXLpVs+fc31RziRgKCdftJdo1zU7b9bdvK29lEC7EuvhO2uqji8+11VNxBIMy/NlsgmV0PIaEGo5Ytt5ec8Hv+4hKMLVHw23Z3faR705Xp+wXm9ZePYunbMLu0cD0WeAN3Oot61bj4Sicl9Y6qJBSmKShrL3PgoXG0e6SAUEDK79u0lf27wYyOYJfby38h7cAQcRp9y80J0Nn/bYDAZ30/xpTPW91REdHL1CDXK4ROqEW7QngaaEBfJF6JOjZ925gozPmD7DIGMUdHO2wc9GI0QLMou1Q23XoOHDYlOFgG+BWjzhjI/ZYeaSkDw70n8+eP23O0K084WCS/gxQ5fnPu/s/KvfMhaiiAyI5dgce8F9Ask7UIlboOkoJmrv+4AwD7R0jbAAH0AskDAABnw5U+PjANiwIAAAAAAVla",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+			name:        "bad-2-index-2.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoNGwAAAJL7eC8+MA2LAgAAAAABWVo=",
+			wantIndex:   true,
 		},
 		{
-			// has three LZMA2 chunks: First is LZMA, second is uncompressed
-			// with dictionary reset, and third is LZMA with new properties but
-			// without dictionary reset.
-			name:        "good-1-lzma2-4.xz",
-			base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKwADlAL1dADGbyhnFVOy2VOexfcRXnmyJrUptFtg8BZQQFpk4IaO5xYD//O7U1T/djNc9j3bsiKoyq2XUOO/3+Yq/9/ilVtdt1z+FC54/4kdoIggFNbhBcvnbvreOhr9DS44NQy9Bad9hDMToNwhK3sJ2FrhITp65U1AfM4PoKaBnyGY6fyISYvtH5Lz0UQ8ViEnYygsli17o2v04wM5Mcxv/0JvoTLcT+DeZ4tqcL7XquKWN6leCmyXK+/aICpvfQQNuAAAAsgdE6RczS4QAAasDyQMAAPVQLf6xxGf7AgAAAAAEWVo=",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+			name:        "bad-2-index-3.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAZDs4Co+MA2LAgAAAAABWVo=",
+			wantIndex:   true,
 		},
 		{
-			// has an empty LZMA2 stream with only the end of payload marker.
-			// XZ Utils 5.0.1 and older incorrectly see this file as corrupt.
-			name:        "good-1-lzma2-5.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhARAAAACocI6GAAAAAAAAAAAAAREAO5Zfc5BCmQ0BAAAAAAFZWg==",
+			name:        "bad-2-index-4.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc51w+MA2LAgAAAAABWVo=",
+			wantIndex:   true,
 		},
 		{
-			// has three Delta filters and LZMA2.
-			name:        "good-1-3delta-lzma2.xz",
-			base64Input: "/Td6WFoAAATm1rRGBAMDAQADAQEDAQIhAQgAALwVZcYBAchMI7eE4glxT/q6ofdRYwisrvJYQg1m7qgBzWAuiFjXbts9JgAF8fuvNGcXwJ8/+fwNDgOk5q9psWKeR5dDwy9Ho6P1BFrAmz0BzFs6+rPCTJ1PV/27r1P/Bv/1p1FepJxjtLRi90egUG6v4wtSw6c3wFRJAbm0/ztfBK+7KMz/hGRxvjA/1VswqWF/pidTtb8AUz37urNeu/mBSbt0qaFO/bymTPG/VGbvpK1RIOMP7gwCpGM7/6jHVgKv3bFQwWf3S++0WkcGt1+jTarjF2W7qDAGtVJgp/TxFxX5Qa23OhW46p9mx1HRYRntCLz/W3Hxb3pnjgWmVZpx/pyiBF1g+6e28k5RvgfqUMKnSPse+O4R/Qae6bVmdJ4sVL+3VOIRCbZWMAmp0P4sXgyqWZZnBam7OLBGYA+srjfATGWuiFy/vELhe8E1SvW+oxZiNAKrtVsDA5/sf4bRZt88F+wKuEo8FLpflzgKwbxP8BGuNlEKt5pMMfD8p+e4WMT5OrX8p65aFgeo4JZfuGmlnVW2+wdLtJoHbkvoUxad/rG6UvK/751ewlboXfsEoltT/beqW7E2VgvBV4tRuwUKSVT5jRfNuUHdvAQ0AAAAALIHROkXM0uEAAHpA8kDAACS+728scRn+wIAAAAABFla",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \nconsequat. Duis aute irure dolor in reprehenderit \nin voluptate velit esse cillum dolore eu \nfugiat nulla pariatur. Excepteur sint occaecat cupidatat \nnon proident, sunt in culpa qui officia \ndeserunt mollit anim id est laborum. \n",
+			name:        "bad-2-index-5.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAjUGAAcAAHu7BSw+MA2LAgAAAAABWVo=",
+			wantIndex:   true,
 		},
 		{
-			// has one stream with no blocks followed by five-byte stream
-			// padding. stream padding must be a multiple of four bytes, thus
-			// this file is corrupt
-			name:        "bad-0pad-empty.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAAAA==",
-			wantErr:     true,
+			name:        "bad-3-index-uncomp-overflow.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQwAAACPmEGcAQAFSGVsbG8KAAAAFjWWMQIAIQEMAAAAj5hBnAEABFdvcmxkAAAAAEc+tvsCACEBDAAAAI+YQZwBAAEhCgAAAALuky0AAxr//////////38Z//////////9/FgIyic40KHKcEAYAAAAAAVla",
+			wantIndex:   true,
 		},
 		{
-			// has two zero-block streams concatenated with five-byte stream
-			// padding between the streams.
-			name:        "bad-0catpad-empty.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAAAP03elhaAAABaSLeNgAAAAAc30QhkEKZDQEAAAAAAVla",
-			wantErr:     true,
+			name:        "bad-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=",
 		},
 		{
-			// is good-0-empty.xz concatenated with an empty LZMA_Alone file.
-			name:        "bad-0cat-alone.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWQAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg==",
-			wantErr:     true,
+			name:        "bad-1-lzma2-1.xz",
+			base64Input: "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAgAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg==",
 		},
 		{
-			// is good-0cat-empty.xz but with one byte wrong in the Header
-			// Magic Bytes field of the second stream. liblzma gives
-			// LZMA_DATA_ERROR for this. (LZMA_FORMAT_ERROR is used only if the
-			// first stream of a file has invalid Header Magic Bytes.)
-			name:        "bad-0cat-header_magic.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVpdAAABAP//////////AIP/+///wAAAAA==",
-			wantErr:     true,
+			name:        "bad-2-compressed_data_padding.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAABFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
 		},
 		{
-			// is good-0-empty.xz but with one byte wrong in the Header Magic
-			// Bytes field. liblzma gives LZMA_FORMAT_ERROR for this.
-			name:        "bad-0-header_magic.xz",
-			base64Input: "/Td6WFkAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=",
-			wantErr:     true,
+			name:        "bad-1-stream_flags-1.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo368qE5CUAQAAAAACWVo=",
+			wantFlags:   true,
 		},
 		{
-			// is good-0-empty.xz but with one byte wrong in the Footer Magic
-			// Bytes field. liblzma gives LZMA_DATA_ERROR for this.
-			name:        "bad-0-footer_magic.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVg=",
-			wantErr:     true,
-		},
-		{
-			// is good-0-empty.xz without the last byte of the file.
-			name:        "bad-0-empty-truncated.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWQ==",
-			wantErr:     true,
+			name:        "bad-1-stream_flags-3.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpgNAQAAAAABWVo=",
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := io.ReadAll(NewReader(bytes.NewReader(decodeBase64(t, tt.base64Input))))
+				if !errors.Is(err, ErrData) {
+					t.Errorf("Read() error = %v, want %v", err, ErrData)
+				}
+				if errors.Is(err, ErrIndex) != tt.wantIndex {
+					t.Errorf("Read() error = %v, want errors.Is(err, ErrIndex) = %v", err, tt.wantIndex)
+				}
+				if errors.Is(err, ErrStreamFlagsMismatch) != tt.wantFlags {
+					t.Errorf("Read() error = %v, want errors.Is(err, ErrStreamFlagsMismatch) = %v", err, tt.wantFlags)
+				}
+			},
+		)
+	}
+}
+
+func TestWithEagerAlloc(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, lzma.PresetDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, loremText); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The dictionary is reallocated if the first block needs a different size.
+	for _, dictSize := range []uint32{8 << 20, 1 << 20} {
+		got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes()), WithEagerAlloc(dictSize)))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if string(got) != loremText {
+			t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+		}
+	}
+}
+
+func BenchmarkReader_firstRead(b *testing.B) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, lzma.PresetDefault)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := io.WriteString(w, loremText); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	benchmarks := []struct {
+		name string
+		opts []ReaderOption
+	}{
+		{name: "lazy"},
+		{name: "WithEagerAlloc", opts: []ReaderOption{WithEagerAlloc(8 << 20)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(
+			bm.name, func(b *testing.B) {
+				p := make([]byte, len(loremText))
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					xr := NewReader(bytes.NewReader(buf.Bytes()), bm.opts...)
+					b.StartTimer()
+					if _, err := xr.Read(p); err != nil {
+						b.Fatal(err)
+					}
+					b.StopTimer()
+					_ = xr.Close()
+					b.StartTimer()
+				}
+			},
+		)
+	}
+}
+
+func TestError_BytesProduced(t *testing.T) {
+	const base64Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAABFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo="
+	// Read a byte at a time so the error is returned separately from the output.
+	got, err := io.ReadAll(iotest.OneByteReader(NewReader(bytes.NewReader(decodeBase64(t, base64Input)))))
+	var xzErr *Error
+	if !errors.As(err, &xzErr) {
+		t.Fatalf("Read() error = %v, want %T", err, xzErr)
+	}
+	if xzErr.BytesProduced != 6 || string(got) != "Hello\n" {
+		t.Errorf("BytesProduced = %d with output '%v', want 6", xzErr.BytesProduced, string(got))
+	}
+}
+
+func TestReader_Read_inMemory(t *testing.T) {
+	// good-0cat-empty.xz followed by a trailing stream which must be left
+	// unread by a single stream reader decoding in place.
+	first := decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=")
+	input := append(append([]byte{}, first...), decodeBase64(t, loremBase64)...)
+	tests := []struct {
+		name   string
+		src    func() io.Reader
+		unread func(io.Reader) int
+	}{
 		{
-			// has no blocks but Index claims that there is one block.
-			name:        "bad-0-nonempty_index.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAEAACu1hiCQQpkNAQAAAAABWVo=",
-			wantErr:     true,
+			name:   "bytes.Buffer",
+			src:    func() io.Reader { return bytes.NewBuffer(append([]byte{}, input...)) },
+			unread: func(r io.Reader) int { return r.(*bytes.Buffer).Len() },
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewReader(tt.src()))
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if string(got) != loremText {
+					t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+				}
+				src := tt.src()
+				if _, err := io.ReadAll(NewSingleStreamReader(src)); err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if got, want := tt.unread(src), len(input)-len(first); got != want {
+					t.Errorf("NewSingleStreamReader() left %d bytes unread, want %d", got, want)
+				}
+			},
+		)
+	}
+}
+
+func BenchmarkReader_inMemory(b *testing.B) {
+	text := bytes.Repeat([]byte(loremText), 10000)
+	input, err := lzma.EasyBufferEncode(1, lzma.CheckCRC64, text)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarks := []struct {
+		name      string
+		newReader func() *Reader
+	}{
+		{name: "NewBytesReader", newReader: func() *Reader { return NewBytesReader(input) }},
+		{name: "bytes.Buffer", newReader: func() *Reader { return NewReader(bytes.NewBuffer(input)) }},
+		// Hiding the type of the source decodes through the reader's buffer.
 		{
-			// has wrong Backward Size in stream Footer.
-			name:        "bad-0-backward_size.xz",
-			base64Input: "/Td6WFoAAAFpIt42AAAAABzfRCE1kcXGAAAAAAABWVo=",
-			wantErr:     true,
-		},
-		{
-			// has different stream Flags in stream Header and stream Footer.
-			name:        "bad-1- stream_flags-1.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo368qE5CUAQAAAAACWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:      "io.Reader",
+			newReader: func() *Reader { return NewReader(struct{ io.Reader }{bytes.NewReader(input)}) },
 		},
+	}
+	for _, bm := range benchmarks {
+		b.Run(
+			bm.name, func(b *testing.B) {
+				b.SetBytes(int64(len(text)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := io.Copy(io.Discard, bm.newReader()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestWithBlockCallback(t *testing.T) {
+	const base64Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo="
+	var got []BlockInfo
+	xr := NewReader(
+		bytes.NewReader(decodeBase64(t, base64Input)),
+		WithBlockCallback(func(info BlockInfo) { got = append(got, info) }),
+	)
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	want := []BlockInfo{
 		{
-			// has wrong CRC32 in stream Header.
-			name:        "bad-1- stream_flags-2.xz",
-			base64Input: "/Td6WFoAAAFpIt52AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
-			wantErr:     true,
+			Offset: 12, UncompressedOffset: 0, HeaderSize: 12,
+			CompressedSize: 10, UncompressedSize: 6, TotalSize: 28, Check: lzma.CheckCRC32,
 		},
 		{
-			// has wrong CRC32 in stream Footer.
-			name:        "bad-1- stream_flags-3.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpgNAQAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			Offset: 40, UncompressedOffset: 6, HeaderSize: 12,
+			CompressedSize: 11, UncompressedSize: 7, TotalSize: 28, Check: lzma.CheckCRC32,
 		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("WithBlockCallback() got %d blocks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("WithBlockCallback() block %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	tests := []struct {
+		name        string
+		base64Input string
+		want        []string
+	}{
 		{
-			// has two-byte variable-length integer in the Uncompressed Size
-			// field in block Header while one-byte would be enough for that
-			// value. It's important that the file gets rejected due to too big
-			// integer encoding instead of due to Uncompressed Size not matching
-			// the value stored in the block Header. That is, the decoder must
-			// not try to decode the Compressed Data field.
-			name:        "bad-1-vli-1.xz",
-			base64Input: "/Td6WFoAAAFpIt42A4CNACEBCAAAAAAAoEipFwEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
-			wantErr:     true,
+			name:        "good-2-lzma2.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
+			want: []string{
+				"stream start [offset 0 check 1]",
+				"block decoded [offset 12 size 28 uncompressed_size 6]",
+				"check verified [offset 12 check 1]",
+				"block decoded [offset 40 size 28 uncompressed_size 7]",
+				"check verified [offset 40 check 1]",
+				"stream end [offset 0 size 92 uncompressed_size 13 blocks 2]",
+			},
 		},
 		{
-			// as ten-byte variable-length integer as Uncompressed Size in block
-			// Header. It's important that the file gets rejected due to too big
-			// integer encoding instead of due to Uncompressed Size not matching
-			// the value stored in the block Header. That is, the decoder must
-			// not try to decode the Compressed Data field.
-			name:        "bad-1-vli-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42BICNgICAgICAgIABIQEIANJk8FwBAAxIZWxsbwpXb3JsZCEKAAAAAEOjohUAASkNfVZxGpBCmQ0BAAAAAAFZWg==",
-			wantErr:     true,
+			name:        "bad-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=",
+			want: []string{
+				"stream start [offset 0 check 1]",
+				"error [err lzma return error code=data error]",
+			},
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var got []string
+				logger := func(event string, kv ...any) {
+					got = append(got, fmt.Sprint(event, " ", kv))
+				}
+				xr := NewReader(bytes.NewReader(decodeBase64(t, tt.base64Input)), WithLogger(logger))
+				_, _ = io.ReadAll(xr)
+				_, _ = xr.Read(make([]byte, 1))
+				if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+					t.Errorf("WithLogger() events = %q, want %q", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_CurrentBlock(t *testing.T) {
+	// good-2-lzma2.xz
+	const base64Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo="
+	xr := NewReader(bytes.NewReader(decodeBase64(t, base64Input)))
+	if got := xr.CurrentBlock(); got != 0 {
+		t.Errorf("CurrentBlock() before Read = %d, want 0", got)
+	}
+	var blocks []int
+	p := make([]byte, 1)
+	for {
+		n, err := xr.Read(p)
+		if n > 0 {
+			blocks = append(blocks, xr.CurrentBlock())
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	// "Hello\n" is the first block and "World!\n" the second, which is started
+	// by the Read returning the end of the first.
+	want := []int{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1}
+	if fmt.Sprint(blocks) != fmt.Sprint(want) {
+		t.Errorf("CurrentBlock() = %v, want %v", blocks, want)
+	}
+}
+
+func TestReader_Read_truncated(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	// The source ends in the middle of the block.
+	src := io.MultiReader(iotest.HalfReader(bytes.NewReader(input[:len(input)/2])), iotest.ErrReader(io.EOF))
+	got, err := io.ReadAll(NewReader(src))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Read() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	var xzErr *Error
+	if !errors.As(err, &xzErr) || xzErr.Code != lzma.BufError || xzErr.BytesProduced != int64(len(got)) {
+		t.Errorf("Read() error = %#v, want code %v with %d bytes produced", err, lzma.BufError, len(got))
+	}
+	if !strings.HasPrefix(loremText, string(got)) {
+		t.Errorf("Read() got = '%v', want a prefix of %v", string(got), loremText)
+	}
+
+	// A decoder stalling before the source ends is not reported as truncation.
+	xr := NewReader(bytes.NewReader(input))
+	xr.stream = stallingDecoder{xr.stream}
+	_, err = io.ReadAll(xr)
+	if !errors.Is(err, ErrNoProgress) {
+		t.Errorf("Read() error = %v, want %v", err, ErrNoProgress)
+	}
+}
+
+// stallingDecoder makes no progress.
+type stallingDecoder struct {
+	decoder
+}
+
+func (stallingDecoder) Code(lzma.Action) lzma.Return {
+	return lzma.BufError
+}
+
+func TestReader_Close(t *testing.T) {
+	xr := NewReader(bytes.NewReader(decodeBase64(t, loremBase64)))
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if err := xr.Close(); err != nil {
+		t.Errorf("Close() after io.EOF error = %v, want nil", err)
+	}
+
+	// bad-1-check-crc32.xz
+	const base64Input = "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo="
+	xr = NewReader(bytes.NewReader(decodeBase64(t, base64Input)))
+	if _, err := io.ReadAll(xr); !errors.Is(err, ErrData) {
+		t.Fatalf("Read() error = %v, want %v", err, ErrData)
+	}
+	if err := xr.Close(); !errors.Is(err, ErrData) {
+		t.Errorf("Close() error = %v, want %v", err, ErrData)
+	}
+	if err := xr.Close(); err != nil {
+		t.Errorf("Close() second call error = %v, want nil", err)
+	}
+	if _, err := xr.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() after Close expected error")
+	}
+}
+
+func TestNewBytesReader(t *testing.T) {
+	// good-0cat-empty.xz followed by good-1-lzma2-1.xz and stream padding.
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg==")
+	input = append(input, decodeBase64(t, loremBase64)...)
+	input = append(input, 0, 0, 0, 0)
+	xr := NewBytesReader(input)
+	got, err := io.ReadAll(xr)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+	if err := xr.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	if _, err := io.ReadAll(NewBytesReader(input[:len(input)-10])); err == nil {
+		t.Error("Read() expected error for truncated input")
+	}
+}
+
+func TestReader_Read_partialOutput(t *testing.T) {
+	// bad-2-compressed_data_padding.xz
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAABFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=")
+	lorem := decodeBase64(t, loremBase64)
+	errSource := errors.New("source failed")
+	tests := []struct {
+		name    string
+		src     io.Reader
+		want    string
+		wantErr error
+	}{
 		{
-			// has block Header that ends in the middle of the Filter Flags field.
-			name:        "bad-1-block_header-1.xz",
-			base64Input: "/Td6WFoAAAFpIt42AQAhAQydYGIBAAVIZWxsbwoCAAZXb3JsZCEKAEOjohUAASQNMCjfr5BCmQ0BAAAAAAFZWg==",
-			wantErr:     true,
+			name:    "decode error",
+			src:     iotest.OneByteReader(bytes.NewReader(input)),
+			want:    "Hello\n",
+			wantErr: ErrData,
 		},
 		{
-			// has block Header that has Compressed Size and Uncompressed Size
-			// but no List of Filter Flags field.
-			name:        "bad-1-block_header-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42AcAEDYCXihIBAAVIZWxsbwoCAAZXb3JsZCEKAEOjohUAASQNMCjfr5BCmQ0BAAAAAAFZWg==",
-			wantErr:     true,
+			name:    "source error",
+			src:     io.MultiReader(bytes.NewReader(lorem[:len(lorem)/2]), iotest.ErrReader(errSource)),
+			wantErr: errSource,
 		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xr := NewReader(tt.src)
+			p := make([]byte, len(loremText))
+			n, err := xr.Read(p)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if n == 0 || int64(n) != int64(xr.TotalOut()) {
+				t.Errorf("Read() = %d, want the %d bytes decoded", n, xr.TotalOut())
+			}
+			if tt.want != "" && string(p[:n]) != tt.want {
+				t.Errorf("Read() got %q, want %q", p[:n], tt.want)
+			}
+			if tt.want == "" && !strings.HasPrefix(loremText, string(p[:n])) {
+				t.Errorf("Read() got %q, want a prefix of the text", p[:n])
+			}
+		})
+	}
+}
+
+func TestReader_Read_bufferInStruct(t *testing.T) {
+	// The buffer shares its object with Go pointers, as with the block buffer
+	// of a tar.Reader, which cgo must not find passed to liblzma.
+	var holder struct {
+		buf  [512]byte
+		next *int
+	}
+	holder.next = new(int)
+	xr := NewReader(bytes.NewReader(decodeBase64(t, loremBase64)))
+	var got []byte
+	for {
+		n, err := xr.Read(holder.buf[:])
+		got = append(got, holder.buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got %q, want %q", got, loremText)
+	}
+}
+
+// eofOnceReader fails the test if Read is called after returning io.EOF.
+type eofOnceReader struct {
+	t   *testing.T
+	r   io.Reader
+	eof bool
+}
+
+func (r *eofOnceReader) Read(p []byte) (int, error) {
+	if r.eof {
+		r.t.Fatal("Read() called after io.EOF")
+	}
+	n, err := r.r.Read(p)
+	r.eof = err == io.EOF
+	return n, err
+}
+
+// finishDecoder copies its input to its output like a decoder that holds
+// output after consuming all of its input, such as the multithreaded decoder,
+// only writing it once coded with lzma.Finish.
+type finishDecoder struct {
+	in, out, held []byte
+	total         uint64
+}
+
+func (d *finishDecoder) SetNextIn(in []byte)   { d.in = in }
+func (d *finishDecoder) AvailableIn() int      { return len(d.in) }
+func (d *finishDecoder) SetNextOut(out []byte) { d.out = out }
+func (d *finishDecoder) AvailableOut() int     { return len(d.out) }
+func (d *finishDecoder) TotalIn() uint64       { return d.total }
+func (d *finishDecoder) TotalOut() uint64      { return d.total - uint64(len(d.held)) }
+func (d *finishDecoder) Check() lzma.Check     { return lzma.CheckNone }
+func (d *finishDecoder) Close() error          { return nil }
+
+func (d *finishDecoder) Code(action lzma.Action) lzma.Return {
+	d.held = append(d.held, d.in...)
+	d.total += uint64(len(d.in))
+	d.in = nil
+	if action != lzma.Finish {
+		return lzma.Ok
+	}
+	n := copy(d.out, d.held)
+	d.out, d.held = d.out[n:], d.held[n:]
+	if len(d.held) == 0 {
+		return lzma.StreamEnd
+	}
+	return lzma.Ok
+}
+
+func TestReader_Read_noReadAfterEOF(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	newReader := func(src io.Reader) *Reader { return NewReader(src) }
+	newFinishReader := func(src io.Reader) *Reader {
+		return newDecoderReader(src, func(uint64) (decoder, error) { return &finishDecoder{}, nil }, nil)
+	}
+	tests := []struct {
+		name      string
+		input     []byte
+		want      string
+		newReader func(io.Reader) *Reader
+	}{
+		{name: "NewReader", input: input, want: loremText, newReader: newReader},
+		{name: "held output", input: []byte(loremText), want: loremText, newReader: newFinishReader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading a byte at a time makes many calls to Read after the
+			// end of the input.
+			xr := tt.newReader(&eofOnceReader{t: t, r: struct{ io.Reader }{bytes.NewReader(tt.input)}})
+			got, err := io.ReadAll(iotest.OneByteReader(xr))
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Read() got %q, want %q", got, tt.want)
+			}
+			if n, err := xr.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Errorf("Read() = %d, %v, want 0, %v", n, err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestReader_Read_emptyInput(t *testing.T) {
+	readers := []struct {
+		name string
+		xr   *Reader
+	}{
+		{name: "NewReader", xr: NewReader(bytes.NewReader(nil))},
+		{name: "NewReader io.Reader", xr: NewReader(iotest.HalfReader(bytes.NewReader(nil)))},
+		{name: "NewSingleStreamReader", xr: NewSingleStreamReader(bytes.NewReader(nil))},
+		{name: "NewBytesReader", xr: NewBytesReader(nil)},
+		{name: "NewReaderThreads", xr: NewReaderThreads(bytes.NewReader(nil), 2)},
+		{name: "NewLZMAReader", xr: NewLZMAReader(bytes.NewReader(nil))},
+	}
+	for _, tt := range readers {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if n, err := tt.xr.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+					t.Errorf("Read() = %d, %v, want 0, %v", n, err, io.EOF)
+				}
+				if err := tt.xr.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+			},
+		)
+	}
+}
+
+func TestWithCompleteInput(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	sources := []struct {
+		name string
+		src  func([]byte) io.Reader
+	}{
+		{name: "bytes.Reader", src: func(b []byte) io.Reader { return bytes.NewReader(b) }},
+		{name: "io.Reader", src: func(b []byte) io.Reader { return iotest.HalfReader(bytes.NewReader(b)) }},
+	}
+	for _, src := range sources {
+		t.Run(
+			src.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewReader(src.src(input), WithCompleteInput()))
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if string(got) != loremText {
+					t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+				}
+				// An empty source has nothing to decode.
+				if got, err := io.ReadAll(NewReader(src.src(nil), WithCompleteInput())); len(got) != 0 || err != nil {
+					t.Errorf("Read() of empty input = %q, %v, want no output", got, err)
+				}
+				for _, n := range []int{1, 20, len(input) / 2, len(input) - 1} {
+					_, err := io.ReadAll(NewReader(src.src(input[:n]), WithCompleteInput()))
+					if !errors.Is(err, io.ErrUnexpectedEOF) {
+						t.Errorf("Read() truncated to %d bytes error = %v, want %v", n, err, io.ErrUnexpectedEOF)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestWithAllowTrailingGarbage(t *testing.T) {
+	lorem := decodeBase64(t, loremBase64)
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
 		{
-			// has wrong CRC32 in block Header.
-			name:        "bad-1-block_header-3.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMzAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
-			wantErr:     true,
+			name:  "bad-0pad-empty.xz",
+			input: decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAAAA=="),
 		},
 		{
-			// has too big Compressed Size in block Header (2^63 - 1 bytes while
-			// maximum is a little less, because the whole block must stay
-			// smaller than 2^63). It's important that the file gets rejected
-			// due to invalid Compressed Size value; the decoder must not try
-			// decoding the Compressed Data field.
-			name:        "bad-1-block_header-4.xz",
-			base64Input: "/Td6WFoAAAFpIt42BED//////////38hAQgAAGPiOnABAAxIZWxsbwpXb3JsZCEKAAAAAEOjohUAASkNfVZxGpBCmQ0BAAAAAAFZWg==",
-			wantErr:     true,
+			name:  "newline",
+			input: append(append([]byte{}, lorem...), '\n'),
+			want:  loremText,
 		},
 		{
-			// has zero as Compressed Size in block Header.
-			name:        "bad-1-block_header-5.xz",
-			base64Input: "/Td6WFoAAAFpIt42A8AADSEBCAAAAAAAqTRVIwEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
-			wantErr:     true,
+			name:  "concatenated then garbage",
+			input: append(append(append([]byte{}, lorem...), lorem...), "garbage after the streams"...),
+			want:  loremText + loremText,
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if _, err := io.ReadAll(NewReader(bytes.NewReader(tt.input))); err == nil {
+					t.Error("NewReader() expected error by default")
+				}
+				got, err := io.ReadAll(NewReader(bytes.NewReader(tt.input), WithAllowTrailingGarbage()))
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("Read() got = '%v', want %v", string(got), tt.want)
+				}
+			},
+		)
+	}
+
+	// Garbage in place of the first stream is not tolerated.
+	_, err := io.ReadAll(NewReader(strings.NewReader("this is not xz compressed data"), WithAllowTrailingGarbage()))
+	if err == nil {
+		t.Error("Read() expected error for garbage input")
+	}
+}
+
+func TestWithPaddingMode(t *testing.T) {
+	const (
+		// good-0pad-empty.xz has 4 bytes of Stream Padding, bad-0pad-empty.xz 5.
+		goodPad = "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAA"
+		badPad  = "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVoAAAAAAA=="
+	)
+	tests := []struct {
+		name        string
+		base64Input string
+		mode        PaddingMode
+		wantErr     bool
+	}{
+		{name: "good-0pad-empty.xz strict", base64Input: goodPad, mode: PaddingStrict},
+		{name: "good-0pad-empty.xz lenient", base64Input: goodPad, mode: PaddingLenient},
+		{name: "bad-0pad-empty.xz strict", base64Input: badPad, mode: PaddingStrict, wantErr: true},
+		{name: "bad-0pad-empty.xz lenient", base64Input: badPad, mode: PaddingLenient},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewReader(bytes.NewReader(decodeBase64(t, tt.base64Input)), WithPaddingMode(tt.mode)))
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Read() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if len(got) != 0 {
+					t.Errorf("Read() got %d bytes, want 0", len(got))
+				}
+			},
+		)
+	}
+}
+
+func TestReader_StreamBoundaryOffset(t *testing.T) {
+	// good-0cat-empty.xz holds two empty streams of 32 bytes.
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVr9N3pYWgAAAWki3jYAAAAAHN9EIZBCmQ0BAAAAAAFZWg==")
+	var xr *Reader
+	var got []int64
+	xr = NewReader(
+		iotest.OneByteReader(bytes.NewReader(input)),
+		WithStreamCallback(
+			func(info StreamInfo) {
+				offset, ok := xr.StreamBoundaryOffset()
+				if !ok {
+					t.Error("StreamBoundaryOffset() not ok at the end of a stream")
+				}
+				if want := info.Offset + info.Size; offset != want {
+					t.Errorf("StreamBoundaryOffset() = %d, want footer end %d", offset, want)
+				}
+				got = append(got, offset)
+			},
+		),
+	)
+	if _, ok := xr.StreamBoundaryOffset(); ok {
+		t.Error("StreamBoundaryOffset() ok before decoding")
+	}
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(got) != 2 || got[0] != 32 || got[1] != 64 {
+		t.Errorf("StreamBoundaryOffset() = %v, want [32 64]", got)
+	}
+	if offset, ok := xr.StreamBoundaryOffset(); offset != 64 || !ok {
+		t.Errorf("StreamBoundaryOffset() after EOF = %d, %v, want 64, true", offset, ok)
+	}
+
+	// Decoding resumes from the boundary of the first stream.
+	out, err := io.ReadAll(NewReader(bytes.NewReader(input[32:])))
+	if err != nil || len(out) != 0 {
+		t.Errorf("Read() resumed = %q, %v", out, err)
+	}
+
+	if _, ok := NewLZMAReader(bytes.NewReader(nil)).StreamBoundaryOffset(); ok {
+		t.Error("StreamBoundaryOffset() ok for .lzma")
+	}
+}
+
+func TestWithStreamCallback(t *testing.T) {
+	crc32 := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=")
+	crc64 := decodeBase64(t, "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla")
+	input := append(append(append([]byte{}, crc32...), 0, 0, 0, 0), crc64...)
+	want := []StreamInfo{
 		{
-			// has corrupt block Header which may crash xz -lvv in XZ Utils 5.0.3 and earlier.
-			name:        "bad-1-block_header-6.xz",
-			base64Input: "/Td6WFoAAAFpIt42AMARDSEBCAAAAAAAf9456wEADEhlbGxvCldvcmxkIQoAAAAAQ6OiFQABJQ1xGcS2kEKZDQEAAAAAAVla",
-			wantErr:     true,
+			Offset: 0, UncompressedOffset: 0, Size: int64(len(crc32)),
+			UncompressedSize: 13, BlockCount: 1, Check: lzma.CheckCRC32,
 		},
 		{
-			// has wrong Unpadded Sizes in Index.
-			name:        "bad-2-index-1.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhsGGgcAAMZoBy4+MA2LAgAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
-		},
-		{
-			// has wrong Uncompressed Sizes in Index.
-			name:        "bad-2-index-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoNGwAAAJL7eC8+MA2LAgAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			Offset: int64(len(crc32) + 4), UncompressedOffset: 13, Size: int64(len(crc64)),
+			UncompressedSize: 13, BlockCount: 1, Check: lzma.CheckCRC64,
 		},
+	}
+	// Offsets are tracked across calls when the input arrives a byte at a time.
+	for _, src := range []io.Reader{bytes.NewReader(input), iotest.OneByteReader(bytes.NewReader(input))} {
+		var got []StreamInfo
+		var checks []lzma.Check
+		xr := NewReader(
+			src,
+			WithStreamCallback(func(info StreamInfo) { got = append(got, info) }),
+			WithBlockCallback(func(info BlockInfo) { checks = append(checks, info.Check) }),
+		)
+		if _, err := io.ReadAll(xr); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("WithStreamCallback() got %d streams, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("WithStreamCallback() stream %d = %+v, want %+v", i, got[i], want[i])
+			}
+		}
+		if len(checks) != 2 || checks[0] != lzma.CheckCRC32 || checks[1] != lzma.CheckCRC64 {
+			t.Errorf("WithBlockCallback() checks = %v, want [%d %d]", checks, lzma.CheckCRC32, lzma.CheckCRC64)
+		}
+	}
+}
+
+func TestWithCheckPolicy(t *testing.T) {
+	errRejected := errors.New("rejected")
+	rejectNone := func(check lzma.Check) error {
+		if check == lzma.CheckNone {
+			return errRejected
+		}
+		return nil
+	}
+	tests := []struct {
+		name, base64Input string
+		wantErr           error
+	}{
 		{
-			// has non-null byte in Index padding.
-			name:        "bad-2-index-3.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAZDs4Co+MA2LAgAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:        "good-1-check-none.xz",
+			base64Input: "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg==",
+			wantErr:     errRejected,
 		},
 		{
-			// wrong CRC32 in Index.
-			name:        "bad-2-index-4.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc51w+MA2LAgAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:        "good-1-check-crc32.xz",
+			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
 		},
-		{
-			// has zero as Unpadded Size. It is important that the file gets
-			// rejected specifically due to Unpadded Size having an invalid value.
-			name:        "bad-2-index-5.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAjUGAAcAAHu7BSw+MA2LAgAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewReader(bytes.NewReader(decodeBase64(t, tt.base64Input)), WithCheckPolicy(rejectNone)))
+				if err != tt.wantErr {
+					t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr != nil && len(got) != 0 {
+					t.Errorf("Read() got %d bytes before the policy rejected the stream", len(got))
+				}
+			},
+		)
+	}
+}
+
+func TestWithVerifyUncompressedSize(t *testing.T) {
+	for _, tt := range readerTests {
+		if tt.wantErr || tt.srcReader != nil || tt.outReader != nil {
+			continue
+		}
+		got, err := io.ReadAll(NewReader(bytes.NewReader(decodeBase64(t, tt.base64Input)), WithVerifyUncompressedSize()))
+		if err != nil {
+			t.Errorf("%s: Read() error = %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: Read() got = '%v', want %v", tt.name, string(got), tt.want)
+		}
+	}
+
+	// good-1-check-crc64.xz with the Uncompressed Size of its Index record
+	// changed from 13 to 12 and the CRC32 of the Index updated to match.
+	input := decodeBase64(t, "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla")
+	footer := len(input) - 12
+	indexSize := (int(binary.LittleEndian.Uint32(input[footer+4:])) + 1) * 4
+	index := input[footer-indexSize : footer]
+	if index[3] != 13 {
+		t.Fatalf("Index record Uncompressed Size = %d, want 13", index[3])
+	}
+	index[3] = 12
+	binary.LittleEndian.PutUint32(index[indexSize-4:], lzma.CRC32(index[:indexSize-4], 0))
+
+	_, err := io.ReadAll(NewReader(bytes.NewReader(input), WithVerifyUncompressedSize()))
+	if !errors.Is(err, ErrSizeMismatch) || !errors.Is(err, ErrIndex) {
+		t.Errorf("Read() error = %v, want %v", err, ErrSizeMismatch)
+	}
+	_, err = io.ReadAll(NewReader(bytes.NewReader(input)))
+	if !errors.Is(err, ErrIndex) || errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Read() without option error = %v, want %v", err, ErrIndex)
+	}
+}
+
+func TestWithComputedChecks(t *testing.T) {
+	// bad-1-check-crc32.xz stores 0x14a2a343 rather than the CRC32 0x15a2a343
+	// of its data.
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=")
+	xr := NewReader(bytes.NewReader(input), WithIgnoreCheck(), WithComputedChecks())
+	got, err := io.ReadAll(xr)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != "Hello\nWorld!\n" {
+		t.Errorf("Read() got = '%v', want %v", string(got), "Hello\nWorld!\n")
+	}
+	want := [][]byte{{0x43, 0xa3, 0xa2, 0x15}}
+	if checks := xr.ComputedChecks(); !reflect.DeepEqual(checks, want) {
+		t.Errorf("ComputedChecks() = %x, want %x", checks, want)
+	}
+
+	xr = NewReader(bytes.NewReader(input), WithComputedChecks())
+	if _, err := io.ReadAll(xr); !errors.Is(err, ErrData) {
+		t.Errorf("Read() without WithIgnoreCheck error = %v, want %v", err, ErrData)
+	}
+	if checks := xr.ComputedChecks(); len(checks) != 0 {
+		t.Errorf("ComputedChecks() = %x, want none", checks)
+	}
+}
+
+// blockingReader returns the contents of r and then blocks until unblock is
+// closed.
+type blockingReader struct {
+	r       io.Reader
+	unblock chan struct{}
+}
+
+func (b blockingReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		<-b.unblock
+	}
+	return n, err
+}
+
+func TestWithReadTimeout(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	src := blockingReader{iotest.HalfReader(bytes.NewReader(input[:len(input)/2])), unblock}
+	got, err := io.ReadAll(NewReader(src, WithReadTimeout(10*time.Millisecond)))
+	if err != os.ErrDeadlineExceeded {
+		t.Errorf("Read() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if !strings.HasPrefix(loremText, string(got)) {
+		t.Errorf("Read() got = '%v', want a prefix of %v", string(got), loremText)
+	}
+
+	got, err = io.ReadAll(NewReader(iotest.HalfReader(bytes.NewReader(input)), WithReadTimeout(time.Minute)))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText {
+		t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+	}
+}
+
+func TestWithSharedBufferPool(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	var allocs atomic.Int32
+	pool := &sync.Pool{
+		New: func() any {
+			allocs.Add(1)
+			b := make([]byte, 64)
+			return &b
 		},
+	}
+	const readers = 32
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := io.ReadAll(NewReader(iotest.HalfReader(bytes.NewReader(input)), WithSharedBufferPool(pool)))
+			if err == nil && string(got) != loremText {
+				err = fmt.Errorf("got '%v', want %v", string(got), loremText)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Read() error = %v", err)
+		}
+	}
+	if n := allocs.Load(); n == 0 || n > readers {
+		t.Errorf("pool allocated %d buffers, want between 1 and %d", n, readers)
+	}
+
+	// A closed reader returns its buffer to the pool.
+	xr := NewReader(iotest.HalfReader(bytes.NewReader(input)), WithSharedBufferPool(pool))
+	if _, err := xr.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if xr.pooled == nil {
+		t.Fatal("Read() did not borrow a buffer from the pool")
+	}
+	if err := xr.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if xr.pooled != nil || xr.buf != nil {
+		t.Error("Close() kept the pooled buffer")
+	}
+}
+
+func TestWithSkipCorruptStreams(t *testing.T) {
+	good := decodeBase64(t, "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla")
+	// badHeader has a stream header whose CRC32 does not match.
+	badHeader := append([]byte{}, good...)
+	badHeader[8] ^= 0xff
+	// badBlock has a corrupt byte in its stored data, which is returned before
+	// the integrity check of the block fails.
+	badBlock := append([]byte{}, good...)
+	badBlock[len(good)-40] ^= 0xff
+	type skip struct{ offset, size int64 }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	n := int64(len(good))
+	tests := []struct {
+		name      string
+		input     []byte
+		want      string
+		wantSkips []skip
+	}{
 		{
-			// has Index whose Uncompressed Size fields have huge values whose
-			// sum exceeds the maximum allowed size of 2^63 - 1 bytes. In this
-			// file the sum is exactly 2^64. lzma_index_append() in
-			// liblzma <= 5.2.6 lacks the integer overflow check for the
-			// uncompressed size and thus doesn't catch the error when decoding
-			// the Index field in this file. This makes "xz -l" not detect the
-			// error and will display 0 as the uncompressed size. Note that
-			// regular decompression isn't affected by this bug because it uses
-			// lzma_index_hash_append() instead.
-			name:        "bad-3-index-uncomp-overflow.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQwAAACPmEGcAQAFSGVsbG8KAAAAFjWWMQIAIQEMAAAAj5hBnAEABFdvcmxkAAAAAEc+tvsCACEBDAAAAI+YQZwBAAEhCgAAAALuky0AAxr//////////38Z//////////9/FgIyic40KHKcEAYAAAAAAVla",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:      "corrupt stream header",
+			input:     cat(good, badHeader, good),
+			want:      "Hello\nWorld!\nHello\nWorld!\n",
+			wantSkips: []skip{{n, n}},
 		},
 		{
-			// has non-null byte in the padding of the Compressed Data field of
-			// the first block.
-			name:        "bad-2-compressed_data_padding.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAABFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=",
-			want:        "Hello\n",
-			wantErr:     true,
+			name:      "corrupt block",
+			input:     cat(good, badBlock, good),
+			want:      "Hello\nWorld!\nHello\xf5World!\nHello\nWorld!\n",
+			wantSkips: []skip{{n, n}},
 		},
 		{
-			// has wrong Check (CRC32).
-			name:        "bad-1-check-crc32.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgBDo6IUAAEkDTAo36+QQpkNAQAAAAABWVo=",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:      "garbage between streams",
+			input:     cat(good, []byte("garbage"), good),
+			want:      "Hello\nWorld!\nHello\nWorld!\n",
+			wantSkips: []skip{{n, 7}},
 		},
 		{
-			// has Compressed Size and Uncompressed Size in block Header but
-			// wrong Check (CRC32) in the actual data. This file differs by one
-			// byte from good-1-block_header-1.xz: the last byte of the Check
-			// field is wrong. This file is useful for testing error detection
-			// in the threaded decoder when a worker thread is configured to
-			// pass base64Input one byte at a time to the block decoder.
-			name:        "bad-1-check-crc32-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42A8ARDSEBCAAAAAAAf9456wEADEhlbGxvCldvcmxkIQoAAAAAQ6Oi/wABJQ1xGcS2kEKZDQEAAAAAAVla",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:      "corrupt last stream",
+			input:     cat(good, badHeader, []byte{1, 2}),
+			want:      "Hello\nWorld!\n",
+			wantSkips: []skip{{n, n + 2}},
 		},
 		{
-			// has wrong Check (CRC64).
-			name:        "bad-1-check-crc64.xz",
-			base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WywABKA08Z2oDH7bzfQEAAAAABFla",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+			name:      "truncated last stream header",
+			input:     cat(good, good[:5]),
+			want:      "Hello\nWorld!\n",
+			wantSkips: []skip{{n, 5}},
 		},
-		{
-			// has wrong Check (SHA-256).
-			name:        "bad-1-check-sha256.xz",
-			base64Input: "/Td6WFoAAArh+wyhAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgCOWTXn4TNozZaI/o9IoJVSk2dqAhViWCx+hI2v4T+wRwABQA2Thk6uGJtLmgEAAAAAClla",
-			want:        "Hello\nWorld!\n",
-			wantErr:     true,
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				for _, src := range []io.Reader{bytes.NewReader(tt.input), iotest.OneByteReader(bytes.NewReader(tt.input))} {
+					var skips []skip
+					xr := NewReader(src, WithSkipCorruptStreams(func(offset, size int64) { skips = append(skips, skip{offset, size}) }))
+					got, err := io.ReadAll(xr)
+					if err != nil {
+						t.Fatalf("Read() error = %v", err)
+					}
+					if string(got) != tt.want {
+						t.Errorf("Read() got = %q, want %q", got, tt.want)
+					}
+					if len(skips) != len(tt.wantSkips) || (len(skips) > 0 && skips[0] != tt.wantSkips[0]) {
+						t.Errorf("skipped %v, want %v", skips, tt.wantSkips)
+					}
+				}
+			},
+		)
+	}
+
+	// Without the option the corrupt stream fails decoding.
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(cat(good, badHeader, good)))); err == nil {
+		t.Error("Read() expected error without WithSkipCorruptStreams")
+	}
+}
+
+// FuzzReader decodes arbitrary input, checking the decoder is freed exactly
+// once whatever the outcome. Run it with:
+//
+//	go test -run '^$' -fuzz FuzzReader
+func FuzzReader(f *testing.F) {
+	for _, tt := range readerTests {
+		f.Add(decodeBase64(f, tt.base64Input))
+	}
+	f.Fuzz(
+		func(t *testing.T, input []byte) {
+			for _, src := range []io.Reader{bytes.NewReader(input), iotest.HalfReader(bytes.NewReader(input))} {
+				xr := NewReader(src)
+				closes := 0
+				xr.stream = closeCounter{decoder: xr.stream, closes: &closes}
+				if _, err := io.ReadAll(xr); err == io.EOF {
+					t.Error("ReadAll() returned io.EOF")
+				}
+				if _, err := xr.Read(make([]byte, 1)); err == nil {
+					t.Error("Read() after the terminal Read expected error")
+				}
+				_ = xr.Close()
+				if closes != 1 {
+					t.Fatalf("decoder closed %d times, want 1", closes)
+				}
+			}
 		},
+	)
+}
+
+// closeCounter counts the calls to Close of a decoder.
+type closeCounter struct {
+	decoder
+	closes *int
+}
+
+func (c closeCounter) Close() error {
+	*c.closes++
+	return c.decoder.Close()
+}
+
+func TestWithMinFill(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	codes := func(opts ...ReaderOption) int {
+		t.Helper()
+		xr := NewReader(iotest.OneByteReader(bytes.NewReader(input)), opts...)
+		counter := &codeCounter{decoder: xr.stream}
+		xr.stream = counter
+		got, err := io.ReadAll(xr)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if string(got) != loremText {
+			t.Errorf("Read() got = '%v', want %v", string(got), loremText)
+		}
+		return counter.codes
+	}
+	byteAtATime := codes()
+	if byteAtATime < len(input) {
+		t.Errorf("Code() called %d times for %d single byte reads", byteAtATime, len(input))
+	}
+	if filled := codes(WithMinFill(64)); filled*8 > byteAtATime {
+		t.Errorf("Code() called %d times with WithMinFill(64), want under %d", filled, byteAtATime/8)
+	}
+}
+
+// codeCounter counts the calls to Code of a decoder.
+type codeCounter struct {
+	decoder
+	codes int
+}
+
+func (c *codeCounter) Code(action lzma.Action) lzma.Return {
+	c.codes++
+	return c.decoder.Code(action)
+}
+
+func TestReader_Total(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	lzmaInput := decodeBase64(t, "XQAAgAD//////////wAkGUmYbwUVJycNdnjQKmgXFf//dfgAAA==")
+	tests := []struct {
+		name            string
+		xr              *Reader
+		wantIn, wantOut int
+	}{
+		{name: "NewReader", xr: NewReader(iotest.HalfReader(bytes.NewReader(input))), wantIn: len(input), wantOut: len(loremText)},
+		{name: "NewLZMAReader", xr: NewLZMAReader(bytes.NewReader(lzmaInput)), wantIn: len(lzmaInput), wantOut: 13},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(tt.xr)
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if len(got) != tt.wantOut {
+					t.Errorf("Read() got %d bytes, want %d", len(got), tt.wantOut)
+				}
+				if in, out := tt.xr.TotalIn(), tt.xr.TotalOut(); in != uint64(tt.wantIn) || out != uint64(tt.wantOut) {
+					t.Errorf("TotalIn(), TotalOut() = %d, %d, want %d, %d", in, out, tt.wantIn, tt.wantOut)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_CompressedOffset(t *testing.T) {
+	// good-2-lzma2.xz
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=")
+	xr := NewReader(iotest.OneByteReader(bytes.NewReader(input)))
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got := xr.CompressedOffset(); got != int64(len(input)) {
+		t.Errorf("CompressedOffset() = %d, want %d", got, len(input))
+	}
+
+	// Corrupting the data of the second block, which starts at 40, fails
+	// decoding within that block.
+	input[55] ^= 0xff
+	xr = NewReader(bytes.NewReader(input))
+	if _, err := io.ReadAll(xr); err == nil {
+		t.Fatal("Read() expected error for corrupt data")
+	}
+	if got := xr.CompressedOffset(); got < 40 || got > 68 {
+		t.Errorf("CompressedOffset() = %d, want within the second block at [40, 68]", got)
+	}
+}
+
+func TestWithHashTap(t *testing.T) {
+	// good-1-check-sha256.xz
+	input := decodeBase64(t, "/Td6WFoAAArh+wyhAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgCOWTXn4TNozZaI/o9IoJVSk2dqAhViWCx+hI2v4T+wRgABQA2Thk6uGJtLmgEAAAAAClla")
+	h := sha256.New()
+	xr := NewReader(iotest.OneByteReader(bytes.NewReader(input)), WithHashTap(h))
+	if xr.CheckValue() != nil {
+		t.Errorf("CheckValue() = %x before decoding, want nil", xr.CheckValue())
+	}
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got := xr.CheckType(); got != lzma.CheckSHA256 {
+		t.Errorf("CheckType() = %v, want %v", got, lzma.CheckSHA256)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, xr.CheckValue()) {
+		t.Errorf("WithHashTap() sum = %x, want stored check %x", got, xr.CheckValue())
+	}
+
+	xr = NewReaderThreads(bytes.NewReader(input), 2)
+	if _, err := io.ReadAll(xr); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got := xr.CheckType(); got != lzma.CheckSHA256 {
+		t.Errorf("NewReaderThreads() CheckType() = %v, want %v", got, lzma.CheckSHA256)
+	}
+}
+
+// sumVerifier is a PostVerifier comparing the SHA-256 of the output with want.
+type sumVerifier struct {
+	hash.Hash
+	want []byte
+}
+
+func (v *sumVerifier) Verify() error {
+	if !bytes.Equal(v.Sum(nil), v.want) {
+		return errors.New("sha256 mismatch")
+	}
+	return nil
+}
+
+func TestWithPostVerify(t *testing.T) {
+	input := decodeBase64(t, loremBase64)
+	errVerify := errors.New("verify failed")
+	sum := sha256.Sum256([]byte(loremText))
+	tests := []struct {
+		name    string
+		opt     ReaderOption
+		wantErr string
+	}{
 		{
-			// has LZMA2 stream whose first chunk (uncompressed) doesn't reset
-			// the dictionary.
-			name:        "bad-1-lzma2-1.xz",
-			base64Input: "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAgAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg==",
-			wantErr:     true,
+			name: "passing",
+			opt: WithPostVerify(func(decoded []byte) error {
+				if string(decoded) != loremText {
+					return errVerify
+				}
+				return nil
+			}),
 		},
 		{
-			// has two LZMA2 chunks, of which the second chunk indicates
-			// dictionary reset, but the LZMA compressed data tries to repeat
-			// data from the previous chunk.
-			name:        "bad-1-lzma2-2.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoA4ADlAK0LAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg==",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \n",
-			wantErr:     true,
+			name:    "failing",
+			opt:     WithPostVerify(func([]byte) error { return errVerify }),
+			wantErr: errVerify.Error(),
 		},
 		{
-			// sets new invalid properties (lc=8, lp=0, pb=0) in the middle of
-			// block.
-			name:        "bad-1-lzma2-3.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMT4ADiALZdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6RgTmzh/D/ALNqUkHtLrDyZJekmp5joa4ZdA2p1Vts7rHgLNxh3Mudhs/h3Ap6gRRf0EDIfg2XRM61wvwsWQi/A4Dc10SOs9Qt3uUWIW5HgqwIWdjkZilh1dH6SWOQET4g0Kni1RSB2SPQj0OuRVU2aaoAwADlAK0IAIzxnUAr0H0dme7k3GN0ZEakoEpkZbL2TsHIaJ8nVK27pjQ8d+wPLhuOQiflaL9g9As68Jsx698/2K+lVZJGBVgiCY+oYAgLo+k+vLQW28ejosAW1RSnIugv6LTQdxfFi+Tyu2vW75qBNE4d3Ow25kRyvym1PAUxYGa6LAMP1kfGfYXUxV5OV3PDQWm+DYyctRWp59J4UUvVKdD5NRrFXfSMenDVXqgxV4DIpdjgAAAA+0dI2wABggPJAwAACwSO3j4wDYsCAAAAAAFZWg==",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \n",
-			wantErr:     true,
+			name: "passing streamed",
+			opt:  WithPostVerifier(&sumVerifier{Hash: sha256.New(), want: sum[:]}),
 		},
 		{
-			// has two LZMA2 chunks, of which the first is uncompressed and the
-			// second is LZMA. The first chunk resets dictionary as it should,
-			// but the second chunk tries to reset state without specifying
-			// properties for LZMA.
-			name:        "bad-1-lzma2-4.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIAqgAZMBJAAymwlsVNculWz59zfVHOJGAoJ1+0l2jXNTtv1t28rb2UQLsS6+E7a6qOLz7XVU3EEgzL82WyCZXQ8hoQajli23l5zwe/7iEowtUfDbdnd9pHvTlen7Beb1l49i6dswu7RwPRZ4A3c6i3rVuPhKJyX1jqokFKYpKGsvc+ChcbR7pIBQQMrv27SV/bvBjI5gl9vLfyHtwBBxGn3LzQnQ2f9tgMBnfT/GlM9b3VER0cvUINcrhE6oRbtCeBpoQF8kXok6Nn3bmCjM+YPsMgYxR0c7bBz0YjRAsyi7VDbdeg4cNiU4WAb4FaPOGMj9lh5pKQPDvSfz54/bc7QrTzhYJL+DFDl+c+7+z8q98yFqKIDIjl2Bx7wX0CyTtQiVug6Sgmau/7gDAAD7R0jbAAHzAskDAADf85AjPjANiwIAAAAAAVla",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \n",
-			wantErr:     true,
+			name:    "failing streamed",
+			opt:     WithPostVerifier(&sumVerifier{Hash: sha256.New(), want: make([]byte, len(sum))}),
+			wantErr: "sha256 mismatch",
 		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				xr := NewReader(iotest.OneByteReader(bytes.NewReader(input)), tt.opt)
+				got, err := io.ReadAll(xr)
+				if (err != nil) != (tt.wantErr != "") || err != nil && err.Error() != tt.wantErr {
+					t.Errorf("Read() error = %v, want %q", err, tt.wantErr)
+				}
+				if string(got) != loremText {
+					t.Errorf("Read() got %q, want %q", got, loremText)
+				}
+				// The error is kept and the output is not verified again.
+				_, err2 := xr.Read(make([]byte, 1))
+				if tt.wantErr != "" && err2 != err || tt.wantErr == "" && err2 != io.EOF {
+					t.Errorf("Read() after end error = %v, want %v", err2, err)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_ReadByte(t *testing.T) {
+	xr := NewReader(bytes.NewReader(decodeBase64(t, loremBase64)))
+	var got []byte
+	for {
+		b, err := xr.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadByte() error = %v", err)
+		}
+		got = append(got, b)
+	}
+	if string(got) != loremText {
+		t.Errorf("ReadByte() got %q, want %q", got, loremText)
+	}
+	if _, err := xr.ReadByte(); err != io.EOF {
+		t.Errorf("ReadByte() error = %v, want %v", err, io.EOF)
+	}
+
+	// Read returns the output decoded ahead by ReadByte first.
+	xr = NewReader(bytes.NewReader(decodeBase64(t, loremBase64)))
+	b, err := xr.ReadByte()
+	if err != nil {
+		t.Fatalf("ReadByte() error = %v", err)
+	}
+	rest, err := io.ReadAll(xr)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got := string(b) + string(rest); got != loremText {
+		t.Errorf("ReadByte() and Read() got %q, want %q", got, loremText)
+	}
+}
+
+func TestWithTap(t *testing.T) {
+	var tapped []byte
+	xr := NewReader(
+		bytes.NewReader(decodeBase64(t, loremBase64)),
+		WithTap(func(p []byte) { tapped = append(tapped, p...) }),
+	)
+	got, err := io.ReadAll(iotest.OneByteReader(xr))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != loremText || !bytes.Equal(tapped, got) {
+		t.Errorf("WithTap() tapped '%v', read '%v'", string(tapped), string(got))
+	}
+}
+
+func TestReader_Read_vliErrors(t *testing.T) {
+	for _, tt := range readerTests {
+		isVLI := strings.HasPrefix(tt.name, "bad-1-vli-")
+		if !isVLI && !strings.HasPrefix(tt.name, "bad-1-block_header-") {
+			continue
+		}
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := io.ReadAll(NewReader(bytes.NewReader(decodeBase64(t, tt.base64Input))))
+				if !errors.Is(err, ErrData) && isVLI {
+					t.Errorf("Read() error = %v, want %v", err, ErrData)
+				}
+				if got := err != nil && strings.Contains(err.Error(), "invalid variable-length integer encoding"); got != isVLI {
+					t.Errorf("Read() error = %v, want VLI error %v", err, isVLI)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_Read_lzma2ControlErrors(t *testing.T) {
+	for _, tt := range readerTests {
+		if !strings.HasPrefix(tt.name, "bad-1-lzma2-") && !strings.HasPrefix(tt.name, "good-") {
+			continue
+		}
+		isControl := tt.name == "bad-1-lzma2-6.xz"
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(decodeBase64(t, tt.base64Input)))))
+				if isControl && !errors.Is(err, ErrData) {
+					t.Errorf("Read() error = %v, want %v", err, ErrData)
+				}
+				if isControl && string(got) != tt.want {
+					t.Errorf("Read() got %q, want %q", got, tt.want)
+				}
+				if hasControl := err != nil && strings.Contains(err.Error(), "invalid LZMA2 control byte"); hasControl != isControl {
+					t.Errorf("Read() error = %v, want control byte error %v", err, isControl)
+				}
+			},
+		)
+	}
+}
+
+func TestReader_Read_checkNoneSizeMismatch(t *testing.T) {
+	// bad-1-lzma2-11.xz with its LZMA2 chunk shortened by its last byte, which
+	// becomes the end marker, so that it ends one byte before the Uncompressed
+	// Size of 13 in the block header.
+	short := decodeBase64(t, "/Td6WFoAAAD/EtlBA8AQDSEBDAAAAAAAV/dqnwEADEhlbGxvIFdvcmxkIQoAASANNO2zywZynnoBAAAAAABZWg==")
+	short[30], short[43] = 0x0b, 0x00
+	tests := []struct {
+		name        string
+		input       []byte
+		want        string
+		wantErr     error
+		wantErrSize bool
+	}{
 		{
-			// is like bad-1-lzma2-4.xz but doesn't try to reset anything in the
-			// header of the second chunk.
-			name:        "bad-1-lzma2-5.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIAqAAZMBJAAymwlsVNculWz59zfVHOJGAoJ1+0l2jXNTtv1t28rb2UQLsS6+E7a6qOLz7XVU3EEgzL82WyCZXQ8hoQajli23l5zwe/7iEowtUfDbdnd9pHvTlen7Beb1l49i6dswu7RwPRZ4A3c6i3rVuPhKJyX1jqokFKYpKGsvc+ChcbR7pIBQQMrv27SV/bvBjI5gl9vLfyHtwBBxGn3LzQnQ2f9tgMBnfT/GlM9b3VER0cvUINcrhE6oRbtCeBpoQF8kXok6Nn3bmCjM+YPsMgYxR0c7bBz0YjRAsyi7VDbdeg4cNiU4WAb4FaPOGMj9lh5pKQPDvSfz54/bc7QrTzhYJL+DFDl+c+7+z8q98yFqKIDIjl2Bx7wX0CyTtQiVug6Sgmau/7gDAAD7R0jbAAHzAskDAADf85AjPjANiwIAAAAAAVla",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \n",
-			wantErr:     true,
+			name:  "good-1-check-none.xz",
+			input: decodeBase64(t, "/Td6WFoAAAD/EtlBAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgAAASANNO2zywZynnoBAAAAAABZWg=="),
+			want:  "Hello\nWorld!\n",
 		},
 		{
-			// has reserved LZMA2 control byte value (0x03).
-			name:        "bad-1-lzma2-6.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAwAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo=",
-			want:        "Hello\n",
-			wantErr:     true,
+			name:        "bad-1-lzma2-11.xz",
+			input:       decodeBase64(t, "/Td6WFoAAAD/EtlBA8AQDSEBDAAAAAAAV/dqnwEADEhlbGxvIFdvcmxkIQoAASANNO2zywZynnoBAAAAAABZWg=="),
+			want:        "Hello World!\n",
+			wantErr:     ErrSizeMismatch,
+			wantErrSize: true,
 		},
 		{
-			// has EOPM at LZMA level.
-			name:        "bad-1-lzma2-7.xz",
-			base64Input: "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQA0TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2ljaW5nIAqAAZMBJAAymwlsVNculWz59zfVHOJGAoJ1+0l2jXNTtv1t28rb2UQLsS6+E7a6qOLz7XVU3EEgzL82WyCZXQ8hoQajli23l5zwe/7iEowtUfDbdnd9pHvTlen7Beb1l49i6dswu7RwPRZ4A3c6i3rVuPhKJyX1jqokFKYpKGsvc+ChcbR7pIBQQMrv27SV/bvBjI5gl9vLfyHtwBBxGn3LzQnQ2f9tgMBnfT/GlM9b3VER0cvUINcrhE6oRbtCeBpoQF8kXok6Nn3bmCjM+YPsMgYxR0c7bBz0YjRAsyi7VDbdeg4cNiU4WAb4FaPOGMj9lh5pKQPDvSfz54/bc7QrTzhYJL+DFDl+c+7+z8q98yFqKIDIjl2Bx7wX0CyTtQiVug6Sgmau/7gDAAD7R0jbAAHzAskDAADf85AjPjANiwIAAAAAAVla",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \n",
-			wantErr:     true,
+			name:        "data shorter than header size",
+			input:       short,
+			want:        "Hello World!",
+			wantErr:     ErrSizeMismatch,
+			wantErrSize: true,
 		},
 		{
-			// is like good-1-lzma2-4.xz but doesn't set new properties in the
-			// third LZMA2 chunk.
-			name:        "bad-1-lzma2-8.xz",
-			base64Input: "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMT4AC7AKFdACYbykZnWvJ3uH2G2EHbBTXNg6V8EqUF25C9LxTTcXKWqIp9hFZxjWoimKuePZCALcdeDBJS0z8HCHscpHfzE7gXwO6Rc8q8z+s0ZqxIm2nZkweuzlCvaAkvW4gfwgiiLFhFsP9iCevu22NPb+DzH88SN5iWTvbysvtur0QC4iLe1eY0lzmjRS+umS95aY/pN4lI/sx+6qkorcPm3LnaqhZ+AQAmbGFib3JpcyBuaXNpIHV0IGFsaXF1aXAgZXggZWEgY29tbW9kbyAKoADlAL0AMZvKGcVU7LZU57F9xFeebImtSm0W2DwFlBAWmTgho7nFgP/87tTVP92M1z2PduyIqjKrZdQ47/f5ir/3+KVW123XP4ULnj/iR2giCAU1uEFy+du+t46Gv0NLjg1DL0Fp32EMxOg3CErewnYWuEhOnrlTUB8zg+gpoGfIZjp/IhJi+0fkvPRRDxWISdjKCyWLXuja/TjAzkxzG//Qm+hMtxP4N5ni2pwvteq4pY3qV4KbJcr79ogKm99BA24AAAAAsgdE6RczS4QAAaoDyQMAAFCDcTWxxGf7AgAAAAAEWVo=",
-			want:        "Lorem ipsum dolor sit amet, consectetur adipisicing \nelit, sed do eiusmod tempor incididunt ut \nlabore et dolore magna aliqua. Ut enim \nad minim veniam, quis nostrud exercitation ullamco \nlaboris nisi ut aliquip ex ea commodo \n",
-			wantErr:     true,
-		},
-		// Flaky test on ubuntu-latest liblzma v5.2.5
-		// {
-		// 	// as LZMA2 stream that is truncated at the end of a LZMA2 chunk (no end marker).
-		// 	// The uncompressed size of the partial LZMA2 stream exceeds the
-		// 	// value stored in the block Header.
-		// 	name:        "bad-1-lzma2-9.xz",
-		// 	base64Input: "/Td6WFoAAAFpIt42A8AUDSEBCAAAAAAAOxUQDQEADEhlbGxvCldvcmxkIQoC//94Q6OiFQABKA08Z2oDkEKZDQEAAAAAAVla",
-		// 	want:        "Hello\nWorld!\n",
-		// 	wantErr:     true,
-		// },
-		// Flaky test on ubuntu-latest liblzma v5.2.5
-		// {
-		// 	// has LZMA2 stream that, from point of view of a LZMA2 decoder,
-		// 	// extends past the end of block (and even the end of the file).
-		// 	// Uncompressed Size in block Header is bigger than the invalid
-		// 	// LZMA2 stream may produce (even if a decoder reads until the end
-		// 	// of the file). The Check type is None to nullify certain simple
-		// 	// size-based sanity checks in a block decoder.
-		// 	name:        "bad-1-lzma2-10.xz",
-		// 	base64Input: "/Td6WFoAAAFpIt42A8AUDSEBCAAAAAAAOxUQDQEADEhlbGxvCldvcmxkIQoC//94Q6OiFQABKA08Z2oDkEKZDQEAAAAAAVla",
-		// 	want:        "Hello\nWorld!\n",
-		// 	wantErr:     true,
-		// },
-		{
-			// has LZMA2 stream that lacks the end of payload marker. When
-			// Compressed Size bytes have been decoded, Uncompressed Size bytes
-			// of output will have been produced but the LZMA2 decoder doesn't
-			// indicate end of stream.
-			name:        "bad-1-lzma2-11.xz",
-			base64Input: "/Td6WFoAAAD/EtlBA8AQDSEBDAAAAAAAV/dqnwEADEhlbGxvIFdvcmxkIQoAASANNO2zywZynnoBAAAAAABZWg==",
-			want:        "Hello World!\n",
-			wantErr:     true,
+			name:    "bad-1-lzma2-6.xz",
+			input:   decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAwAGV29ybGQhCgBDo6IVAAEkDTAo36+QQpkNAQAAAAABWVo="),
+			want:    "Hello\n",
+			wantErr: ErrData,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(tt.base64Input))
-				if tt.srcReader != nil {
-					r = tt.srcReader(r)
+				got, err := io.ReadAll(NewReader(bytes.NewReader(tt.input)))
+				if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+					t.Errorf("Read() error = %v, want %v", err, tt.wantErr)
 				}
-				var xr io.Reader = NewReader(r)
-				if tt.outReader != nil {
-					xr = tt.outReader(xr)
+				if errors.Is(err, ErrSizeMismatch) != tt.wantErrSize {
+					t.Errorf("Read() error = %v, want size mismatch %v", err, tt.wantErrSize)
 				}
-				got, err := io.ReadAll(xr)
-				if (err != nil) != tt.wantErr {
-					t.Errorf("Read() error = %v, wantErr %v", err, tt.wantErr)
-					return
+				if errors.Is(err, ErrIndex) {
+					t.Errorf("Read() error = %v matches %v", err, ErrIndex)
 				}
 				if string(got) != tt.want {
-					t.Errorf("Read() got = '%v', want %v", string(got), tt.want)
+					t.Errorf("Read() got %q, want %q", got, tt.want)
 				}
 			},
 		)
 	}
 }
+
+func TestWithMaxStreams(t *testing.T) {
+	empty := decodeBase64(t, "/Td6WFoAAAFpIt42AAAAABzfRCGQQpkNAQAAAAABWVo=")
+	input := bytes.Repeat(empty, 3)
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(input), WithMaxStreams(3))); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	_, err := io.ReadAll(NewReader(bytes.NewReader(input), WithMaxStreams(2)))
+	if !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("Read() error = %v, want %v", err, ErrTooManyStreams)
+	}
+	if errors.Is(err, ErrData) {
+		t.Errorf("Read() error = %v matches %v", err, ErrData)
+	}
+	var xzErr *Error
+	if !errors.As(err, &xzErr) || xzErr.Code != lzma.DataError {
+		t.Errorf("Read() error = %v, want code %v", err, lzma.DataError)
+	}
+	_, err = io.ReadAll(NewReader(bytes.NewReader(input), WithMaxStreams(2), WithSkipCorruptStreams(func(offset, size int64) {
+		t.Errorf("onSkip(%d, %d) called", offset, size)
+	})))
+	if !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("Read() skipping error = %v, want %v", err, ErrTooManyStreams)
+	}
+}
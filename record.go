@@ -0,0 +1,69 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"errors"
+	"io"
+)
+
+// RecordReader decodes XZ compressed data into records of a fixed size.
+type RecordReader struct {
+	xr   *Reader
+	size int
+	// tail holds the decoded output short of a full record.
+	tail []byte
+	err  error
+}
+
+// NewRecordReader creates a XZ decoder reader like NewReader whose Read only
+// returns whole records of recordSize bytes, buffering any partial record,
+// until the end of the output where the remainder is returned. If recordSize
+// is not positive no decoder is created and every Read fails.
+func NewRecordReader(src io.Reader, recordSize int, opts ...ReaderOption) *RecordReader {
+	if recordSize <= 0 {
+		return &RecordReader{err: errors.New("record size must be positive")}
+	}
+	return &RecordReader{
+		xr:   NewReader(src, opts...),
+		size: recordSize,
+		tail: make([]byte, 0, recordSize),
+	}
+}
+
+// Read reads as many whole records as fit in p, which must hold at least one
+// record. It returns io.ErrShortBuffer otherwise.
+func (r *RecordReader) Read(p []byte) (int, error) {
+	if r.xr == nil {
+		return 0, r.err
+	}
+	if len(p) < r.size {
+		return 0, io.ErrShortBuffer
+	}
+	p = p[:len(p)/r.size*r.size]
+	n := copy(p, r.tail)
+	for n < r.size && r.err == nil {
+		var m int
+		m, r.err = r.xr.Read(p[n:])
+		n += m
+	}
+	records := n / r.size * r.size
+	if records == 0 && r.err != nil {
+		// The remainder at the end of the output.
+		records = n
+	}
+	r.tail = append(r.tail[:0], p[records:n]...)
+	if len(r.tail) > 0 {
+		return records, nil
+	}
+	return records, r.err
+}
+
+// Close closes the underlying Reader.
+func (r *RecordReader) Close() error {
+	if r.xr == nil {
+		return nil
+	}
+	return r.xr.Close()
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRecordReader(t *testing.T) {
+	// good-1-check-crc64.xz
+	input := decodeBase64(t, "/Td6WFoAAATm1rRGAgAhAQgAAADYDyMTAQAFSGVsbG8KAgAGV29ybGQhCgDvLogRnT+WygABKA08Z2oDH7bzfQEAAAAABFla")
+	rr := NewRecordReader(iotest.OneByteReader(bytes.NewReader(input)), 4)
+	var got []int
+	var out []byte
+	p := make([]byte, 4)
+	for {
+		n, err := rr.Read(p)
+		if n > 0 {
+			got = append(got, n)
+			out = append(out, p[:n]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if want := []int{4, 4, 4, 1}; len(got) != len(want) || got[0] != 4 || got[1] != 4 || got[2] != 4 || got[3] != 1 {
+		t.Errorf("Read() sizes = %v, want %v", got, want)
+	}
+	if string(out) != "Hello\nWorld!\n" {
+		t.Errorf("Read() got = '%v', want %v", string(out), "Hello\nWorld!\n")
+	}
+	if _, err := rr.Read(make([]byte, 3)); err != io.ErrShortBuffer {
+		t.Errorf("Read() error = %v, want %v", err, io.ErrShortBuffer)
+	}
+	if err := rr.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	// Larger reads return every whole record available.
+	rr = NewRecordReader(bytes.NewReader(input), 4)
+	n, err := rr.Read(make([]byte, 10))
+	if n != 8 || err != nil {
+		t.Errorf("Read() = %d, %v, want 8, nil", n, err)
+	}
+}
+
+func TestNewRecordReader_invalidSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		rr := NewRecordReader(bytes.NewReader(nil), size)
+		if n, err := rr.Read(make([]byte, 8)); n != 0 || err == nil || err == io.EOF {
+			t.Errorf("Read() with record size %d = %d, %v, want an error", size, n, err)
+		}
+		if err := rr.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+// Report accumulates the structure of the input of a reader created with
+// WithReport as it is decoded, the same details as listed by
+// xz --list --verbose.
+type Report struct {
+	// Streams are the streams that have been decoded, in the order of the
+	// input.
+	Streams []StreamReport
+	// blocks are the blocks decoded of the stream not yet ended.
+	blocks []BlockReport
+}
+
+// StreamReport describes a decoded stream and its blocks.
+type StreamReport struct {
+	StreamInfo
+	Blocks []BlockReport
+}
+
+// BlockReport describes a decoded block and the integrity check stored in it.
+type BlockReport struct {
+	BlockInfo
+	// CheckValue is the integrity check stored after the block data, empty for
+	// lzma.CheckNone.
+	CheckValue []byte
+}
+
+// addBlock records a block of the current stream.
+func (rep *Report) addBlock(info BlockInfo, check []byte) {
+	rep.blocks = append(rep.blocks, BlockReport{BlockInfo: info, CheckValue: check})
+}
+
+// addStream records the end of the current stream with the blocks added since
+// the previous stream.
+func (rep *Report) addStream(info StreamInfo) {
+	rep.Streams = append(rep.Streams, StreamReport{StreamInfo: info, Blocks: rep.blocks})
+	rep.blocks = nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestWithReport(t *testing.T) {
+	// good-2-lzma2.xz
+	input := decodeBase64(t, "/Td6WFoAAAFpIt42AgAhAQgAAADYDyMTAQAFSGVsbG8KAAAAFjWWMQIAIQEIAAAA2A8jEwEABldvcmxkIQoAAN3RylMAAhoGGwcAAAbc510+MA2LAgAAAAABWVo=")
+	var rep Report
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(input), WithReport(&rep))); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	crc := func(s string) []byte {
+		return binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte(s)))
+	}
+	want := []StreamReport{
+		{
+			StreamInfo: StreamInfo{Size: int64(len(input)), UncompressedSize: 13, BlockCount: 2, Check: lzma.CheckCRC32},
+			Blocks: []BlockReport{
+				{
+					BlockInfo: BlockInfo{
+						Offset: 12, HeaderSize: 12, CompressedSize: 10, UncompressedSize: 6, TotalSize: 28,
+						Check: lzma.CheckCRC32,
+					},
+					CheckValue: crc("Hello\n"),
+				},
+				{
+					BlockInfo: BlockInfo{
+						Offset: 40, UncompressedOffset: 6, HeaderSize: 12, CompressedSize: 11, UncompressedSize: 7,
+						TotalSize: 28, Check: lzma.CheckCRC32,
+					},
+					CheckValue: crc("World!\n"),
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(rep.Streams, want) {
+		t.Errorf("Report.Streams = %+v, want %+v", rep.Streams, want)
+	}
+}
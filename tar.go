@@ -0,0 +1,163 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// A TarOption configures TarDir.
+type TarOption func(*tarConfig)
+
+type tarConfig struct {
+	exclude []string
+}
+
+// WithExcludeGlob leaves out of the archive every file and directory whose
+// slash-separated path relative to the root, or whose name, matches pattern
+// as by path.Match. The contents of an excluded directory are left out too.
+func WithExcludeGlob(pattern string) TarOption {
+	return func(c *tarConfig) {
+		c.exclude = append(c.exclude, pattern)
+	}
+}
+
+// excluded reports whether the file at the relative path name is excluded.
+func (c *tarConfig) excluded(name string) (bool, error) {
+	for _, pattern := range c.exclude {
+		for _, s := range []string{name, path.Base(name)} {
+			matched, err := path.Match(pattern, s)
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// TarDir writes the directory tree at root to dst as a tar archive compressed
+// as a single .xz stream using the compression level preset. Paths in the
+// archive are relative to root, which itself is not included, and are walked
+// in lexical order. Regular files, directories and symbolic links are archived;
+// other files fail with an error. The archive and the stream are complete once
+// TarDir returns nil, otherwise the stream is left incomplete. dst is not
+// closed.
+func TarDir(dst io.Writer, root string, preset uint32, opts ...TarOption) error {
+	var config tarConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	// The tar writer makes many small writes of headers and padding, which
+	// are buffered to run the encoder over larger inputs.
+	xw, err := NewWriterSize(dst, preset, defaultBufferSize)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(xw)
+	err = filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		excluded, err := config.excluded(rel)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		return writeTarEntry(tw, name, rel, entry)
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		_ = xw.Abort()
+		return err
+	}
+	return xw.Close()
+}
+
+// writeTarEntry writes the header and contents of the file at name to tw as
+// rel.
+func writeTarEntry(tw *tar.Writer, name, rel string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(name); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = rel
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// TarReader reads the files of a tar archive compressed as .xz, such as
+// written by TarDir.
+type TarReader struct {
+	*tar.Reader
+	xr *Reader
+}
+
+// OpenTar creates a TarReader of the .tar.xz archive read from src, decoded
+// like NewReader with the given options.
+func OpenTar(src io.Reader, opts ...ReaderOption) *TarReader {
+	xr := NewReader(src, opts...)
+	return &TarReader{Reader: tar.NewReader(xr), xr: xr}
+}
+
+// Next advances to the next file of the archive like tar.Reader.Next. At the
+// end of the archive, the rest of the .xz data is decoded so that its integrity
+// checks are verified before io.EOF is returned.
+func (t *TarReader) Next() (*tar.Header, error) {
+	header, err := t.Reader.Next()
+	if err == io.EOF {
+		if _, err := io.Copy(io.Discard, t.xr); err != nil {
+			return nil, err
+		}
+	}
+	return header, err
+}
+
+// Close frees the decoder like Reader.Close. It does not close the source.
+func (t *TarReader) Close() error {
+	return t.xr.Close()
+}
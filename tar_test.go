@@ -0,0 +1,112 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTarDir(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a.txt":           loremText,
+		"sub/b.txt":       "Hello\nWorld!\n",
+		"sub/empty.txt":   "",
+		"sub/skip.log":    "excluded by name",
+		"cache/c.txt":     "excluded with its directory",
+		"sub/deep/d.json": "{}",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := TarDir(&buf, root, 1, WithExcludeGlob("*.log"), WithExcludeGlob("cache")); err != nil {
+		t.Fatalf("TarDir() error = %v", err)
+	}
+	tr := OpenTar(bytes.NewReader(buf.Bytes()))
+	defer tr.Close()
+	got := map[string]string{}
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		names = append(names, header.Name)
+		if header.Linkname != "" {
+			got[header.Name] = "-> " + header.Linkname
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !header.FileInfo().IsDir() {
+			got[header.Name] = string(content)
+		}
+	}
+	want := map[string]string{
+		"a.txt":           loremText,
+		"link":            "-> a.txt",
+		"sub/b.txt":       "Hello\nWorld!\n",
+		"sub/empty.txt":   "",
+		"sub/deep/d.json": "{}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OpenTar() files = %v, want %v", got, want)
+	}
+	wantNames := []string{"a.txt", "link", "sub/", "sub/b.txt", "sub/deep/", "sub/deep/d.json", "sub/empty.txt"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("OpenTar() names = %v, want %v", names, wantNames)
+	}
+
+	if err := TarDir(io.Discard, filepath.Join(root, "missing"), 1); err == nil {
+		t.Error("TarDir() expected error for a missing root")
+	}
+	if err := TarDir(io.Discard, root, 1, WithExcludeGlob("[")); err == nil {
+		t.Error("TarDir() expected error for a malformed pattern")
+	}
+}
+
+func TestTarReader_Next_corrupt(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte(loremText), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := TarDir(&buf, root, 1); err != nil {
+		t.Fatalf("TarDir() error = %v", err)
+	}
+	// Corrupting the check of the block is only found once the end of the
+	// archive is reached.
+	input := buf.Bytes()
+	input[len(input)-40] ^= 0xff
+	tr := OpenTar(bytes.NewReader(input))
+	defer tr.Close()
+	var err error
+	for err == nil {
+		_, err = tr.Next()
+	}
+	if err == io.EOF {
+		t.Error("Next() error = EOF, want the corruption reported")
+	}
+}
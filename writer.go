@@ -0,0 +1,491 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"dill.foo/xz/lzma"
+)
+
+var (
+	// errWriterClosed is returned by Write once the Writer has been closed.
+	errWriterClosed = errors.New("writer is closed")
+	// errWriterAborted is returned by Write once the Writer has been aborted.
+	errWriterAborted = errors.New("writer is aborted")
+)
+
+// Writer compresses the data written to it as a single XZ stream.
+type Writer struct {
+	dst     io.Writer
+	stream  *lzma.Stream
+	buf     []byte
+	lastErr error
+
+	check         lzma.Check
+	blockSize     uint64
+	deterministic bool
+	// blockLeft counts down the input remaining in the current block when
+	// blocks are split by the Writer rather than by liblzma.
+	blockLeft uint64
+
+	// in and out count the bytes written by the caller and to dst.
+	in, out int64
+	// staged holds small writes until it is full, if created by
+	// NewWriterSize, so the encoder is run over larger inputs.
+	staged []byte
+
+	// Set when the Writer encodes blocks itself to store their sizes in the
+	// block headers. pending holds the input of the current block.
+	explicitSizes bool
+	block         *lzma.Block
+	index         *lzma.Index
+	pending       []byte
+	headerWritten bool
+}
+
+// A WriterOption configures a Writer.
+type WriterOption func(*Writer)
+
+// WithCheck sets the integrity check stored in the stream. The default is
+// lzma.CheckCRC64.
+func WithCheck(check lzma.Check) WriterOption {
+	return func(w *Writer) {
+		w.check = check
+	}
+}
+
+// WithBlockSize splits the stream into blocks holding size bytes of
+// uncompressed data. Smaller blocks allow more parallelism and finer seeking
+// at a small cost to the compression ratio. By default a single-threaded
+// Writer produces a single block, and a multithreaded Writer uses blocks three
+// times the dictionary size of the preset.
+func WithBlockSize(size uint64) WriterOption {
+	return func(w *Writer) {
+		if size == 0 {
+			w.lastErr = errors.New("block size must be nonzero")
+		}
+		w.blockSize = size
+	}
+}
+
+// WithExplicitSizes stores the Compressed Size and Uncompressed Size of every
+// block in its block header, for decoders that expect them. The Writer buffers
+// the input of each block until it is complete, so WithBlockSize should be used
+// to bound the memory held by the Writer; otherwise the whole input is a single
+// block. The multithreaded Writer always stores the sizes.
+func WithExplicitSizes() WriterOption {
+	return func(w *Writer) {
+		w.explicitSizes = true
+	}
+}
+
+// WithDeterministic makes the Writer produce byte-identical output for identical
+// input and settings, as needed for content-addressable storage. The .xz format
+// has no timestamps or other fields that vary between runs, and the output of a
+// single-threaded encoder depends only on the input, the preset, the check, the
+// block size and WithExplicitSizes. A multithreaded encoder also depends on
+// them, but as it may be built differently, a Writer of NewWriterThreads given
+// WithDeterministic encodes with a single thread. The output is not guaranteed
+// to be identical across liblzma versions, whose encoders may improve.
+func WithDeterministic() WriterOption {
+	return func(w *Writer) {
+		w.deterministic = true
+	}
+}
+
+// NewWriter creates a XZ encoder writer to the given destination using the
+// compression level preset, optionally combined with lzma.PresetExtreme. The
+// stream is only complete once the Writer is closed.
+func NewWriter(dst io.Writer, preset uint32, opts ...WriterOption) (*Writer, error) {
+	w, err := newWriter(dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	w.blockLeft = w.blockSize
+	if w.explicitSizes {
+		if err := w.initBlockEncoder(preset); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	if w.stream, err = lzma.NewEasyEncoder(preset, w.check); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWriterFilters creates a XZ encoder writer like NewWriter that encodes with
+// the given filter chain, such as lzma.DeltaFilter followed by LZMA2 with
+// lzma.LZMA2Options, rather than a preset. The chain holds at most
+// lzma.FiltersMax filters and must end with lzma.FilterLZMA2, as required by
+// the .xz format.
+func NewWriterFilters(dst io.Writer, filters []lzma.Filter, check lzma.Check) (*Writer, error) {
+	if len(filters) == 0 || filters[len(filters)-1].ID != lzma.FilterLZMA2 {
+		return nil, errors.New("filter chain must end with lzma.FilterLZMA2")
+	}
+	if len(filters) > lzma.FiltersMax {
+		return nil, fmt.Errorf("filter chain of %d filters exceeds the maximum of %d", len(filters), lzma.FiltersMax)
+	}
+	w, err := newWriter(dst, []WriterOption{WithCheck(check)})
+	if err != nil {
+		return nil, err
+	}
+	if w.stream, err = lzma.NewStreamEncoder(filters, check); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// initBlockEncoder prepares the Writer to encode each block itself.
+func (w *Writer) initBlockEncoder(preset uint32) error {
+	if !lzma.CheckIsSupported(w.check) {
+		return &Error{Code: lzma.UnsupportedCheck}
+	}
+	w.block = lzma.NewBlock()
+	if err := w.block.SetPreset(preset, w.check); err != nil {
+		_ = w.block.Close()
+		return err
+	}
+	var err error
+	if w.index, err = lzma.NewIndex(); err != nil {
+		_ = w.block.Close()
+		return err
+	}
+	return nil
+}
+
+// NewWriterSize creates a XZ encoder writer like NewWriter that buffers up to
+// bufSize bytes of input before compressing it, so that many small writes, such
+// as of single lines, do not each run the encoder. Writes of at least bufSize
+// bytes are compressed directly. Errors compressing buffered input are returned
+// by a later Write or by Close.
+func NewWriterSize(dst io.Writer, preset uint32, bufSize int, opts ...WriterOption) (*Writer, error) {
+	if bufSize <= 0 {
+		return nil, errors.New("buffer size must be positive")
+	}
+	w, err := NewWriter(dst, preset, opts...)
+	if err != nil {
+		return nil, err
+	}
+	w.staged = make([]byte, 0, bufSize)
+	return w, nil
+}
+
+// NewWriterThreads creates a XZ encoder writer like NewWriter that compresses
+// blocks in parallel using the given number of threads, or one per CPU if
+// threads is zero. With WithDeterministic, or if the linked liblzma does not
+// support threads as reported by lzma.HasThreadsSupport, it is the same as
+// NewWriter.
+func NewWriterThreads(dst io.Writer, preset uint32, threads int, opts ...WriterOption) (*Writer, error) {
+	w, err := newWriter(dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	if w.deterministic || !lzma.HasThreadsSupport() {
+		return NewWriter(dst, preset, opts...)
+	}
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	w.stream, err = lzma.NewStreamEncoderMT(
+		lzma.MTOptions{
+			Threads:   uint32(threads),
+			BlockSize: w.blockSize,
+			Preset:    preset,
+			Check:     w.check,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewAppendWriter creates a XZ encoder writer using the default preset that
+// writes a standalone stream, to be appended to a destination that already
+// holds complete .xz streams. Once the Writer is closed the destination decodes
+// as concatenated streams, with the appended data following the existing data.
+func NewAppendWriter(dst io.Writer, opts ...WriterOption) (*Writer, error) {
+	return NewWriter(dst, lzma.PresetDefault, opts...)
+}
+
+// NewLZMAWriter creates an encoder writer of the legacy .lzma format, for tools
+// that do not support .xz, using the compression level preset. The format has
+// no integrity check and the stream is only complete once the writer is
+// closed. An error creating the encoder is returned by Write and Close.
+func NewLZMAWriter(dst io.Writer, preset uint32) io.WriteCloser {
+	w, err := newWriter(dst, nil)
+	if err == nil {
+		w.stream, err = lzma.NewAloneEncoder(preset)
+	}
+	if err != nil {
+		return errWriteCloser{err}
+	}
+	return w
+}
+
+// NewMultiWriter creates a XZ encoder writer using the default preset that
+// compresses once and writes the same compressed data to every destination,
+// in order. Like io.MultiWriter, writing stops at the first destination that
+// fails, whose error is returned by Write or Close and ends compression. An
+// error creating the encoder is returned by Write and Close.
+func NewMultiWriter(dsts ...io.Writer) io.WriteCloser {
+	w, err := NewWriter(io.MultiWriter(dsts...), lzma.PresetDefault)
+	if err != nil {
+		return errWriteCloser{err}
+	}
+	return w
+}
+
+// errWriteCloser fails every call with err.
+type errWriteCloser struct {
+	err error
+}
+
+func (w errWriteCloser) Write([]byte) (int, error) { return 0, w.err }
+func (w errWriteCloser) Close() error              { return w.err }
+
+func newWriter(dst io.Writer, opts []WriterOption) (*Writer, error) {
+	w := &Writer{
+		dst:   dst,
+		buf:   make([]byte, defaultBufferSize),
+		check: lzma.CheckCRC64,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	// Options report invalid arguments through lastErr.
+	if w.lastErr != nil {
+		return nil, w.lastErr
+	}
+	return w, nil
+}
+
+// Write compresses p to the destination. Compressed data is buffered by the
+// encoder so not all of p may have been written to the destination on return.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.lastErr != nil {
+		return 0, w.lastErr
+	}
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if w.blockLeft > 0 && uint64(len(chunk)) > w.blockLeft {
+			chunk = chunk[:w.blockLeft]
+		}
+		if w.block != nil {
+			w.pending = append(w.pending, chunk...)
+		} else if err := w.stage(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		w.in += int64(len(chunk))
+		p = p[len(chunk):]
+		if w.blockLeft > 0 {
+			w.blockLeft -= uint64(len(chunk))
+			if w.blockLeft == 0 {
+				if err := w.endBlock(); err != nil {
+					return n, err
+				}
+				w.blockLeft = w.blockSize
+			}
+		}
+	}
+	return n, nil
+}
+
+// stage compresses in, first buffering it in staged if the Writer has one.
+func (w *Writer) stage(in []byte) error {
+	if cap(w.staged) == 0 || (len(w.staged) == 0 && len(in) >= cap(w.staged)) {
+		return w.code(in, lzma.Run)
+	}
+	for len(in) > 0 {
+		n := copy(w.staged[len(w.staged):cap(w.staged)], in)
+		w.staged = w.staged[:len(w.staged)+n]
+		in = in[n:]
+		if len(w.staged) == cap(w.staged) {
+			if err := w.flushStaged(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flushStaged compresses the input buffered in staged.
+func (w *Writer) flushStaged() error {
+	if len(w.staged) == 0 {
+		return nil
+	}
+	err := w.code(w.staged, lzma.Run)
+	w.staged = w.staged[:0]
+	return err
+}
+
+// code runs the encoder over in with the given action, writing all output to
+// the destination. Run returns once in has been consumed, other actions once
+// they have completed.
+func (w *Writer) code(in []byte, action lzma.Action) error {
+	w.stream.SetNextIn(in)
+	for {
+		w.stream.SetNextOut(w.buf)
+		ret := w.stream.Code(action)
+		if err := w.emit(w.buf[:len(w.buf)-w.stream.AvailableOut()]); err != nil {
+			return err
+		}
+		switch ret {
+		case lzma.Ok:
+			if action == lzma.Run && w.stream.AvailableIn() == 0 && w.stream.AvailableOut() > 0 {
+				return nil
+			}
+		case lzma.StreamEnd:
+			return nil
+		default:
+			return w.fail(&Error{Code: ret})
+		}
+	}
+}
+
+// emit writes p to the destination.
+func (w *Writer) emit(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	written, err := w.dst.Write(p)
+	w.out += int64(written)
+	if err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// endBlock completes the current block.
+func (w *Writer) endBlock() error {
+	if w.block == nil {
+		if err := w.flushStaged(); err != nil {
+			return err
+		}
+		return w.code(nil, lzma.FullFlush)
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if err := w.writeStreamHeader(); err != nil {
+		return err
+	}
+	out, err := lzma.BlockBufferEncode(w.block, w.pending)
+	if err != nil {
+		return w.fail(err)
+	}
+	if err := w.emit(out); err != nil {
+		return err
+	}
+	if err := w.index.AppendBlock(w.block.UnpaddedSize(), w.block.UncompressedSize()); err != nil {
+		return w.fail(err)
+	}
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// writeStreamHeader writes the stream header before the first block.
+func (w *Writer) writeStreamHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	header, ret := lzma.EncodeStreamHeader(lzma.StreamFlags{Check: w.check})
+	if ret != lzma.Ok {
+		return w.fail(&Error{Code: ret})
+	}
+	w.headerWritten = true
+	return w.emit(header)
+}
+
+// finishStream writes the last block, the Index and the stream footer.
+func (w *Writer) finishStream() error {
+	if err := w.endBlock(); err != nil {
+		return err
+	}
+	if err := w.writeStreamHeader(); err != nil {
+		return err
+	}
+	index := make([]byte, w.index.Size())
+	if _, err := lzma.IndexBufferEncode(w.index, index); err != nil {
+		return w.fail(err)
+	}
+	if err := w.emit(index); err != nil {
+		return err
+	}
+	footer, ret := lzma.EncodeStreamFooter(lzma.StreamFlags{Check: w.check, BackwardSize: uint64(len(index))})
+	if ret != lzma.Ok {
+		return w.fail(&Error{Code: ret})
+	}
+	return w.emit(footer)
+}
+
+// Stats returns the number of uncompressed bytes written to the Writer and of
+// compressed bytes written to the destination. Once the Writer has been closed
+// they are the sizes of the whole stream.
+func (w *Writer) Stats() (inBytes, outBytes int64) {
+	return w.in, w.out
+}
+
+// fail frees the encoder after an unrecoverable error.
+func (w *Writer) fail(err error) error {
+	w.lastErr = err
+	w.release()
+	return err
+}
+
+// release frees the encoder.
+func (w *Writer) release() {
+	if w.block != nil {
+		_ = w.block.Close()
+		_ = w.index.Close()
+		return
+	}
+	_ = w.stream.Close()
+}
+
+// Abort frees the encoder without completing the stream, such as when the
+// data being compressed turned out to be incomplete, so that the output cannot
+// be mistaken for a complete stream. Compressed data already written to the
+// destination is left as is and the buffered data is discarded. Write fails
+// and Close does nothing after Abort.
+func (w *Writer) Abort() error {
+	if w.lastErr != nil {
+		return nil
+	}
+	w.lastErr = errWriterAborted
+	w.release()
+	return nil
+}
+
+// Close completes the stream, writing any buffered data to the destination.
+// It does not close the destination. If an earlier Write failed, the stream
+// cannot be completed and Close returns the error of the Write. Closing a
+// closed or aborted Writer does nothing.
+func (w *Writer) Close() error {
+	if w.lastErr == errWriterClosed || w.lastErr == errWriterAborted {
+		return nil
+	}
+	if w.lastErr != nil {
+		return w.lastErr
+	}
+	var err error
+	if w.block != nil {
+		err = w.finishStream()
+	} else if err = w.flushStaged(); err == nil {
+		err = w.code(nil, lzma.Finish)
+	}
+	if err != nil {
+		return err
+	}
+	w.lastErr = errWriterClosed
+	w.release()
+	return nil
+}
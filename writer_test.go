@@ -0,0 +1,490 @@
+// Copyright 2024 Dillon Giacoppo
+// SPDX-License-Identifier: MIT
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"dill.foo/xz/lzma"
+)
+
+func TestWriter(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 1000)
+	tests := []struct {
+		name          string
+		newWriter     func(io.Writer) (*Writer, error)
+		wantBlocks    uint64
+		wantBlocksMax uint64
+	}{
+		{
+			name: "NewWriter",
+			newWriter: func(dst io.Writer) (*Writer, error) {
+				return NewWriter(dst, 1)
+			},
+			wantBlocks:    1,
+			wantBlocksMax: 1,
+		},
+		{
+			name: "NewWriter with block size",
+			newWriter: func(dst io.Writer) (*Writer, error) {
+				return NewWriter(dst, 1, WithBlockSize(64<<10))
+			},
+			wantBlocks:    uint64(len(input)) / (64 << 10),
+			wantBlocksMax: uint64(len(input))/(64<<10) + 1,
+		},
+		{
+			name: "NewWriterThreads with block size",
+			newWriter: func(dst io.Writer) (*Writer, error) {
+				return NewWriterThreads(dst, 1, 4, WithBlockSize(64<<10))
+			},
+			wantBlocks:    uint64(len(input)) / (64 << 10),
+			wantBlocksMax: uint64(len(input))/(64<<10) + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := tt.newWriter(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Write in uneven chunks to cross block boundaries mid-write.
+				for p := input; len(p) > 0; {
+					n := min(len(p), 10000)
+					if _, err := w.Write(p[:n]); err != nil {
+						t.Fatalf("Write() error = %v", err)
+					}
+					p = p[n:]
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("Close() error = %v", err)
+				}
+				got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if !bytes.Equal(got, input) {
+					t.Errorf("Read() got %d bytes, want %d", len(got), len(input))
+				}
+				index, _, err := decodeIndex(bytes.NewReader(buf.Bytes()))
+				if err != nil || index == nil {
+					t.Fatalf("decodeIndex() = %v, %v", index, err)
+				}
+				defer index.Close()
+				if blocks := index.BlockCount(); blocks < tt.wantBlocks || blocks > tt.wantBlocksMax {
+					t.Errorf("BlockCount() = %d, want %d to %d", blocks, tt.wantBlocks, tt.wantBlocksMax)
+				}
+			},
+		)
+	}
+}
+
+func TestWithBlockSize_zero(t *testing.T) {
+	if _, err := NewWriter(io.Discard, 1, WithBlockSize(0)); err == nil {
+		t.Error("NewWriter() expected error for zero block size")
+	}
+	if _, err := NewWriterThreads(io.Discard, 1, 2, WithBlockSize(0)); err == nil {
+		t.Error("NewWriterThreads() expected error for zero block size")
+	}
+}
+
+func TestWriter_Close_writeError(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 1000)
+	wantErr := errors.New("disk full")
+	for _, opts := range [][]WriterOption{nil, {WithExplicitSizes(), WithBlockSize(64 << 10)}} {
+		pr, pw := io.Pipe()
+		_ = pr.CloseWithError(wantErr)
+		w, err := NewWriter(pw, lzma.PresetDefault, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != wantErr {
+			t.Fatalf("Write() error = %v, want %v", err, wantErr)
+		}
+		if err := w.Close(); err != wantErr {
+			t.Errorf("Close() error = %v, want %v", err, wantErr)
+		}
+		if err := w.Close(); err != wantErr {
+			t.Errorf("Close() again error = %v, want %v", err, wantErr)
+		}
+	}
+}
+
+func TestWithExplicitSizes(t *testing.T) {
+	for _, size := range []int{0, 13, 100 * len(loremText)} {
+		input := bytes.Repeat([]byte(loremText), 100)[:size]
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, lzma.PresetDefault, WithExplicitSizes(), WithBlockSize(1000), WithCheck(lzma.CheckSHA256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if _, out := w.Stats(); out != int64(buf.Len()) {
+			t.Errorf("Stats() outBytes = %d, want %d", out, buf.Len())
+		}
+
+		var blocks []BlockInfo
+		got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes()), WithBlockCallback(func(info BlockInfo) { blocks = append(blocks, info) })))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("Read() got %d bytes, want %d", len(got), len(input))
+		}
+		if want := (size + 999) / 1000; len(blocks) != want {
+			t.Errorf("decoded %d blocks, want %d", len(blocks), want)
+		}
+		for _, info := range blocks {
+			block := lzma.NewBlock()
+			header := buf.Bytes()[info.Offset : info.Offset+int64(info.HeaderSize)]
+			if ret := block.DecodeHeader(header, lzma.CheckSHA256); ret != lzma.Ok {
+				t.Fatalf("DecodeHeader() = %v", ret)
+			}
+			if got := block.CompressedSize(); got != uint64(info.CompressedSize) {
+				t.Errorf("header Compressed Size = %d, want %d", got, info.CompressedSize)
+			}
+			if got := block.UncompressedSize(); got != uint64(info.UncompressedSize) {
+				t.Errorf("header Uncompressed Size = %d, want %d", got, info.UncompressedSize)
+			}
+			_ = block.Close()
+		}
+	}
+}
+
+func TestWriter_Abort(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 1000)
+	for _, opts := range [][]WriterOption{nil, {WithExplicitSizes(), WithBlockSize(64 << 10)}} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, lzma.PresetDefault, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := w.Abort(); err != nil {
+			t.Fatalf("Abort() error = %v", err)
+		}
+		if _, err := w.Write(input); err == nil {
+			t.Error("Write() after Abort() expected error")
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("Close() after Abort() error = %v", err)
+		}
+		if buf.Len() == 0 {
+			t.Fatal("nothing written before Abort()")
+		}
+		if _, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes()))); err == nil {
+			t.Error("Read() of aborted output expected error")
+		}
+	}
+}
+
+func TestNewWriterSize(t *testing.T) {
+	lines := strings.SplitAfter(strings.Repeat(loremText, 50), "\n")
+	for _, opts := range [][]WriterOption{nil, {WithBlockSize(1000)}} {
+		var buf bytes.Buffer
+		w, err := NewWriterSize(&buf, lzma.PresetDefault, 4096, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if _, err := io.WriteString(w, line); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+		// A write larger than the buffer is compressed directly.
+		if _, err := w.Write(bytes.Repeat([]byte(loremText), 20)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if want := strings.Repeat(loremText, 70); string(got) != want {
+			t.Errorf("Read() got %d bytes, want %d", len(got), len(want))
+		}
+	}
+	if _, err := NewWriterSize(io.Discard, lzma.PresetDefault, 0); err == nil {
+		t.Error("NewWriterSize() expected error for zero buffer size")
+	}
+}
+
+// BenchmarkWriter_lines compresses many short lines, each written separately.
+func BenchmarkWriter_lines(b *testing.B) {
+	line := []byte("2024-01-01T00:00:00Z INFO request served in 12ms\n")
+	benchmarks := []struct {
+		name      string
+		newWriter func(io.Writer) (*Writer, error)
+	}{
+		{name: "NewWriter", newWriter: func(dst io.Writer) (*Writer, error) { return NewWriter(dst, 1) }},
+		{
+			name:      "NewWriterSize",
+			newWriter: func(dst io.Writer) (*Writer, error) { return NewWriterSize(dst, 1, defaultBufferSize) },
+		},
+	}
+	for _, bb := range benchmarks {
+		b.Run(
+			bb.name, func(b *testing.B) {
+				b.SetBytes(int64(len(line)) * 100_000)
+				for i := 0; i < b.N; i++ {
+					w, err := bb.newWriter(io.Discard)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for j := 0; j < 100_000; j++ {
+						if _, err := w.Write(line); err != nil {
+							b.Fatal(err)
+						}
+					}
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestNewAppendWriter(t *testing.T) {
+	payloads := []string{loremText, "Hello\nWorld!\n"}
+	var buf bytes.Buffer
+	for _, payload := range payloads {
+		w, err := NewAppendWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, payload); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+	got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if want := strings.Join(payloads, ""); string(got) != want {
+		t.Errorf("Read() got = '%v', want %v", string(got), want)
+	}
+	first, err := io.ReadAll(NewSingleStreamReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(first) != payloads[0] {
+		t.Errorf("NewSingleStreamReader() got = '%v', want %v", string(first), payloads[0])
+	}
+}
+
+func TestNewMultiWriter(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 100)
+	dsts := make([]bytes.Buffer, 3)
+	w := NewMultiWriter(&dsts[0], &dsts[1], &dsts[2])
+	if _, err := w.Write(input); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for i := range dsts {
+		if !bytes.Equal(dsts[i].Bytes(), dsts[0].Bytes()) {
+			t.Errorf("destination %d differs from destination 0", i)
+		}
+		got, err := io.ReadAll(NewReader(bytes.NewReader(dsts[i].Bytes())))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("destination %d decoded %d bytes, want %d", i, len(got), len(input))
+		}
+	}
+
+	wantErr := errors.New("destination failed")
+	var buf bytes.Buffer
+	w = NewMultiWriter(&buf, errWriter{wantErr})
+	_, err := w.Write(input)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != wantErr {
+		t.Errorf("Close() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestNewLZMAWriter(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 100)
+	var buf bytes.Buffer
+	w := NewLZMAWriter(&buf, lzma.PresetDefault)
+	if _, err := w.Write(input); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if buf.Len() < 13 {
+		t.Fatalf("NewLZMAWriter() wrote %d bytes, want at least the 13 byte header", buf.Len())
+	}
+	got, err := io.ReadAll(NewLZMAReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("Read() got %d bytes, want %d", len(got), len(input))
+	}
+
+	path, err := exec.LookPath("xz")
+	if err != nil {
+		t.Skip("xz not installed")
+	}
+	cmd := exec.Command(path, "--format=lzma", "--decompress", "--stdout")
+	cmd.Stdin = bytes.NewReader(buf.Bytes())
+	got, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("xz --decompress error = %v", err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("xz --decompress got %d bytes, want %d", len(got), len(input))
+	}
+}
+
+func TestNewLZMAWriter_invalidPreset(t *testing.T) {
+	w := NewLZMAWriter(io.Discard, 10)
+	if _, err := w.Write([]byte(loremText)); err == nil {
+		t.Error("Write() expected error for invalid preset")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close() expected error for invalid preset")
+	}
+}
+
+func TestWriter_Stats(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 100)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, lzma.PresetDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := input; len(p) > 0; p = p[min(len(p), 1000):] {
+		if _, err := w.Write(p[:min(len(p), 1000)]); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	inBytes, outBytes := w.Stats()
+	if inBytes != int64(len(input)) || outBytes != int64(buf.Len()) {
+		t.Errorf("Stats() = %d, %d, want %d, %d", inBytes, outBytes, len(input), buf.Len())
+	}
+	if outBytes >= inBytes {
+		t.Errorf("Stats() compressed %d bytes to %d", inBytes, outBytes)
+	}
+}
+
+func TestWithDeterministic(t *testing.T) {
+	input := bytes.Repeat([]byte(loremText), 100)
+	compress := func(newWriter func(io.Writer) (*Writer, error), chunk int) []byte {
+		var buf bytes.Buffer
+		w, err := newWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := input; len(p) > 0; p = p[min(len(p), chunk):] {
+			if _, err := w.Write(p[:min(len(p), chunk)]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+	want := compress(
+		func(dst io.Writer) (*Writer, error) {
+			return NewWriter(dst, lzma.PresetDefault, WithBlockSize(1000), WithDeterministic())
+		}, len(input),
+	)
+	for _, chunk := range []int{1, 7, 4096} {
+		got := compress(
+			func(dst io.Writer) (*Writer, error) {
+				return NewWriterThreads(dst, lzma.PresetDefault, 4, WithBlockSize(1000), WithDeterministic())
+			}, chunk,
+		)
+		if !bytes.Equal(got, want) {
+			t.Errorf("written in chunks of %d got %d bytes differing from %d bytes", chunk, len(got), len(want))
+		}
+	}
+}
+
+func TestNewWriterFilters(t *testing.T) {
+	// A ramp of slowly increasing steps, whose differences are far more
+	// repetitive than the bytes themselves.
+	input := make([]byte, 1<<16)
+	for i := range input {
+		input[i] = byte(i * i >> 10)
+	}
+	options, err := lzma.PresetOptions(lzma.PresetDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compress := func(filters []lzma.Filter) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriterFilters(&buf, filters, lzma.CheckCRC32)
+		if err != nil {
+			t.Fatalf("NewWriterFilters() error = %v", err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		got, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("Read() got %d bytes differing from %d bytes", len(got), len(input))
+		}
+		return buf.Bytes()
+	}
+	lzma2 := lzma.Filter{ID: lzma.FilterLZMA2, Options: options}
+	plain := compress([]lzma.Filter{lzma2})
+	delta := compress([]lzma.Filter{lzma.DeltaFilter(1), lzma2})
+	if len(delta) >= len(plain) {
+		t.Errorf("delta compressed to %d bytes, want fewer than %d of LZMA2 alone", len(delta), len(plain))
+	}
+
+	invalid := []struct {
+		name    string
+		filters []lzma.Filter
+	}{
+		{name: "empty"},
+		{name: "not LZMA2 last", filters: []lzma.Filter{lzma2, lzma.DeltaFilter(1)}},
+		{name: "too long", filters: []lzma.Filter{lzma.DeltaFilter(1), lzma.DeltaFilter(2), lzma.DeltaFilter(3), lzma.DeltaFilter(4), lzma2}},
+		{name: "invalid distance", filters: []lzma.Filter{lzma.DeltaFilter(257), lzma2}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWriterFilters(io.Discard, tt.filters, lzma.CheckCRC32); err == nil {
+				t.Error("NewWriterFilters() expected error")
+			}
+		})
+	}
+}